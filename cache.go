@@ -0,0 +1,275 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is an interface describing a store for the results of
+// Runner.Run, used by the WithCache option.  Implementations must be
+// safe for use from multiple goroutines.  The package provides an
+// in-memory implementation, returned by NewLRUCache, and an on-disk
+// implementation, returned by NewDiskCache.
+type Cache interface {
+	// Get looks up the result stored under a key.  It returns the
+	// result and a boolean value that is true if the result was
+	// found.
+	Get(key interface{}) (interface{}, bool)
+
+	// Put stores a result under a key.
+	Put(key interface{}, value interface{})
+}
+
+// lruEntry is an entry in the lruCache.
+type lruEntry struct {
+	key     interface{} // The key of the entry
+	value   interface{} // The cached value
+	expires time.Time   // When the entry expires; zero for never
+}
+
+// lruCache is an implementation of Cache that keeps a bounded number
+// of results in memory, discarding the least recently used.
+type lruCache struct {
+	sync.Mutex
+	size    int                           // Maximum number of entries
+	ttl     time.Duration                 // How long entries are valid
+	order   *list.List                    // Entries, most recent first
+	entries map[interface{}]*list.Element // Entries by key
+	now     func() time.Time              // Source of the current time
+}
+
+// NewLRUCache constructs an in-memory Cache holding at most the
+// specified number of results; if that number is less than or equal
+// to 0, the number of results is not limited.  If the ttl is greater
+// than 0, results expire that long after they are stored.
+func NewLRUCache(size int, ttl time.Duration) Cache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   &list.List{},
+		entries: map[interface{}]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+// Get looks up the result stored under a key.  It returns the result
+// and a boolean value that is true if the result was found.
+func (c *lruCache) Get(key interface{}) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	// Look up the entry
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+
+	// Has it expired?
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	// Mark it recently used
+	c.order.MoveToFront(elem)
+
+	return entry.value, true
+}
+
+// Put stores a result under a key.
+func (c *lruCache) Put(key interface{}, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	// Construct the entry
+	entry := &lruEntry{
+		key:   key,
+		value: value,
+	}
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+
+	// Replace any existing entry
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+
+	// Discard the least recently used entries
+	for c.size > 0 && c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*lruEntry).key)
+	}
+}
+
+// diskCache is an implementation of Cache that stores results in
+// files in a local directory.
+type diskCache struct {
+	dir   string           // The directory to store results in
+	codec Codec            // The codec for encoding results
+	ttl   time.Duration    // How long entries are valid
+	now   func() time.Time // Source of the current time
+}
+
+// NewDiskCache constructs a Cache that stores results in files in the
+// specified directory, which will be created if it does not exist.
+// The results are encoded using the specified Codec.  Keys are
+// rendered with fmt's "%#v" verb and hashed to produce file names, so
+// keys that render identically are considered equal.  If the ttl is
+// greater than 0, results expire that long after they are stored.
+// Errors encountered reading or writing results are treated as cache
+// misses.
+func NewDiskCache(dir string, codec Codec, ttl time.Duration) (Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &diskCache{
+		dir:   dir,
+		codec: codec,
+		ttl:   ttl,
+		now:   time.Now,
+	}, nil
+}
+
+// path computes the path of the file storing the result for a key.
+func (c *diskCache) path(key interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", key)))
+
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get looks up the result stored under a key.  It returns the result
+// and a boolean value that is true if the result was found.
+func (c *diskCache) Get(key interface{}) (interface{}, bool) {
+	path := c.path(key)
+
+	// Check whether the entry has expired
+	if c.ttl > 0 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, false
+		}
+		if !c.now().Before(info.ModTime().Add(c.ttl)) {
+			os.Remove(path)
+			return nil, false
+		}
+	}
+
+	// Read and decode the result
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	value, err := c.codec.Decode(data)
+	if err != nil {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Put stores a result under a key.
+func (c *diskCache) Put(key interface{}, value interface{}) {
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file and rename it into place, so
+	// readers never see a partial result
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// memoizer implements the WithCache option for the workers.
+type memoizer struct {
+	hits  int64   // Number of cache hits; must be first for atomic access
+	cache Cache   // The cache to use
+	key   KeyFunc // Key function
+}
+
+// newMemoizer constructs a memoizer from the options, or returns nil
+// if caching was not requested.
+func newMemoizer(opts *options) *memoizer {
+	if opts.cache == nil {
+		return nil
+	}
+
+	return &memoizer{
+		cache: opts.cache,
+		key:   opts.cacheKey,
+	}
+}
+
+// get computes the key for a data item and looks up its result in the
+// cache.  It returns the key and, if the result was found, a Result
+// with the Cached flag set.
+func (m *memoizer) get(data interface{}) (interface{}, *Result) {
+	key := m.key(data)
+
+	value, ok := m.cache.Get(key)
+	if !ok {
+		return key, nil
+	}
+	atomic.AddInt64(&m.hits, 1)
+
+	return key, &Result{
+		Result: value,
+		Cached: true,
+	}
+}
+
+// put stores a result in the cache.  Results of calls to Runner.Run
+// that panicked are not cached.
+func (m *memoizer) put(key interface{}, result *Result) {
+	if result.Panic == nil {
+		m.cache.Put(key, result.Result)
+	}
+}
+
+// count returns the number of cache hits.
+func (m *memoizer) count() int64 {
+	return atomic.LoadInt64(&m.hits)
+}
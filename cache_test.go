@@ -0,0 +1,320 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCacheImplementsCache(t *testing.T) {
+	assert.Implements(t, (*Cache)(nil), &lruCache{})
+}
+
+func TestNewLRUCache(t *testing.T) {
+	result := NewLRUCache(5, time.Second)
+
+	obj, ok := result.(*lruCache)
+	require.True(t, ok)
+	assert.Equal(t, 5, obj.size)
+	assert.Equal(t, time.Second, obj.ttl)
+	assert.Equal(t, 0, obj.order.Len())
+	assert.NotNil(t, obj.entries)
+	assert.NotNil(t, obj.now)
+}
+
+func TestLRUCacheGetMissing(t *testing.T) {
+	obj := NewLRUCache(5, 0)
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestLRUCachePutGet(t *testing.T) {
+	obj := NewLRUCache(5, 0)
+	obj.Put("key", "value")
+
+	result, ok := obj.Get("key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "value", result)
+}
+
+func TestLRUCachePutReplaces(t *testing.T) {
+	obj := NewLRUCache(5, 0)
+	obj.Put("key", "value1")
+
+	obj.Put("key", "value2")
+
+	result, ok := obj.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "value2", result)
+	assert.Equal(t, 1, obj.(*lruCache).order.Len())
+}
+
+func TestLRUCacheEvicts(t *testing.T) {
+	obj := NewLRUCache(2, 0)
+	obj.Put("key1", "value1")
+	obj.Put("key2", "value2")
+	obj.Get("key1")
+
+	obj.Put("key3", "value3")
+
+	_, ok := obj.Get("key2")
+	assert.False(t, ok)
+	_, ok = obj.Get("key1")
+	assert.True(t, ok)
+	_, ok = obj.Get("key3")
+	assert.True(t, ok)
+}
+
+func TestLRUCacheUnlimited(t *testing.T) {
+	obj := NewLRUCache(0, 0)
+
+	for i := 0; i < 100; i++ {
+		obj.Put(i, i)
+	}
+
+	assert.Equal(t, 100, obj.(*lruCache).order.Len())
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	now := time.Now()
+	obj := NewLRUCache(5, time.Second).(*lruCache)
+	obj.now = func() time.Time { return now }
+	obj.Put("key", "value")
+	now = now.Add(time.Second)
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+	assert.Equal(t, 0, obj.order.Len())
+	assert.NotContains(t, obj.entries, "key")
+}
+
+func TestDiskCacheImplementsCache(t *testing.T) {
+	assert.Implements(t, (*Cache)(nil), &diskCache{})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "parallelizer")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func TestNewDiskCacheBase(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "cache")
+	codec := JSONCodec{}
+
+	result, err := NewDiskCache(dir, codec, time.Second)
+
+	assert.NoError(t, err)
+	assert.Equal(t, dir, result.(*diskCache).dir)
+	assert.Equal(t, codec, result.(*diskCache).codec)
+	assert.Equal(t, time.Second, result.(*diskCache).ttl)
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestNewDiskCacheError(t *testing.T) {
+	file := filepath.Join(tempDir(t), "file")
+	require.NoError(t, ioutil.WriteFile(file, []byte{}, 0644))
+
+	result, err := NewDiskCache(filepath.Join(file, "cache"), JSONCodec{}, 0)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestDiskCachePath(t *testing.T) {
+	obj := &diskCache{dir: "dir"}
+
+	result1 := obj.path("key")
+	result2 := obj.path(1)
+
+	assert.Equal(t, "dir", filepath.Dir(result1))
+	assert.Len(t, filepath.Base(result1), 64)
+	assert.NotEqual(t, result1, result2)
+	assert.Equal(t, result1, obj.path("key"))
+}
+
+func TestDiskCachePutGet(t *testing.T) {
+	obj, err := NewDiskCache(tempDir(t), GobCodec{}, 0)
+	require.NoError(t, err)
+	obj.Put("key", "value")
+
+	result, ok := obj.Get("key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "value", result)
+}
+
+func TestDiskCacheGetMissing(t *testing.T) {
+	obj, err := NewDiskCache(tempDir(t), GobCodec{}, 0)
+	require.NoError(t, err)
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestDiskCacheGetMissingTTL(t *testing.T) {
+	obj, err := NewDiskCache(tempDir(t), GobCodec{}, time.Second)
+	require.NoError(t, err)
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestDiskCacheGetUnexpired(t *testing.T) {
+	obj, err := NewDiskCache(tempDir(t), GobCodec{}, time.Hour)
+	require.NoError(t, err)
+	obj.Put("key", "value")
+
+	result, ok := obj.Get("key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "value", result)
+}
+
+func TestDiskCacheGetExpired(t *testing.T) {
+	dc, err := NewDiskCache(tempDir(t), GobCodec{}, time.Second)
+	require.NoError(t, err)
+	obj := dc.(*diskCache)
+	obj.Put("key", "value")
+	obj.now = func() time.Time { return time.Now().Add(time.Minute) }
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+	_, err = os.Stat(obj.path("key"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskCacheGetDecodeError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", "value").Return([]byte("data"), nil)
+	codec.On("Decode", []byte("data")).Return(nil, assert.AnError)
+	obj, err := NewDiskCache(tempDir(t), codec, 0)
+	require.NoError(t, err)
+	obj.Put("key", "value")
+
+	result, ok := obj.Get("key")
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+	codec.AssertExpectations(t)
+}
+
+func TestDiskCachePutEncodeError(t *testing.T) {
+	dir := tempDir(t)
+	codec := &MockCodec{}
+	codec.On("Encode", "value").Return(nil, assert.AnError)
+	obj, err := NewDiskCache(dir, codec, 0)
+	require.NoError(t, err)
+
+	obj.Put("key", "value")
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 0)
+	codec.AssertExpectations(t)
+}
+
+func TestNewMemoizerDisabled(t *testing.T) {
+	result := newMemoizer(&options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewMemoizerEnabled(t *testing.T) {
+	cache := &MockCache{}
+
+	result := newMemoizer(&options{
+		cache:    cache,
+		cacheKey: identityKey,
+	})
+
+	require.NotNil(t, result)
+	assert.Same(t, cache, result.cache)
+	assert.NotNil(t, result.key)
+}
+
+func TestMemoizerGetHit(t *testing.T) {
+	cache := &MockCache{}
+	cache.On("Get", "data").Return("value", true)
+	obj := &memoizer{
+		cache: cache,
+		key:   identityKey,
+	}
+
+	key, result := obj.get("data")
+
+	assert.Equal(t, "data", key)
+	assert.Equal(t, &Result{Result: "value", Cached: true}, result)
+	assert.Equal(t, int64(1), obj.count())
+	cache.AssertExpectations(t)
+}
+
+func TestMemoizerGetMiss(t *testing.T) {
+	cache := &MockCache{}
+	cache.On("Get", "data").Return(nil, false)
+	obj := &memoizer{
+		cache: cache,
+		key:   identityKey,
+	}
+
+	key, result := obj.get("data")
+
+	assert.Equal(t, "data", key)
+	assert.Nil(t, result)
+	assert.Equal(t, int64(0), obj.count())
+	cache.AssertExpectations(t)
+}
+
+func TestMemoizerPutBase(t *testing.T) {
+	cache := &MockCache{}
+	cache.On("Put", "key", "value")
+	obj := &memoizer{cache: cache}
+
+	obj.put("key", &Result{Result: "value"})
+
+	cache.AssertExpectations(t)
+}
+
+func TestMemoizerPutPanic(t *testing.T) {
+	cache := &MockCache{}
+	obj := &memoizer{cache: cache}
+
+	obj.put("key", &Result{Panic: "panic"})
+
+	cache.AssertExpectations(t)
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default interval between checkpoints.
+const (
+	defaultCheckpointInterval = time.Minute
+)
+
+// ErrNoCheckpoint is returned by CheckpointStore.Load if no checkpoint
+// has been saved.
+var ErrNoCheckpoint = errors.New("No checkpoint has been saved")
+
+// ErrNoCodec is returned by ResumeGoWorker if no Codec is specified.
+var ErrNoCodec = errors.New("No codec was specified")
+
+// CheckpointStore is an interface describing a place to persist
+// checkpoints, used by the WithCheckpoint option and by
+// ResumeGoWorker.  The package provides an implementation that stores
+// checkpoints in a local file, returned by NewFileCheckpointStore.
+type CheckpointStore interface {
+	// Save saves a checkpoint, replacing any previously saved
+	// checkpoint.
+	Save(data []byte) error
+
+	// Load loads the most recently saved checkpoint.  If no
+	// checkpoint has been saved, it returns ErrNoCheckpoint.
+	Load() ([]byte, error)
+}
+
+// Snapshotter is an interface that may optionally be implemented by a
+// Runner to allow the state accumulated by Runner.Integrate to be
+// saved in checkpoints and restored by ResumeGoWorker.
+type Snapshotter interface {
+	// Snapshot returns the current integrated state.  It is
+	// called with Runner.Integrate calls locked out, and the
+	// returned value is encoded before any further calls to
+	// Runner.Integrate are made.  For a ShardedRunner, calls to
+	// ShardedRunner.IntegrateShard are locked out instead, and
+	// the shards are merged before Snapshot is called.
+	Snapshot() interface{}
+
+	// Restore restores the integrated state from a value
+	// previously returned by Snapshot and passed through the
+	// Codec.  It is called before any data items are submitted.
+	Restore(state interface{})
+}
+
+// fileCheckpointStore is an implementation of CheckpointStore that
+// stores the checkpoint in a local file.
+type fileCheckpointStore struct {
+	path string // Path to the checkpoint file
+}
+
+// NewFileCheckpointStore constructs a CheckpointStore that stores the
+// checkpoint in the specified file.  The file is replaced atomically,
+// so a crash while saving a checkpoint leaves the previous checkpoint
+// intact.
+func NewFileCheckpointStore(path string) CheckpointStore {
+	return &fileCheckpointStore{path: path}
+}
+
+// Save saves a checkpoint, replacing any previously saved checkpoint.
+func (s *fileCheckpointStore) Save(data []byte) error {
+	// Write to a temporary file, then rename it into place
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// Load loads the most recently saved checkpoint.  If no checkpoint
+// has been saved, it returns ErrNoCheckpoint.
+func (s *fileCheckpointStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoCheckpoint
+	}
+
+	return data, err
+}
+
+// checkpointData is the representation of a checkpoint.  The state
+// and items are encoded with the user's Codec.
+type checkpointData struct {
+	State []byte   `json:"state,omitempty"` // Encoded integrated state
+	Items [][]byte `json:"items"`           // Encoded pending data items
+}
+
+// checkpointer implements the WithCheckpoint option.  It tracks the
+// data items that have been accepted but not yet integrated, and
+// periodically saves them, along with the integrated state, to the
+// CheckpointStore.
+type checkpointer struct {
+	sync.Mutex
+	store    CheckpointStore        // Where to save checkpoints
+	codec    Codec                  // Codec for items and state
+	interval time.Duration          // Interval between checkpoints
+	nextID   uint64                 // ID for the next item
+	pending  map[uint64]interface{} // Pending data items
+	stop     chan struct{}          // Closed to stop the saver
+	done     chan struct{}          // Closed when the saver exits
+}
+
+// newCheckpointer constructs a checkpointer from the options, or
+// returns nil if checkpointing was not requested.
+func newCheckpointer(opts *options) *checkpointer {
+	if opts.checkpointStore == nil {
+		return nil
+	}
+
+	interval := opts.checkpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	return &checkpointer{
+		store:    opts.checkpointStore,
+		codec:    opts.checkpointCodec,
+		interval: interval,
+		pending:  map[uint64]interface{}{},
+	}
+}
+
+// add records a work item as pending.
+func (c *checkpointer) add(item *workItem) {
+	c.Lock()
+	defer c.Unlock()
+
+	item.id = c.nextID
+	c.nextID++
+	c.pending[item.id] = item.data
+}
+
+// remove records that a work item has been integrated.
+func (c *checkpointer) remove(item *workItem) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.pending, item.id)
+}
+
+// encode encodes a checkpoint.  The caller must ensure that no
+// Runner.Integrate calls are in progress.
+func (c *checkpointer) encode(runner Runner) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	ckpt := &checkpointData{Items: make([][]byte, 0, len(c.pending))}
+
+	// Encode the integrated state
+	if snap, ok := runner.(Snapshotter); ok {
+		state, err := c.codec.Encode(snap.Snapshot())
+		if err != nil {
+			return nil, err
+		}
+		ckpt.State = state
+	}
+
+	// Encode the pending items in the order they were submitted
+	ids := make([]uint64, 0, len(c.pending))
+	for id := range c.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		item, err := c.codec.Encode(c.pending[id])
+		if err != nil {
+			return nil, err
+		}
+		ckpt.Items = append(ckpt.Items, item)
+	}
+
+	return json.Marshal(ckpt)
+}
+
+// save saves a checkpoint.  The serial lock is the lock used to
+// serialize calls to Runner.Integrate.
+func (c *checkpointer) save(serial sync.Locker, runner Runner) error {
+	serial.Lock()
+	data, err := c.encode(runner)
+	serial.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.store.Save(data)
+}
+
+// saver is the goroutine that periodically saves checkpoints.  Errors
+// are ignored, since the checkpoint will be retried on the next tick;
+// the error from the final checkpoint is reported by finish.
+func (c *checkpointer) saver(serial sync.Locker, runner Runner) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.save(serial, runner)
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// start starts the goroutine that periodically saves checkpoints.
+func (c *checkpointer) start(serial sync.Locker, runner Runner) {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go c.saver(serial, runner)
+}
+
+// finish stops the goroutine that periodically saves checkpoints, then
+// saves a final checkpoint.
+func (c *checkpointer) finish(serial sync.Locker, runner Runner) error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+	}
+
+	return c.save(serial, runner)
+}
+
+// loadCheckpoint loads a checkpoint from the store and decodes it,
+// returning the integrated state, if any, and the pending data items.
+func loadCheckpoint(store CheckpointStore, codec Codec) (interface{}, bool, []interface{}, error) {
+	data, err := store.Load()
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	ckpt := &checkpointData{}
+	if err := json.Unmarshal(data, ckpt); err != nil {
+		return nil, false, nil, err
+	}
+
+	// Decode the state
+	var state interface{}
+	if ckpt.State != nil {
+		if state, err = codec.Decode(ckpt.State); err != nil {
+			return nil, false, nil, err
+		}
+	}
+
+	// Decode the items
+	items := make([]interface{}, len(ckpt.Items))
+	for i, item := range ckpt.Items {
+		if items[i], err = codec.Decode(item); err != nil {
+			return nil, false, nil, err
+		}
+	}
+
+	return state, ckpt.State != nil, items, nil
+}
+
+// ResumeGoWorker constructs a worker like NewGoWorker, but resumes the
+// work recorded in the most recent checkpoint saved in the store.  If
+// the Runner implements Snapshotter, its integrated state is restored
+// from the checkpoint; then the data items that had not been
+// integrated when the checkpoint was saved are submitted to the new
+// worker.  If no checkpoint has been saved, the new worker is simply
+// returned.  If an item cannot be resubmitted, the new worker is
+// waited on, then the error is returned.  To continue saving
+// checkpoints, pass the WithCheckpoint option.  The options supported
+// are those of NewGoWorker; passing any other returns an error
+// wrapping ErrUnsupportedOption.
+func ResumeGoWorker(runner Runner, workers int, store CheckpointStore, codec Codec, opts ...Option) (Worker, error) {
+	if codec == nil {
+		return nil, ErrNoCodec
+	}
+	o := newOptions(opts)
+	if err := o.check("ResumeGoWorker", goOptions); err != nil {
+		return nil, err
+	}
+
+	// Load the checkpoint
+	state, hasState, items, err := loadCheckpoint(store, codec)
+	if err != nil && err != ErrNoCheckpoint {
+		return nil, err
+	}
+
+	// Restore the integrated state
+	if snap, ok := runner.(Snapshotter); ok && hasState {
+		snap.Restore(state)
+	}
+
+	// Construct the worker and resubmit the items
+	worker := newGoWorker(runner, workers, o)
+	if err := resubmit(worker, items); err != nil {
+		return nil, err
+	}
+
+	return worker, nil
+}
+
+// resubmit is a helper for ResumeGoWorker that submits the pending
+// data items to the worker.  If an item cannot be submitted, the
+// worker is waited on, so that it does not leak, and the error is
+// returned.
+func resubmit(worker Worker, items []interface{}) error {
+	for _, item := range items {
+		if err := worker.Call(item); err != nil {
+			worker.Wait()
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,529 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sumRunner is a Runner implementing Snapshotter that sums the
+// numbers submitted to it.  If gate is non-nil, Run blocks on it for
+// numbers greater than 100.
+type sumRunner struct {
+	sum  float64
+	gate chan struct{}
+}
+
+func (r *sumRunner) Run(data interface{}) interface{} {
+	n := data.(float64)
+	if r.gate != nil && n > 100 {
+		<-r.gate
+	}
+	return n
+}
+
+func (r *sumRunner) Integrate(worker Worker, result *Result) {
+	r.sum += result.Result.(float64)
+}
+
+func (r *sumRunner) Result() interface{} {
+	return r.sum
+}
+
+func (r *sumRunner) Snapshot() interface{} {
+	return r.sum
+}
+
+func (r *sumRunner) Restore(state interface{}) {
+	r.sum = state.(float64)
+}
+
+// shardSumRunner is a sumRunner that also implements ShardedRunner.
+type shardSumRunner struct {
+	sumRunner
+}
+
+func (r *shardSumRunner) NewShard() interface{} {
+	return new(float64)
+}
+
+func (r *shardSumRunner) IntegrateShard(worker Worker, shard interface{}, result *Result) {
+	*shard.(*float64) += result.Result.(float64)
+}
+
+func (r *shardSumRunner) Merge(shards []interface{}) {
+	for _, shard := range shards {
+		r.sum += *shard.(*float64)
+	}
+}
+
+func TestFileCheckpointStoreImplementsCheckpointStore(t *testing.T) {
+	assert.Implements(t, (*CheckpointStore)(nil), &fileCheckpointStore{})
+}
+
+func TestNewFileCheckpointStore(t *testing.T) {
+	result := NewFileCheckpointStore("path")
+
+	assert.Equal(t, &fileCheckpointStore{path: "path"}, result)
+}
+
+func TestFileCheckpointStoreSaveLoad(t *testing.T) {
+	path := filepath.Join(tempDir(t), "ckpt")
+	obj := NewFileCheckpointStore(path)
+
+	err := obj.Save([]byte("data"))
+
+	assert.NoError(t, err)
+	result, err := obj.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), result)
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFileCheckpointStoreSaveError(t *testing.T) {
+	obj := NewFileCheckpointStore(filepath.Join(tempDir(t), "missing", "ckpt"))
+
+	err := obj.Save([]byte("data"))
+
+	assert.Error(t, err)
+}
+
+func TestFileCheckpointStoreLoadMissing(t *testing.T) {
+	obj := NewFileCheckpointStore(filepath.Join(tempDir(t), "ckpt"))
+
+	result, err := obj.Load()
+
+	assert.Same(t, ErrNoCheckpoint, err)
+	assert.Nil(t, result)
+}
+
+func TestNewCheckpointerDisabled(t *testing.T) {
+	result := newCheckpointer(&options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewCheckpointerEnabled(t *testing.T) {
+	store := &MockCheckpointStore{}
+
+	result := newCheckpointer(&options{
+		checkpointStore:    store,
+		checkpointCodec:    JSONCodec{},
+		checkpointInterval: time.Second,
+	})
+
+	require.NotNil(t, result)
+	assert.Same(t, store, result.store)
+	assert.Equal(t, JSONCodec{}, result.codec)
+	assert.Equal(t, time.Second, result.interval)
+	assert.NotNil(t, result.pending)
+}
+
+func TestNewCheckpointerDefaultInterval(t *testing.T) {
+	result := newCheckpointer(&options{
+		checkpointStore: &MockCheckpointStore{},
+	})
+
+	require.NotNil(t, result)
+	assert.Equal(t, defaultCheckpointInterval, result.interval)
+}
+
+func TestCheckpointerAddRemove(t *testing.T) {
+	obj := newCheckpointer(&options{checkpointStore: &MockCheckpointStore{}})
+	item1 := &workItem{data: "data1"}
+	item2 := &workItem{data: "data2"}
+
+	obj.add(item1)
+	obj.add(item2)
+	obj.remove(item1)
+
+	assert.Equal(t, uint64(0), item1.id)
+	assert.Equal(t, uint64(1), item2.id)
+	assert.Equal(t, map[uint64]interface{}{1: "data2"}, obj.pending)
+}
+
+func TestCheckpointerEncodeBase(t *testing.T) {
+	obj := newCheckpointer(&options{
+		checkpointStore: &MockCheckpointStore{},
+		checkpointCodec: JSONCodec{},
+	})
+	for i := 1.0; i <= 3; i++ {
+		obj.add(&workItem{data: i})
+	}
+
+	result, err := obj.encode(&sumRunner{sum: 5})
+
+	assert.NoError(t, err)
+	ckpt := &checkpointData{}
+	require.NoError(t, json.Unmarshal(result, ckpt))
+	assert.Equal(t, &checkpointData{
+		State: []byte("5"),
+		Items: [][]byte{[]byte("1"), []byte("2"), []byte("3")},
+	}, ckpt)
+}
+
+func TestCheckpointerEncodeNoSnapshot(t *testing.T) {
+	obj := newCheckpointer(&options{
+		checkpointStore: &MockCheckpointStore{},
+		checkpointCodec: JSONCodec{},
+	})
+
+	result, err := obj.encode(&MockRunner{})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"items":[]}`, string(result))
+}
+
+func TestCheckpointerEncodeStateError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", 5.0).Return(nil, assert.AnError)
+	obj := newCheckpointer(&options{
+		checkpointStore: &MockCheckpointStore{},
+		checkpointCodec: codec,
+	})
+
+	result, err := obj.encode(&sumRunner{sum: 5})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	codec.AssertExpectations(t)
+}
+
+func TestCheckpointerEncodeItemError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", "data").Return(nil, assert.AnError)
+	obj := newCheckpointer(&options{
+		checkpointStore: &MockCheckpointStore{},
+		checkpointCodec: codec,
+	})
+	obj.add(&workItem{data: "data"})
+
+	result, err := obj.encode(&MockRunner{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	codec.AssertExpectations(t)
+}
+
+func TestCheckpointerSaveBase(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Save", []byte(`{"items":[]}`)).Return(assert.AnError)
+	obj := newCheckpointer(&options{
+		checkpointStore: store,
+		checkpointCodec: JSONCodec{},
+	})
+
+	err := obj.save(&sync.Mutex{}, &MockRunner{})
+
+	assert.Same(t, assert.AnError, err)
+	store.AssertExpectations(t)
+}
+
+func TestCheckpointerSaveEncodeError(t *testing.T) {
+	store := &MockCheckpointStore{}
+	codec := &MockCodec{}
+	codec.On("Encode", "data").Return(nil, assert.AnError)
+	obj := newCheckpointer(&options{
+		checkpointStore: store,
+		checkpointCodec: codec,
+	})
+	obj.add(&workItem{data: "data"})
+
+	err := obj.save(&sync.Mutex{}, &MockRunner{})
+
+	assert.Same(t, assert.AnError, err)
+	store.AssertExpectations(t)
+}
+
+func TestCheckpointerStartFinish(t *testing.T) {
+	saved := make(chan bool, 1)
+	store := &MockCheckpointStore{}
+	store.On("Save", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		select {
+		case saved <- true:
+		default:
+		}
+	})
+	obj := newCheckpointer(&options{
+		checkpointStore:    store,
+		checkpointCodec:    JSONCodec{},
+		checkpointInterval: time.Millisecond,
+	})
+
+	obj.start(&sync.Mutex{}, &MockRunner{})
+	<-saved
+	err := obj.finish(&sync.Mutex{}, &MockRunner{})
+
+	assert.NoError(t, err)
+	_, ok := <-obj.done
+	assert.False(t, ok)
+	store.AssertExpectations(t)
+}
+
+func TestCheckpointerFinishNotStarted(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Save", []byte(`{"items":[]}`)).Return(nil)
+	obj := newCheckpointer(&options{
+		checkpointStore: store,
+		checkpointCodec: JSONCodec{},
+	})
+
+	err := obj.finish(&sync.Mutex{}, &MockRunner{})
+
+	assert.NoError(t, err)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointBase(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return([]byte(`{"state":"NQ==","items":["MQ==","Mg=="]}`), nil)
+
+	state, hasState, items, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, state)
+	assert.True(t, hasState)
+	assert.Equal(t, []interface{}{1.0, 2.0}, items)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointNoState(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return([]byte(`{"items":[]}`), nil)
+
+	state, hasState, items, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+	assert.False(t, hasState)
+	assert.Equal(t, []interface{}{}, items)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointLoadError(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return(nil, ErrNoCheckpoint)
+
+	_, _, _, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.Same(t, ErrNoCheckpoint, err)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointBadJSON(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return([]byte(`bad`), nil)
+
+	_, _, _, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.Error(t, err)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointStateError(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return([]byte(`{"state":"YmFk","items":[]}`), nil)
+
+	_, _, _, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.Error(t, err)
+	store.AssertExpectations(t)
+}
+
+func TestLoadCheckpointItemError(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return([]byte(`{"items":["YmFk"]}`), nil)
+
+	_, _, _, err := loadCheckpoint(store, JSONCodec{})
+
+	assert.Error(t, err)
+	store.AssertExpectations(t)
+}
+
+func TestResumeGoWorkerNoCheckpoint(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(tempDir(t), "ckpt"))
+	runner := &sumRunner{}
+
+	worker, err := ResumeGoWorker(runner, 2, store, JSONCodec{})
+
+	require.NoError(t, err)
+	assert.NoError(t, worker.Call(1.0))
+	result, err := worker.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, result)
+}
+
+func TestResumeGoWorkerLoadError(t *testing.T) {
+	store := &MockCheckpointStore{}
+	store.On("Load").Return(nil, assert.AnError)
+
+	worker, err := ResumeGoWorker(&sumRunner{}, 2, store, JSONCodec{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, worker)
+	store.AssertExpectations(t)
+}
+
+func TestResumeGoWorkerNoCodec(t *testing.T) {
+	store := &MockCheckpointStore{}
+
+	worker, err := ResumeGoWorker(&sumRunner{}, 2, store, nil)
+
+	assert.Same(t, ErrNoCodec, err)
+	assert.Nil(t, worker)
+	store.AssertExpectations(t)
+}
+
+func TestResumeGoWorkerUnsupportedOption(t *testing.T) {
+	store := &MockCheckpointStore{}
+
+	worker, err := ResumeGoWorker(&sumRunner{}, 2, store, JSONCodec{}, WithHeartbeat(0, 0))
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	assert.Nil(t, worker)
+	store.AssertExpectations(t)
+}
+
+func TestResubmitBase(t *testing.T) {
+	worker := &MockWorker{}
+	worker.On("Call", 1.0).Return(nil)
+	worker.On("Call", 2.0).Return(nil)
+
+	err := resubmit(worker, []interface{}{1.0, 2.0})
+
+	assert.NoError(t, err)
+	worker.AssertExpectations(t)
+}
+
+func TestResubmitCallError(t *testing.T) {
+	worker := &MockWorker{}
+	worker.On("Call", 1.0).Return(assert.AnError)
+	worker.On("Wait").Return(nil, nil)
+
+	err := resubmit(worker, []interface{}{1.0, 2.0})
+
+	assert.Same(t, assert.AnError, err)
+	worker.AssertExpectations(t)
+	worker.AssertNotCalled(t, "Call", 2.0)
+}
+
+func TestGoWorkerCheckpointResume(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(tempDir(t), "ckpt"))
+	runner1 := &sumRunner{gate: make(chan struct{})}
+	worker1 := NewGoWorker(runner1, 0, WithCheckpoint(store, JSONCodec{}, time.Hour))
+	results := []CallResult{}
+	for _, n := range []float64{1, 2, 200, 300} {
+		cr, err := worker1.(AsyncWorker).CallAsync(n)
+		require.NoError(t, err)
+		results = append(results, cr)
+	}
+	results[0].Wait()
+	results[1].Wait()
+	require.NoError(t, worker1.(*goWorker).ckpt.save(worker1.(*goWorker).serial, runner1))
+	snapshot, err := store.Load()
+	require.NoError(t, err)
+
+	// "Crash" worker1, shutting it down and restoring the
+	// checkpoint saved before it finished, and resume from it
+	close(runner1.gate)
+	_, err = worker1.Wait()
+	require.NoError(t, err)
+	require.NoError(t, store.Save(snapshot))
+	runner2 := &sumRunner{}
+	worker2, err := ResumeGoWorker(runner2, 2, store, JSONCodec{}, WithCheckpoint(store, JSONCodec{}, time.Hour))
+	require.NoError(t, err)
+	result, err := worker2.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 503.0, result)
+	_, _, items, err := loadCheckpoint(store, JSONCodec{})
+	assert.NoError(t, err)
+	assert.Len(t, items, 0)
+}
+
+func TestGoWorkerCheckpointIntegrated(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(tempDir(t), "ckpt"))
+	runner := &sumRunner{}
+	obj := NewGoWorker(runner, 0, WithCheckpoint(store, JSONCodec{}, time.Hour)).(*goWorker)
+	results := make(chan *Result) // Blocks the worker after integrating
+	require.NoError(t, obj.call(&workItem{data: 5.0, result: results}))
+	require.Eventually(t, func() bool {
+		obj.serial.Lock()
+		defer obj.serial.Unlock()
+		return runner.sum == 5.0
+	}, time.Second, time.Millisecond)
+
+	err := obj.ckpt.save(obj.serial, runner)
+
+	require.NoError(t, err)
+	state, _, items, err := loadCheckpoint(store, JSONCodec{})
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, state)
+	assert.Len(t, items, 0)
+	<-results
+	_, err = obj.Wait()
+	assert.NoError(t, err)
+}
+
+func TestGoWorkerCheckpointResumeSharded(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(tempDir(t), "ckpt"))
+	runner1 := &shardSumRunner{sumRunner{gate: make(chan struct{})}}
+	worker1 := NewGoWorker(runner1, 0, WithCheckpoint(store, JSONCodec{}, time.Hour))
+	results := []CallResult{}
+	for _, n := range []float64{1, 2, 200, 300} {
+		cr, err := worker1.(AsyncWorker).CallAsync(n)
+		require.NoError(t, err)
+		results = append(results, cr)
+	}
+	results[0].Wait()
+	results[1].Wait()
+	obj := worker1.(*goWorker)
+	require.NoError(t, obj.ckpt.save(obj.checkpointLock(), runner1))
+	snapshot, err := store.Load()
+	require.NoError(t, err)
+
+	// "Crash" worker1, shutting it down and restoring the
+	// checkpoint saved before it finished, and resume from it
+	close(runner1.gate)
+	_, err = worker1.Wait()
+	require.NoError(t, err)
+	require.NoError(t, store.Save(snapshot))
+	state, _, items, err := loadCheckpoint(store, JSONCodec{})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, state)
+	assert.Len(t, items, 2)
+	runner2 := &shardSumRunner{}
+	worker2, err := ResumeGoWorker(runner2, 2, store, JSONCodec{}, WithCheckpoint(store, JSONCodec{}, time.Hour))
+	require.NoError(t, err)
+	result, err := worker2.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 503.0, result)
+	state, _, items, err = loadCheckpoint(store, JSONCodec{})
+	assert.NoError(t, err)
+	assert.Equal(t, 503.0, state)
+	assert.Len(t, items, 0)
+}
@@ -0,0 +1,360 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Parallelize is a parallel command runner, in the style of "xargs -P"
+// or GNU parallel, built on the parallelizer library.  It reads items,
+// one per line, from standard input or from the files named with -a,
+// and runs a command for each item, with the item substituted for
+// each "{}" in the command's arguments, or appended as the last
+// argument if there is no "{}".  The number of commands run at once is
+// set with -j.  Failing commands may be retried with -retries, and
+// commands may be limited to a maximum run time with -timeout.  The
+// output of each command is written when the command completes, or,
+// with -keep-order, in the order the items were read.  A summary of
+// the exit code and duration of each command may be written, as JSON
+// lines, to the file named with -summary.  The exit code is 0 if all
+// commands succeeded, 1 if any failed, and 2 if the arguments were
+// invalid.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/tmobile/parallelizer"
+)
+
+// Exit codes.
+const (
+	exitSuccess = 0 // All commands succeeded
+	exitFailed  = 1 // Some command failed
+	exitUsage   = 2 // Invalid arguments
+)
+
+// placeholder is the string replaced with the item in the command.
+const placeholder = "{}"
+
+// Maximum length of an input line.
+const maxLineSize = 1 << 20
+
+// errNoCommand is returned by parseArgs if no command was given.
+var errNoCommand = errors.New("no command given")
+
+// stringList is a flag.Value that accumulates repeated flags.
+type stringList []string
+
+// String returns the string representation of the list.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds a value to the list.
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// config contains the configuration from the command line.
+type config struct {
+	jobs      int           // Number of commands to run at once
+	files     stringList    // Files to read items from
+	retries   int           // Number of times to retry a failed command
+	timeout   time.Duration // Maximum run time of each command
+	keepOrder bool          // Write output in input order
+	summary   string        // File to write the summary to
+	command   []string      // The command template
+}
+
+// parseArgs parses the command line arguments.
+func parseArgs(args []string, stderr io.Writer) (*config, error) {
+	cfg := &config{}
+
+	flags := flag.NewFlagSet("parallelize", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: parallelize [options] command [args...]\n\n")
+		flags.PrintDefaults()
+	}
+	flags.IntVar(&cfg.jobs, "j", runtime.NumCPU(), "Number of commands to run at once")
+	flags.Var(&cfg.files, "a", "File to read items from; may be repeated (default standard input)")
+	flags.IntVar(&cfg.retries, "retries", 0, "Number of times to retry a failed command")
+	flags.DurationVar(&cfg.timeout, "timeout", 0, "Maximum run time of each command (default no limit)")
+	flags.BoolVar(&cfg.keepOrder, "keep-order", false, "Write output in input order instead of as completed")
+	flags.StringVar(&cfg.summary, "summary", "", "File to write a JSON-lines summary to")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg.command = flags.Args()
+	if len(cfg.command) == 0 {
+		flags.Usage()
+		return nil, errNoCommand
+	}
+
+	return cfg, nil
+}
+
+// buildCommand constructs the arguments of the command to run for an
+// item.
+func buildCommand(template []string, item string) []string {
+	found := false
+	args := make([]string, 0, len(template)+1)
+	for _, arg := range template {
+		if strings.Contains(arg, placeholder) {
+			found = true
+			arg = strings.Replace(arg, placeholder, item, -1)
+		}
+		args = append(args, arg)
+	}
+	if !found {
+		args = append(args, item)
+	}
+
+	return args
+}
+
+// job describes an item to run the command for.
+type job struct {
+	index int    // Index of the item in the input
+	item  string // The item
+}
+
+// jobResult describes the outcome of running the command for an
+// item.  It is also the format of the summary lines.
+type jobResult struct {
+	Index    int      `json:"index"`           // Index of the item in the input
+	Item     string   `json:"item"`            // The item
+	Command  []string `json:"command"`         // The command that was run
+	ExitCode int      `json:"exit_code"`       // Exit code of the last attempt
+	Duration float64  `json:"duration"`        // Total run time in seconds
+	Attempts int      `json:"attempts"`        // Number of attempts made
+	Error    string   `json:"error,omitempty"` // Error running the command
+	stdout   []byte   // Standard output of the last attempt
+	stderr   []byte   // Standard error of the last attempt
+}
+
+// runner is the parallelizer.Runner that runs the commands.
+type runner struct {
+	cfg     *config            // The configuration
+	stdout  io.Writer          // Where to write command output
+	stderr  io.Writer          // Where to write command errors
+	summary *json.Encoder      // Encoder for the summary, if requested
+	pending map[int]*jobResult // Results waiting to be written in order
+	next    int                // Index of the next result to write
+	failed  int                // Number of failed commands
+}
+
+// attempt runs the command once.  If the command times out, it is
+// killed along with its children, which could otherwise keep its
+// output open and so keep it from completing.
+func (r *runner) attempt(args []string, result *jobResult) {
+	ctx := context.Background()
+	if r.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.timeout)
+		defer cancel()
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err == nil {
+		// Kill the command if it times out
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killProcessGroup(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	result.stdout = stdout.Bytes()
+	result.stderr = stderr.Bytes()
+	result.ExitCode = 0
+	result.Error = ""
+	if ctx.Err() == context.DeadlineExceeded {
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", r.cfg.timeout)
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+}
+
+// Run runs the command for an item, retrying as configured.
+func (r *runner) Run(data interface{}) interface{} {
+	j := data.(job)
+	result := &jobResult{
+		Index:   j.index,
+		Item:    j.item,
+		Command: buildCommand(r.cfg.command, j.item),
+	}
+
+	start := time.Now()
+	for result.Attempts <= r.cfg.retries {
+		result.Attempts++
+		r.attempt(result.Command, result)
+		if result.ExitCode == 0 {
+			break
+		}
+	}
+	result.Duration = time.Since(start).Seconds()
+
+	return result
+}
+
+// write writes the output of a command and its summary line.
+func (r *runner) write(result *jobResult) {
+	r.stdout.Write(result.stdout)
+	r.stderr.Write(result.stderr)
+	if result.ExitCode != 0 {
+		r.failed++
+	}
+	if r.summary != nil {
+		r.summary.Encode(result)
+	}
+}
+
+// Integrate writes the output of a command, either immediately or, if
+// the output is to be kept in input order, once the output of all the
+// preceding commands has been written.
+func (r *runner) Integrate(worker parallelizer.Worker, result *parallelizer.Result) {
+	jr := result.Result.(*jobResult)
+	if !r.cfg.keepOrder {
+		r.write(jr)
+		return
+	}
+
+	r.pending[jr.Index] = jr
+	for {
+		next, ok := r.pending[r.next]
+		if !ok {
+			break
+		}
+		delete(r.pending, r.next)
+		r.next++
+		r.write(next)
+	}
+}
+
+// Result returns the number of failed commands.
+func (r *runner) Result() interface{} {
+	return r.failed
+}
+
+// readItems reads items from a reader, one per line, submitting each
+// to the worker.  Empty lines are skipped.  It returns the index of
+// the next item.
+func readItems(in io.Reader, worker parallelizer.Worker, index int) (int, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		item := scanner.Text()
+		if item == "" {
+			continue
+		}
+		if err := worker.Call(job{index: index, item: item}); err != nil {
+			return index, err
+		}
+		index++
+	}
+
+	return index, scanner.Err()
+}
+
+// run is the implementation of the command.  It returns the exit
+// code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg, err := parseArgs(args, stderr)
+	if err == flag.ErrHelp {
+		return exitSuccess
+	} else if err != nil {
+		return exitUsage
+	}
+
+	// Set up the runner
+	r := &runner{
+		cfg:     cfg,
+		stdout:  stdout,
+		stderr:  stderr,
+		pending: map[int]*jobResult{},
+	}
+	if cfg.summary != "" {
+		f, err := os.Create(cfg.summary)
+		if err != nil {
+			fmt.Fprintf(stderr, "parallelize: %s\n", err)
+			return exitUsage
+		}
+		defer f.Close()
+		r.summary = json.NewEncoder(f)
+	}
+
+	// Read the items
+	worker := parallelizer.NewGoWorker(r, cfg.jobs)
+	status := exitSuccess
+	if len(cfg.files) == 0 {
+		if _, err := readItems(stdin, worker, 0); err != nil {
+			fmt.Fprintf(stderr, "parallelize: reading standard input: %s\n", err)
+			status = exitFailed
+		}
+	}
+	index := 0
+	for _, name := range cfg.files {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "parallelize: %s\n", err)
+			status = exitFailed
+			continue
+		}
+		index, err = readItems(f, worker, index)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "parallelize: reading %s: %s\n", name, err)
+			status = exitFailed
+		}
+	}
+
+	// Wait for the commands to complete
+	failed, _ := worker.Wait()
+	if failed.(int) > 0 {
+		status = exitFailed
+	}
+
+	return status
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
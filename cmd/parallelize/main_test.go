@@ -0,0 +1,286 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmobile/parallelizer"
+)
+
+// tempDir creates a temporary directory that is removed when the test
+// completes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "parallelize")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// readSummary reads a summary file.
+func readSummary(t *testing.T, path string) []*jobResult {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	result := []*jobResult{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		jr := &jobResult{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), jr))
+		result = append(result, jr)
+	}
+
+	return result
+}
+
+func TestStringList(t *testing.T) {
+	obj := &stringList{}
+
+	assert.NoError(t, obj.Set("a"))
+	assert.NoError(t, obj.Set("b"))
+
+	assert.Equal(t, &stringList{"a", "b"}, obj)
+	assert.Equal(t, "a,b", obj.String())
+}
+
+func TestParseArgsDefaults(t *testing.T) {
+	result, err := parseArgs([]string{"echo", "-n"}, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{
+		jobs:    runtime.NumCPU(),
+		command: []string{"echo", "-n"},
+	}, result)
+}
+
+func TestParseArgsAll(t *testing.T) {
+	result, err := parseArgs([]string{
+		"-j", "3",
+		"-a", "file1",
+		"-a", "file2",
+		"-retries", "2",
+		"-timeout", "5s",
+		"-keep-order",
+		"-summary", "summary.json",
+		"echo",
+	}, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config{
+		jobs:      3,
+		files:     stringList{"file1", "file2"},
+		retries:   2,
+		timeout:   5 * time.Second,
+		keepOrder: true,
+		summary:   "summary.json",
+		command:   []string{"echo"},
+	}, result)
+}
+
+func TestParseArgsNoCommand(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	result, err := parseArgs([]string{"-j", "3"}, stderr)
+
+	assert.Same(t, errNoCommand, err)
+	assert.Nil(t, result)
+	assert.Contains(t, stderr.String(), "Usage: parallelize")
+}
+
+func TestParseArgsBadFlag(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	result, err := parseArgs([]string{"-bogus", "echo"}, stderr)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.Contains(t, stderr.String(), "bogus")
+}
+
+func TestBuildCommandPlaceholder(t *testing.T) {
+	result := buildCommand([]string{"cp", "{}", "{}.bak"}, "file")
+
+	assert.Equal(t, []string{"cp", "file", "file.bak"}, result)
+}
+
+func TestBuildCommandAppend(t *testing.T) {
+	result := buildCommand([]string{"echo", "-n"}, "item")
+
+	assert.Equal(t, []string{"echo", "-n", "item"}, result)
+}
+
+func TestRunnerRunSuccess(t *testing.T) {
+	obj := &runner{cfg: &config{command: []string{"echo"}}}
+
+	result := obj.Run(job{index: 3, item: "hello"}).(*jobResult)
+
+	assert.Equal(t, 3, result.Index)
+	assert.Equal(t, "hello", result.Item)
+	assert.Equal(t, []string{"echo", "hello"}, result.Command)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, 1, result.Attempts)
+	assert.Equal(t, "", result.Error)
+	assert.Equal(t, []byte("hello\n"), result.stdout)
+}
+
+func TestRunnerRunRetries(t *testing.T) {
+	obj := &runner{cfg: &config{
+		command: []string{"sh", "-c", "echo oops >&2; exit {}"},
+		retries: 2,
+	}}
+
+	result := obj.Run(job{item: "3"}).(*jobResult)
+
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, 3, result.Attempts)
+	assert.Equal(t, []byte("oops\n"), result.stderr)
+}
+
+func TestRunnerRunTimeout(t *testing.T) {
+	obj := &runner{cfg: &config{
+		command: []string{"sleep"},
+		timeout: 10 * time.Millisecond,
+	}}
+
+	result := obj.Run(job{item: "10"}).(*jobResult)
+
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "timed out after 10ms", result.Error)
+}
+
+func TestRunnerRunTimeoutChildren(t *testing.T) {
+	obj := &runner{cfg: &config{
+		command: []string{"sh", "-c", "sleep 5; echo {}"},
+		timeout: 100 * time.Millisecond,
+	}}
+	start := time.Now()
+
+	result := obj.Run(job{item: "item"}).(*jobResult)
+
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, "timed out after 100ms", result.Error)
+}
+
+func TestRunnerRunNotFound(t *testing.T) {
+	obj := &runner{cfg: &config{command: []string{"/nonexistent/command"}}}
+
+	result := obj.Run(job{item: "item"}).(*jobResult)
+
+	assert.Equal(t, -1, result.ExitCode)
+	assert.NotEqual(t, "", result.Error)
+}
+
+func TestRunnerIntegrateKeepOrder(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	obj := &runner{
+		cfg:     &config{keepOrder: true},
+		stdout:  stdout,
+		stderr:  &bytes.Buffer{},
+		pending: map[int]*jobResult{},
+	}
+
+	for _, i := range []int{2, 0, 3, 1} {
+		obj.Integrate(nil, &parallelizer.Result{Result: &jobResult{
+			Index:    i,
+			ExitCode: i % 2,
+			stdout:   []byte{'0' + byte(i)},
+		}})
+	}
+
+	assert.Equal(t, "0123", stdout.String())
+	assert.Equal(t, 4, obj.next)
+	assert.Len(t, obj.pending, 0)
+	assert.Equal(t, 2, obj.Result())
+}
+
+func TestRunStdin(t *testing.T) {
+	summary := filepath.Join(tempDir(t), "summary.json")
+	stdout := &bytes.Buffer{}
+	stdin := strings.NewReader("c\n\na\nb\n")
+
+	result := run([]string{"-j", "2", "-keep-order", "-summary", summary, "echo", "item", "{}"}, stdin, stdout, &bytes.Buffer{})
+
+	assert.Equal(t, exitSuccess, result)
+	assert.Equal(t, "item c\nitem a\nitem b\n", stdout.String())
+	lines := readSummary(t, summary)
+	assert.Len(t, lines, 3)
+	for i, line := range lines {
+		assert.Equal(t, i, line.Index)
+		assert.Equal(t, 0, line.ExitCode)
+		assert.Equal(t, 1, line.Attempts)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := tempDir(t)
+	file1 := filepath.Join(dir, "file1")
+	file2 := filepath.Join(dir, "file2")
+	require.NoError(t, ioutil.WriteFile(file1, []byte("0\n1\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(file2, []byte("0\n"), 0644))
+	stdout := &bytes.Buffer{}
+
+	result := run([]string{"-a", file1, "-a", file2, "-keep-order", "sh", "-c", "echo {}; exit {}"}, strings.NewReader("ignored\n"), stdout, &bytes.Buffer{})
+
+	assert.Equal(t, exitFailed, result)
+	assert.Equal(t, "0\n1\n0\n", stdout.String())
+}
+
+func TestRunMissingFile(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	result := run([]string{"-a", filepath.Join(tempDir(t), "missing"), "echo"}, nil, &bytes.Buffer{}, stderr)
+
+	assert.Equal(t, exitFailed, result)
+	assert.Contains(t, stderr.String(), "missing")
+}
+
+func TestRunBadSummary(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	result := run([]string{"-summary", filepath.Join(tempDir(t), "missing", "summary"), "echo"}, nil, &bytes.Buffer{}, stderr)
+
+	assert.Equal(t, exitUsage, result)
+	assert.Contains(t, stderr.String(), "summary")
+}
+
+func TestRunUsage(t *testing.T) {
+	result := run([]string{}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	assert.Equal(t, exitUsage, result)
+}
+
+func TestRunHelp(t *testing.T) {
+	stderr := &bytes.Buffer{}
+
+	result := run([]string{"-h"}, nil, &bytes.Buffer{}, stderr)
+
+	assert.Equal(t, exitSuccess, result)
+	assert.Contains(t, stderr.String(), "Usage: parallelize")
+}
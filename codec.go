@@ -0,0 +1,84 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec is an interface describing how to convert data items and
+// results to and from a byte representation, for those facilities
+// that must store them outside of the process.
+type Codec interface {
+	// Encode converts a value to bytes.
+	Encode(value interface{}) ([]byte, error)
+
+	// Decode converts bytes produced by Encode back into a value.
+	Decode(data []byte) (interface{}, error)
+}
+
+// gobValue is a wrapper that allows arbitrary values to be encoded by
+// the gob package.
+type gobValue struct {
+	Value interface{}
+}
+
+// GobCodec is an implementation of Codec that uses the encoding/gob
+// package.  Decoded values have the same types as the encoded values,
+// but all concrete types must be registered using gob.Register.
+type GobCodec struct{}
+
+// Encode converts a value to bytes.
+func (c GobCodec) Encode(value interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&gobValue{Value: value}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode converts bytes produced by Encode back into a value.
+func (c GobCodec) Decode(data []byte) (interface{}, error) {
+	value := &gobValue{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(value); err != nil {
+		return nil, err
+	}
+
+	return value.Value, nil
+}
+
+// JSONCodec is an implementation of Codec that uses the encoding/json
+// package.  Note that decoded values will have the generic types
+// produced by json.Unmarshal, such as float64 for numbers and
+// map[string]interface{} for objects.
+type JSONCodec struct{}
+
+// Encode converts a value to bytes.
+func (c JSONCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Decode converts bytes produced by Encode back into a value.
+func (c JSONCodec) Decode(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
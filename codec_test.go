@@ -0,0 +1,79 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGobCodecImplementsCodec(t *testing.T) {
+	assert.Implements(t, (*Codec)(nil), GobCodec{})
+}
+
+func TestGobCodecRoundTrip(t *testing.T) {
+	obj := GobCodec{}
+
+	data, err := obj.Encode("value")
+	require.NoError(t, err)
+	result, err := obj.Decode(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "value", result)
+}
+
+func TestGobCodecEncodeUnregistered(t *testing.T) {
+	obj := GobCodec{}
+
+	result, err := obj.Encode(struct{ A int }{A: 1})
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestGobCodecDecodeError(t *testing.T) {
+	obj := GobCodec{}
+
+	result, err := obj.Decode([]byte("bad"))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestJSONCodecImplementsCodec(t *testing.T) {
+	assert.Implements(t, (*Codec)(nil), JSONCodec{})
+}
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	obj := JSONCodec{}
+
+	data, err := obj.Encode(map[string]interface{}{"a": 1})
+	require.NoError(t, err)
+	result, err := obj.Decode(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, result)
+}
+
+func TestJSONCodecDecodeError(t *testing.T) {
+	obj := JSONCodec{}
+
+	result, err := obj.Decode([]byte("bad"))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
@@ -16,97 +16,255 @@ package parallelizer
 
 import (
 	"errors"
-	"reflect"
+	"fmt"
+	"runtime/debug"
+	"sync"
 )
 
 // Various errors that may be returned by Worker.Call.
 var (
 	ErrClosed        = errors.New("Object has been closed by a call to Wait")
-	ErrWouldDeadlock = errors.New("Called Wait from Integrate; would deadlock")
+	ErrWouldDeadlock = errors.New("Called from a Runner or Doer method; would deadlock")
 )
 
+// ErrSpawningStreaming is the value with which the worker constructors
+// panic if the Runner implements both SpawningRunner and
+// StreamingRunner, which may not be combined.
+var ErrSpawningStreaming = errors.New("Runner implements both SpawningRunner and StreamingRunner")
+
 // Result describes a result from calling a Run or Do function.  These
 // functions are called in such a way as to capture panics, and the
 // Result structure will contain both the return value and the
 // captured panic.
 type Result struct {
-	Result interface{} // The function result
-	Panic  interface{} // The captured panic
+	Result     interface{} // The function result
+	Panic      interface{} // The captured panic
+	PanicError *PanicError // Details of the captured panic
+	Cached     bool        // True if the result came from a Cache
+	Partial    bool        // True if emitted by StreamingRunner.RunStreaming
+}
+
+// PanicError describes a panic captured from a call to Runner.Run,
+// Doer.Do, or the function passed to Then.  It is available in the
+// PanicError field of the Result, and it is the value passed to panic
+// by Wait when the WithRepanic option is used.
+type PanicError struct {
+	Value  interface{} // The value passed to panic
+	Stack  []byte      // The stack of the goroutine that panicked
+	Data   interface{} // The data passed to the function
+	Source string      // Where it was captured, e.g. "NewGoWorker"
+	Name   string      // The name given with WithName, if any
+}
+
+// Error returns the error message.  It identifies the worker by its
+// name, if it was given one with WithName.
+func (e *PanicError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("Panic in %s %q: %v", e.Source, e.Name, e.Value)
+	}
+
+	return fmt.Sprintf("Panic in %s: %v", e.Source, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error, or nil
+// otherwise.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Stats contains statistics describing the operation of a Worker.
+type Stats struct {
+	Calls      int64 // Number of data items accepted by Call
+	Duplicates int64 // Number of data items suppressed as duplicates
+	CacheHits  int64 // Number of results obtained from a Cache
+}
+
+// workItem describes a data item submitted to a worker.  It contains
+// an optional result channel, through which the result will be
+// returned to the caller of AsyncWorker.CallAsync.  The channel must
+// have a buffer size of at least one to avoid blocking the worker.
+type workItem struct {
+	data   interface{}    // The data to pass to Runner.Run
+	result chan<- *Result // Optional channel to send the result to
+	key    interface{}    // Key computed for duplicate suppression
+	id     uint64         // Identifier assigned for checkpoints
+	logID  uint64         // Identifier assigned by the durable queue
+	spawn  bool           // Set if spawned by SpawningRunner.RunWithSpawner
+}
+
+// newAsyncItem is a helper that constructs a work item with a result
+// channel, along with a CallResult for receiving the result.
+func newAsyncItem(data interface{}) (*workItem, CallResult) {
+	result := make(chan *Result, 1)
+
+	return &workItem{
+		data:   data,
+		result: result,
+	}, &callResult{response: result}
+}
+
+// resolve sends the result of working the item to the caller of
+// AsyncWorker.CallAsync, if any.
+func (item *workItem) resolve(result *Result) {
+	if item.result != nil {
+		item.result <- result
+	}
 }
 
 // panicer wraps a Run method and captures any panics caused within
-// it.
-func panicer(fn func(interface{}) interface{}, data interface{}) (result *Result) {
+// it, along with the stack at the time of the panic.  The source
+// identifies the caller in the resulting PanicError.
+func panicer(source string, fn func(interface{}) interface{}, data interface{}) (result *Result) {
 	// Ensure we capture panics
 	defer func() {
 		if panicData := recover(); panicData != nil {
-			result = &Result{Panic: panicData}
+			result = &Result{
+				Panic: panicData,
+				PanicError: &PanicError{
+					Value:  panicData,
+					Stack:  debug.Stack(),
+					Data:   data,
+					Source: source,
+				},
+			}
 		}
 	}()
 
 	return &Result{Result: fn(data)}
 }
 
-// pState describes the state of the worker or serializer.
-type pState int
+// nameResult records the name given with WithName in the PanicError
+// of a result, if there is one.  It returns the result.
+func nameResult(result *Result, name string) *Result {
+	if result.PanicError != nil {
+		result.PanicError.Name = name
+	}
 
-// Worker state values.
-const (
-	pNew     pState = iota // New state, not started
-	pRunning               // Running state
-	pClosed                // Closed state, result hasn't been received yet
-	pResult                // Result state, result has been received
-)
+	return result
+}
+
+// runHooks contains the worker-provided state and functions passed to
+// the optional variants of Runner.Run.  Any of them may be nil.
+type runHooks struct {
+	local *workerLocal             // Worker-local state for a LocalRunner
+	spawn func(data interface{})   // Spawn function for a SpawningRunner
+	emit  func(result interface{}) // Emit function for a StreamingRunner
+}
+
+// checkRunner is called by the constructors of the workers supporting
+// SpawningRunner and StreamingRunner.  It panics with
+// ErrSpawningStreaming if the runner implements both, since neither
+// method could be called without losing the other's function.
+func checkRunner(runner Runner) {
+	_, spawning := runner.(SpawningRunner)
+	_, streaming := runner.(StreamingRunner)
+	if spawning && streaming {
+		panic(ErrSpawningStreaming)
+	}
+}
+
+// runItem runs a data item and captures any panics.  It calls
+// SpawningRunner.RunWithSpawner or StreamingRunner.RunStreaming if
+// the runner implements it and the corresponding function is
+// provided; otherwise, it calls LocalRunner.RunLocal with the
+// worker-local state if there is one, or Runner.Run.  The runner
+// never implements both SpawningRunner and StreamingRunner, which
+// checkRunner rejects.
+func runItem(source string, runner Runner, hooks runHooks, data interface{}) *Result {
+	if sr, ok := runner.(SpawningRunner); ok && hooks.spawn != nil {
+		return panicer(source, func(data interface{}) interface{} {
+			return sr.RunWithSpawner(data, hooks.spawn)
+		}, data)
+	}
+
+	if sr, ok := runner.(StreamingRunner); ok && hooks.emit != nil {
+		return panicer(source, func(data interface{}) interface{} {
+			return sr.RunStreaming(data, hooks.emit)
+		}, data)
+	}
+
+	if hooks.local == nil {
+		return panicer(source, runner.Run, data)
+	}
+
+	return hooks.local.run(source, data)
+}
+
+// repanicker implements the WithRepanic option.  It records the first
+// panic captured by a worker or serializer, so that Wait may panic
+// with it.
+type repanicker struct {
+	sync.Mutex
+	err *PanicError // The first panic captured
+}
+
+// newRepanicker constructs a repanicker from the options, or returns
+// nil if the option was not used.
+func newRepanicker(opts *options) *repanicker {
+	if !opts.repanic {
+		return nil
+	}
+
+	return &repanicker{}
+}
+
+// record records the panic from a result, if it is the first.  It is
+// safe to call on a nil repanicker.
+func (r *repanicker) record(result *Result) {
+	if r == nil || result.PanicError == nil {
+		return
+	}
 
-// selector groups together a reflect.SelectCase and a function that
-// will be called if that case matches.
-type selector struct {
-	selectCase reflect.SelectCase                 // The select case
-	fn         func(value reflect.Value, ok bool) // The function to call
-}
-
-// selectSend generates a selector value that may be used with
-// doSelect for sending a value on a channel.
-func selectSend(channel interface{}, value interface{}, fn func()) selector {
-	return selector{
-		selectCase: reflect.SelectCase{
-			Dir:  reflect.SelectSend,
-			Chan: reflect.ValueOf(channel),
-			Send: reflect.ValueOf(value),
-		},
-		fn: func(value reflect.Value, ok bool) {
-			fn()
-		},
+	r.Lock()
+	defer r.Unlock()
+
+	if r.err == nil {
+		r.err = result.PanicError
 	}
 }
 
-// selectRecv generates a selector value that may be used with
-// doSelect for receiving a value from a channel.
-func selectRecv(channel interface{}, fn func(value interface{}, ok bool)) selector {
-	return selector{
-		selectCase: reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(channel),
-		},
-		fn: func(value reflect.Value, ok bool) {
-			fn(value.Interface(), ok)
-		},
+// check panics with the first panic recorded, if any.  It is safe to
+// call on a nil repanicker.
+func (r *repanicker) check() {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	err := r.err
+	r.Unlock()
+
+	if err != nil {
+		panic(err)
 	}
 }
 
-// doSelect takes a list of selector instances, performs the select,
-// and arranges to have the appropriate function called when the
-// select returns.
-func doSelect(selectors []selector) {
-	// Construct a list of select cases
-	cases := make([]reflect.SelectCase, len(selectors))
-	for i, sel := range selectors {
-		cases[i] = sel.selectCase
+// first returns the first panic recorded, or nil if there was none.
+// It is safe to call on a nil repanicker.
+func (r *repanicker) first() error {
+	if r == nil {
+		return nil
 	}
 
-	// Run the select
-	chosen, value, ok := reflect.Select(cases)
+	r.Lock()
+	defer r.Unlock()
 
-	// Call the appropriate function
-	selectors[chosen].fn(value, ok)
+	// Avoid returning a typed nil
+	if r.err == nil {
+		return nil
+	}
+
+	return r.err
 }
+
+// pState describes the state of the worker or serializer.
+type pState int
+
+// Worker state values.
+const (
+	pNew     pState = iota // New state, not started
+	pRunning               // Running state
+	pClosed                // Closed state, result hasn't been received yet
+	pResult                // Result state, result has been received
+)
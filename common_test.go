@@ -15,16 +15,17 @@
 package parallelizer
 
 import (
-	"reflect"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPanicerBase(t *testing.T) {
 	fnCalled := false
 
-	result := panicer(func(data interface{}) interface{} {
+	result := panicer("source", func(data interface{}) interface{} {
 		assert.Equal(t, "data", data)
 		fnCalled = true
 		return "result"
@@ -39,68 +40,279 @@ func TestPanicerBase(t *testing.T) {
 func TestPanicerPanic(t *testing.T) {
 	fnCalled := false
 
-	result := panicer(func(data interface{}) interface{} {
+	result := panicer("source", func(data interface{}) interface{} {
 		assert.Equal(t, "data", data)
 		fnCalled = true
 		panic("this is a test")
 	}, "data")
 
-	assert.Equal(t, &Result{
-		Panic: "this is a test",
-	}, result)
+	assert.Equal(t, "this is a test", result.Panic)
+	assert.Nil(t, result.Result)
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "this is a test", result.PanicError.Value)
+	assert.Equal(t, "data", result.PanicError.Data)
+	assert.Equal(t, "source", result.PanicError.Source)
+	assert.Contains(t, string(result.PanicError.Stack), "TestPanicerPanic")
 	assert.True(t, fnCalled)
 }
 
-func TestSelectSend(t *testing.T) {
-	funcCalled := false
-	channel := make(chan int)
+func TestPanicErrorImplementsError(t *testing.T) {
+	assert.Implements(t, (*error)(nil), &PanicError{})
+}
 
-	result := selectSend(channel, 1234, func() {
-		funcCalled = true
-	})
+func TestPanicErrorError(t *testing.T) {
+	obj := &PanicError{Value: "boom", Source: "NewGoWorker"}
 
-	assert.Equal(t, reflect.SelectSend, result.selectCase.Dir)
-	assert.Equal(t, channel, result.selectCase.Chan.Interface())
-	assert.Equal(t, 1234, result.selectCase.Send.Interface())
-	assert.NotNil(t, result.fn)
-	result.fn(reflect.ValueOf(1234), true)
-	assert.True(t, funcCalled)
+	result := obj.Error()
+
+	assert.Equal(t, "Panic in NewGoWorker: boom", result)
 }
 
-func TestSelectRecv(t *testing.T) {
-	funcCalled := false
-	channel := make(chan int)
+func TestPanicErrorErrorName(t *testing.T) {
+	obj := &PanicError{Value: "boom", Source: "NewGoWorker", Name: "fetch"}
 
-	result := selectRecv(channel, func(value interface{}, ok bool) {
-		assert.Equal(t, 1234, value)
-		assert.True(t, ok)
-		funcCalled = true
-	})
+	result := obj.Error()
+
+	assert.Equal(t, `Panic in NewGoWorker "fetch": boom`, result)
+}
+
+func TestNameResult(t *testing.T) {
+	result := &Result{Panic: "boom", PanicError: &PanicError{Value: "boom"}}
+
+	nameResult(result, "fetch")
+
+	assert.Equal(t, "fetch", result.PanicError.Name)
+}
+
+func TestNameResultNoPanic(t *testing.T) {
+	result := &Result{Result: "result"}
+
+	nameResult(result, "fetch")
+
+	assert.Nil(t, result.PanicError)
+}
+
+func TestPanicErrorUnwrapError(t *testing.T) {
+	obj := &PanicError{Value: assert.AnError}
+
+	result := obj.Unwrap()
+
+	assert.Same(t, assert.AnError, result)
+	assert.True(t, errors.Is(obj, assert.AnError))
+}
+
+func TestPanicErrorUnwrapNonError(t *testing.T) {
+	obj := &PanicError{Value: "boom"}
+
+	result := obj.Unwrap()
+
+	assert.Nil(t, result)
+}
+
+// page is a result produced by pageRunner.  The final result of an
+// item has a page of -1.
+type page struct {
+	item int
+	page int
+}
 
-	assert.Equal(t, reflect.SelectRecv, result.selectCase.Dir)
-	assert.Equal(t, channel, result.selectCase.Chan.Interface())
-	assert.NotNil(t, result.fn)
-	result.fn(reflect.ValueOf(1234), true)
-	assert.True(t, funcCalled)
-}
-
-func TestDoSelect(t *testing.T) {
-	funcCalled := false
-	channel := make(chan int, 1)
-	channel <- 1234
-	sel := selector{
-		selectCase: reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(channel),
+// pageRunner is a StreamingRunner that emits as many pages as the
+// data item, then returns a final page.  It checks that the pages of
+// each item are integrated in order, with the final page last.
+type pageRunner struct {
+	next  map[int]int
+	bad   int
+	pages int
+}
+
+func (r *pageRunner) Run(data interface{}) interface{} {
+	panic("Run called")
+}
+
+func (r *pageRunner) RunStreaming(data interface{}, emit func(result interface{})) interface{} {
+	n := data.(int)
+	for i := 0; i < n; i++ {
+		emit(page{item: n, page: i})
+	}
+
+	return page{item: n, page: -1}
+}
+
+func (r *pageRunner) Integrate(worker Worker, result *Result) {
+	p := result.Result.(page)
+	if p.page < 0 {
+		if result.Partial || r.next[p.item] != p.item {
+			r.bad++
+		}
+		return
+	}
+
+	if !result.Partial || r.next[p.item] != p.page {
+		r.bad++
+	}
+	r.next[p.item]++
+	r.pages++
+}
+
+func (r *pageRunner) Result() interface{} {
+	return r.pages
+}
+
+func TestRunItemBase(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result")
+
+	result := runItem("test", runner, runHooks{}, "data")
+
+	assert.Equal(t, &Result{Result: "result"}, result)
+	runner.AssertExpectations(t)
+}
+
+func TestRunItemLocal(t *testing.T) {
+	runner := &localRunner{}
+
+	result := runItem("test", runner, runHooks{
+		local: &workerLocal{runner: runner},
+	}, 5)
+
+	assert.Equal(t, &Result{Result: 5}, result)
+	assert.Equal(t, 1, runner.setups)
+}
+
+func TestRunItemSpawn(t *testing.T) {
+	runner := &treeRunner{}
+	spawned := []interface{}{}
+
+	result := runItem("test", runner, runHooks{
+		spawn: func(data interface{}) {
+			spawned = append(spawned, data)
 		},
-		fn: func(value reflect.Value, ok bool) {
-			assert.Equal(t, 1234, value.Interface())
-			assert.True(t, ok)
-			funcCalled = true
+	}, 2)
+
+	assert.Equal(t, &Result{Result: 1}, result)
+	assert.Equal(t, []interface{}{1, 1}, spawned)
+}
+
+func TestRunItemSpawnNoFunc(t *testing.T) {
+	runner := &treeRunner{}
+
+	result := runItem("test", runner, runHooks{}, 2)
+
+	assert.Equal(t, "Run called", result.Panic)
+}
+
+func TestRunItemStream(t *testing.T) {
+	runner := &pageRunner{}
+	emitted := []interface{}{}
+
+	result := runItem("test", runner, runHooks{
+		emit: func(result interface{}) {
+			emitted = append(emitted, result)
 		},
+	}, 2)
+
+	assert.Equal(t, &Result{Result: page{item: 2, page: -1}}, result)
+	assert.Equal(t, []interface{}{
+		page{item: 2, page: 0},
+		page{item: 2, page: 1},
+	}, emitted)
+}
+
+func TestRunItemStreamNoFunc(t *testing.T) {
+	runner := &pageRunner{}
+
+	result := runItem("test", runner, runHooks{}, 2)
+
+	assert.Equal(t, "Run called", result.Panic)
+}
+
+// spawnStreamRunner is a Runner implementing both SpawningRunner and
+// StreamingRunner.
+type spawnStreamRunner struct {
+	MockRunner
+}
+
+func (r *spawnStreamRunner) RunWithSpawner(data interface{}, spawn func(data interface{})) interface{} {
+	return data
+}
+
+func (r *spawnStreamRunner) RunStreaming(data interface{}, emit func(result interface{})) interface{} {
+	return data
+}
+
+func TestCheckRunnerBase(t *testing.T) {
+	for _, runner := range []Runner{&MockRunner{}, &treeRunner{}, &pageRunner{}} {
+		assert.NotPanics(t, func() { checkRunner(runner) })
 	}
+}
+
+func TestCheckRunnerSpawningStreaming(t *testing.T) {
+	assert.PanicsWithValue(t, ErrSpawningStreaming, func() {
+		checkRunner(&spawnStreamRunner{})
+	})
+}
+
+func TestNewRepanickerDisabled(t *testing.T) {
+	result := newRepanicker(&options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewRepanickerEnabled(t *testing.T) {
+	result := newRepanicker(&options{repanic: true})
+
+	assert.Equal(t, &repanicker{}, result)
+}
+
+func TestRepanickerNil(t *testing.T) {
+	var obj *repanicker
+
+	obj.record(&Result{PanicError: &PanicError{}})
+	assert.NotPanics(t, obj.check)
+}
+
+func TestRepanickerNoPanic(t *testing.T) {
+	obj := &repanicker{}
+
+	obj.record(&Result{Result: "result"})
+
+	assert.Nil(t, obj.err)
+	assert.NotPanics(t, obj.check)
+}
+
+func TestRepanickerFirstPanic(t *testing.T) {
+	err1 := &PanicError{Value: "one"}
+	err2 := &PanicError{Value: "two"}
+	obj := &repanicker{}
+
+	obj.record(&Result{Panic: "one", PanicError: err1})
+	obj.record(&Result{Panic: "two", PanicError: err2})
+
+	assert.Same(t, err1, obj.err)
+	assert.PanicsWithValue(t, err1, obj.check)
+}
+
+func TestRepanickerFirst(t *testing.T) {
+	err := &PanicError{Value: "one"}
+	obj := &repanicker{}
+
+	obj.record(&Result{Panic: "one", PanicError: err})
+
+	assert.Same(t, err, obj.first())
+}
+
+func TestRepanickerFirstNoPanic(t *testing.T) {
+	obj := &repanicker{}
+
+	result := obj.first()
+
+	assert.NoError(t, result)
+}
+
+func TestRepanickerFirstNil(t *testing.T) {
+	var obj *repanicker
 
-	doSelect([]selector{sel})
+	result := obj.first()
 
-	assert.True(t, funcCalled)
+	assert.NoError(t, result)
 }
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import "sync"
+
+// completion signals that the final result of a worker or serializer
+// has been computed, closing a channel and calling the functions
+// registered with OnComplete.
+type completion struct {
+	sync.Mutex
+	done   chan struct{}              // Closed on completion; made lazily
+	fired  bool                       // Set once completion is signaled
+	result interface{}                // The final result
+	err    error                      // The final error
+	fns    []func(interface{}, error) // Functions awaiting completion
+}
+
+// newCompletion constructs a completion.
+func newCompletion() *completion {
+	return &completion{}
+}
+
+// channel returns a channel that is closed on completion.
+func (c *completion) channel() <-chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.done == nil {
+		if c.fired {
+			c.done = closedChan
+		} else {
+			c.done = make(chan struct{})
+		}
+	}
+
+	return c.done
+}
+
+// onComplete registers a function to be called on completion.  If
+// completion has already been signaled, the function is called
+// immediately.
+func (c *completion) onComplete(fn func(result interface{}, err error)) {
+	c.Lock()
+	if !c.fired {
+		c.fns = append(c.fns, fn)
+		c.Unlock()
+		return
+	}
+	result, err := c.result, c.err
+	c.Unlock()
+
+	fn(result, err)
+}
+
+// fire signals completion with the final result and error, closing
+// the channel and calling the registered functions in the order they
+// were registered.  Only the first call has any effect.  It is safe
+// to call on a nil completion.
+func (c *completion) fire(result interface{}, err error) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	if c.fired {
+		c.Unlock()
+		return
+	}
+	c.fired = true
+	c.result = result
+	c.err = err
+	if c.done != nil {
+		close(c.done)
+	}
+	fns := c.fns
+	c.fns = nil
+	c.Unlock()
+
+	// Call the functions without holding the lock
+	for _, fn := range fns {
+		fn(result, err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closeRunner is a Runner that counts down from the data it is passed,
+// calling Worker.Call from Integrate, and closes the worker when it
+// integrates the closeAt value.  It records the errors from calling
+// Worker.Call.
+type closeRunner struct {
+	closeAt int
+	total   int
+	errs    []error
+}
+
+func (r *closeRunner) Run(data interface{}) interface{} {
+	return data
+}
+
+func (r *closeRunner) Integrate(worker Worker, result *Result) {
+	n := result.Result.(int)
+	r.total += n
+	if n == r.closeAt {
+		worker.(ClosableWorker).Close()
+	}
+	if n > 0 {
+		r.errs = append(r.errs, worker.Call(n-1))
+	}
+}
+
+func (r *closeRunner) Result() interface{} {
+	return r.total
+}
+
+func TestCompletionChannel(t *testing.T) {
+	obj := newCompletion()
+
+	result := obj.channel()
+
+	assert.False(t, isClosed(result))
+	obj.fire("result", nil)
+	assert.True(t, isClosed(result))
+}
+
+func TestCompletionChannelFired(t *testing.T) {
+	obj := newCompletion()
+	obj.fire("result", nil)
+
+	result := obj.channel()
+
+	assert.True(t, isClosed(result))
+}
+
+func TestCompletionOnComplete(t *testing.T) {
+	err := assert.AnError
+	obj := newCompletion()
+	calls := []string{}
+	obj.onComplete(func(result interface{}, err error) {
+		calls = append(calls, "first")
+		assert.Equal(t, "result", result)
+		assert.Same(t, assert.AnError, err)
+	})
+	obj.onComplete(func(result interface{}, err error) {
+		calls = append(calls, "second")
+	})
+
+	obj.fire("result", err)
+
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Nil(t, obj.fns)
+}
+
+func TestCompletionOnCompleteFired(t *testing.T) {
+	obj := newCompletion()
+	obj.fire("result", nil)
+	var called interface{}
+
+	obj.onComplete(func(result interface{}, err error) {
+		called = result
+	})
+
+	assert.Equal(t, "result", called)
+}
+
+func TestCompletionFireOnce(t *testing.T) {
+	obj := newCompletion()
+	calls := 0
+	obj.onComplete(func(result interface{}, err error) {
+		calls++
+	})
+	obj.fire("first", nil)
+
+	obj.fire("second", nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "first", obj.result)
+}
+
+func TestCompletionFireNil(t *testing.T) {
+	var obj *completion
+
+	assert.NotPanics(t, func() { obj.fire("result", nil) })
+}
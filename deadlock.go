@@ -0,0 +1,349 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Default interval after which the watchdog reports a stall.
+const (
+	defaultWatchdogInterval = time.Minute
+)
+
+// markerDigits is the number of hexadecimal digits of a
+// goroutineMarker identifier encoded in the call chain of a marked
+// goroutine.
+const markerDigits = 8
+
+// markerIDs is the source of goroutineMarker identifiers; it is
+// accessed atomically.
+var markerIDs uint32
+
+// goroutineMarker marks the goroutines running calls into a Runner or
+// Doer, so that re-entrant calls from those goroutines may be
+// detected.  The runtime only exposes goroutine identifiers through
+// formatted stack traces, which are too costly to produce for every
+// data item, so a marked goroutine instead calls through a chain of
+// markDigit frames spelling out the marker's identifier, one
+// hexadecimal digit per frame.  Marking thus costs a few function
+// calls; only contains walks the stack, looking for the chain.  Its
+// methods are safe to call on a nil marker, which marks nothing.
+type goroutineMarker struct {
+	id uint32 // Identifier encoded in the call chain
+}
+
+// newGoroutineMarker constructs a goroutineMarker with a fresh
+// identifier.
+func newGoroutineMarker() *goroutineMarker {
+	return &goroutineMarker{id: atomic.AddUint32(&markerIDs, 1)}
+}
+
+// run calls the function with the calling goroutine marked.
+func (m *goroutineMarker) run(fn func()) {
+	if m == nil {
+		fn()
+		return
+	}
+
+	markDigit(m.id, markerDigits, fn)
+}
+
+// contains returns true if the calling goroutine is running a
+// function passed to run.
+func (m *goroutineMarker) contains() bool {
+	if m == nil {
+		return false
+	}
+
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+
+	// Look for the innermost frame of each chain, then decode it
+	for i, pc := range pcs {
+		if pc-markEntry != markEndOffset {
+			continue
+		}
+		if id, ok := markerID(pcs[i+1:]); ok && id == m.id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markDigit encodes the low n hexadecimal digits of the identifier in
+// the call chain, most significant first, then calls the function.
+// Each digit selects a different call site, so the digit is recovered
+// from the return address of the frame.
+//
+//go:noinline
+func markDigit(id uint32, n uint, fn func()) {
+	if n == 0 {
+		fn()
+		return
+	}
+
+	n--
+	switch id >> (4 * n) & 0xf {
+	case 0x0:
+		markDigit(id, n, fn)
+	case 0x1:
+		markDigit(id, n, fn)
+	case 0x2:
+		markDigit(id, n, fn)
+	case 0x3:
+		markDigit(id, n, fn)
+	case 0x4:
+		markDigit(id, n, fn)
+	case 0x5:
+		markDigit(id, n, fn)
+	case 0x6:
+		markDigit(id, n, fn)
+	case 0x7:
+		markDigit(id, n, fn)
+	case 0x8:
+		markDigit(id, n, fn)
+	case 0x9:
+		markDigit(id, n, fn)
+	case 0xa:
+		markDigit(id, n, fn)
+	case 0xb:
+		markDigit(id, n, fn)
+	case 0xc:
+		markDigit(id, n, fn)
+	case 0xd:
+		markDigit(id, n, fn)
+	case 0xe:
+		markDigit(id, n, fn)
+	default:
+		markDigit(id, n, fn)
+	}
+}
+
+// Layout of markDigit, for decoding its frames: the entry point, the
+// offset of the return address of the call to the marked function,
+// and the offsets of the return addresses encoding each digit.  These
+// are found by probing, in init.
+var (
+	markEntry     uintptr
+	markEndOffset uintptr
+	markOffsets   [16]uintptr
+)
+
+func init() {
+	for digit := range markOffsets {
+		var pcs [3]uintptr
+		markDigit(uint32(digit), 1, func() {
+			runtime.Callers(2, pcs[:])
+		})
+
+		markEntry = funcEntry(pcs[0])
+		markEndOffset = pcs[0] - markEntry
+		markOffsets[digit] = pcs[1] - markEntry
+	}
+}
+
+// funcEntry returns the entry point of the function containing the
+// return address, as returned by runtime.Callers.
+func funcEntry(pc uintptr) uintptr {
+	if fn := runtime.FuncForPC(pc - 1); fn != nil {
+		return fn.Entry()
+	}
+
+	return 0
+}
+
+// markerID decodes the identifier from the frames following the
+// innermost markDigit frame of a chain, least significant digit
+// first.  It returns false if the frames do not form a complete
+// chain.
+func markerID(pcs []uintptr) (uint32, bool) {
+	if len(pcs) < markerDigits {
+		return 0, false
+	}
+
+	var id uint32
+	for n, pc := range pcs[:markerDigits] {
+		digit := digitOf(pc - markEntry)
+		if digit < 0 {
+			return 0, false
+		}
+		id |= uint32(digit) << (4 * uint(n))
+	}
+
+	return id, true
+}
+
+// digitOf returns the digit encoded by a markDigit frame with the
+// return address offset, or -1 if it does not encode one.
+func digitOf(offset uintptr) int {
+	for digit, o := range markOffsets {
+		if offset == o {
+			return digit
+		}
+	}
+
+	return -1
+}
+
+// watchdog implements the WithWatchdog option.  It counts the items
+// accepted and completed, and if items are outstanding but none has
+// completed for a full interval, it writes a description of the stall
+// and the stacks of all goroutines to the writer.
+type watchdog struct {
+	accepted  int64         // Items accepted; accessed atomically
+	completed int64         // Items completed; accessed atomically
+	kind      string        // The constructor that created the object
+	interval  time.Duration // Interval without progress to report
+	out       io.Writer     // Where to write the report
+	stop      chan struct{} // Closed to stop the watchdog
+	done      chan struct{} // Closed when the watchdog exits
+}
+
+// newWatchdog constructs a watchdog from the options, or returns nil
+// if the watchdog was not requested.
+func newWatchdog(kind string, opts *options) *watchdog {
+	if !opts.watchdog {
+		return nil
+	}
+
+	interval := opts.watchdogInterval
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	out := opts.watchdogOut
+	if out == nil {
+		out = os.Stderr
+	}
+
+	return &watchdog{
+		kind:     kind,
+		interval: interval,
+		out:      out,
+	}
+}
+
+// accept records that an item has been accepted.  It is safe to call
+// on a nil watchdog.
+func (d *watchdog) accept() {
+	if d != nil {
+		atomic.AddInt64(&d.accepted, 1)
+	}
+}
+
+// complete records that an item has been completed.  It is safe to
+// call on a nil watchdog.
+func (d *watchdog) complete() {
+	if d != nil {
+		atomic.AddInt64(&d.completed, 1)
+	}
+}
+
+// check is called on each tick of the watchdog.  It is passed the
+// number of items completed and whether items were outstanding at the
+// previous tick, and returns the same values for the current tick.
+// A stall is reported if items were outstanding at both ticks and
+// none completed in between, unless it has already been reported.
+func (d *watchdog) check(last int64, waiting, reported bool) (int64, bool, bool) {
+	accepted := atomic.LoadInt64(&d.accepted)
+	completed := atomic.LoadInt64(&d.completed)
+
+	// Progress was made, or there's nothing to wait for
+	if completed != last || accepted == completed {
+		return completed, accepted != completed, false
+	}
+
+	if waiting && !reported {
+		d.report(accepted, completed)
+		reported = true
+	}
+
+	return completed, true, reported
+}
+
+// report writes a description of a stall to the writer.
+func (d *watchdog) report(accepted, completed int64) {
+	fmt.Fprintf(d.out, "parallelizer: %s has made no progress for %s: %d items accepted, %d completed, %d outstanding\n\n%s\n",
+		d.kind, d.interval, accepted, completed, accepted-completed, allStacks())
+}
+
+// run is the watchdog goroutine.
+func (d *watchdog) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	last := atomic.LoadInt64(&d.completed)
+	waiting, reported := false, false
+	for {
+		select {
+		case <-ticker.C:
+			last, waiting, reported = d.check(last, waiting, reported)
+
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// start starts the watchdog goroutine.  It is safe to call on a nil
+// watchdog.
+func (d *watchdog) start() {
+	if d == nil {
+		return
+	}
+
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+
+	go d.run()
+}
+
+// finish stops the watchdog goroutine, if it was started.  It is safe
+// to call on a nil watchdog.
+func (d *watchdog) finish() {
+	if d == nil || d.stop == nil {
+		return
+	}
+
+	close(d.stop)
+	<-d.done
+}
+
+// allStacks returns the stacks of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedBuffer is a bytes.Buffer that may be written and read from
+// different goroutines.
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.buf.String()
+}
+
+// goid returns the identifier of the calling goroutine, parsed from
+// the header of its stack trace, for tests that check which goroutine
+// ran a call.
+func goid() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+
+	return id
+}
+
+func TestNewGoroutineMarker(t *testing.T) {
+	obj1 := newGoroutineMarker()
+	obj2 := newGoroutineMarker()
+
+	assert.NotEqual(t, obj1.id, obj2.id)
+}
+
+func TestGoroutineMarker(t *testing.T) {
+	obj := newGoroutineMarker()
+	other := newGoroutineMarker()
+	results := make(chan bool, 1)
+	marked := []bool{}
+
+	obj.run(func() {
+		marked = append(marked, obj.contains(), other.contains())
+		go func() { results <- obj.contains() }()
+		marked = append(marked, <-results)
+	})
+
+	assert.Equal(t, []bool{true, false, false}, marked)
+	assert.False(t, obj.contains())
+}
+
+func TestGoroutineMarkerNested(t *testing.T) {
+	outer := &goroutineMarker{id: 0xffffffff}
+	inner := &goroutineMarker{id: 0}
+	other := &goroutineMarker{id: 0x7fffffff}
+	marked := []bool{}
+
+	outer.run(func() {
+		inner.run(func() {
+			marked = append(marked, outer.contains(), inner.contains(), other.contains())
+		})
+	})
+
+	assert.Equal(t, []bool{true, true, false}, marked)
+}
+
+func TestGoroutineMarkerNil(t *testing.T) {
+	var obj *goroutineMarker
+	called := false
+
+	obj.run(func() {
+		called = true
+		assert.False(t, obj.contains())
+	})
+
+	assert.True(t, called)
+}
+
+func TestMarkDigitOffsets(t *testing.T) {
+	seen := map[uintptr]bool{markEndOffset: true}
+
+	for _, offset := range markOffsets {
+		assert.False(t, seen[offset])
+		seen[offset] = true
+	}
+
+	assert.NotZero(t, markEntry)
+}
+
+func TestMarkerIDIncomplete(t *testing.T) {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(1, pcs)
+
+	_, ok := markerID(pcs[:n])
+
+	assert.False(t, ok)
+}
+
+func TestNewWatchdogDisabled(t *testing.T) {
+	result := newWatchdog("kind", &options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewWatchdogBase(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	result := newWatchdog("kind", &options{
+		watchdog:         true,
+		watchdogInterval: time.Second,
+		watchdogOut:      out,
+	})
+
+	assert.Equal(t, &watchdog{
+		kind:     "kind",
+		interval: time.Second,
+		out:      out,
+	}, result)
+}
+
+func TestNewWatchdogDefaults(t *testing.T) {
+	result := newWatchdog("kind", &options{watchdog: true})
+
+	assert.Equal(t, &watchdog{
+		kind:     "kind",
+		interval: defaultWatchdogInterval,
+		out:      os.Stderr,
+	}, result)
+}
+
+func TestWatchdogNil(t *testing.T) {
+	var obj *watchdog
+
+	obj.accept()
+	obj.complete()
+	obj.start()
+	obj.finish()
+}
+
+func TestWatchdogAcceptComplete(t *testing.T) {
+	obj := &watchdog{}
+
+	obj.accept()
+	obj.accept()
+	obj.complete()
+
+	assert.Equal(t, int64(2), obj.accepted)
+	assert.Equal(t, int64(1), obj.completed)
+}
+
+func TestWatchdogCheckIdle(t *testing.T) {
+	out := &bytes.Buffer{}
+	obj := &watchdog{accepted: 3, completed: 3, out: out}
+
+	last, waiting, reported := obj.check(3, true, true)
+
+	assert.Equal(t, int64(3), last)
+	assert.False(t, waiting)
+	assert.False(t, reported)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestWatchdogCheckProgress(t *testing.T) {
+	out := &bytes.Buffer{}
+	obj := &watchdog{accepted: 3, completed: 2, out: out}
+
+	last, waiting, reported := obj.check(1, true, true)
+
+	assert.Equal(t, int64(2), last)
+	assert.True(t, waiting)
+	assert.False(t, reported)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestWatchdogCheckFirstTick(t *testing.T) {
+	out := &bytes.Buffer{}
+	obj := &watchdog{accepted: 3, completed: 2, out: out}
+
+	last, waiting, reported := obj.check(2, false, false)
+
+	assert.Equal(t, int64(2), last)
+	assert.True(t, waiting)
+	assert.False(t, reported)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestWatchdogCheckStalled(t *testing.T) {
+	out := &bytes.Buffer{}
+	obj := &watchdog{accepted: 3, completed: 2, kind: "kind", interval: time.Second, out: out}
+
+	last, waiting, reported := obj.check(2, true, false)
+
+	assert.Equal(t, int64(2), last)
+	assert.True(t, waiting)
+	assert.True(t, reported)
+	assert.True(t, strings.HasPrefix(out.String(), "parallelizer: kind has made no progress for 1s: 3 items accepted, 2 completed, 1 outstanding\n\ngoroutine "))
+	assert.Contains(t, out.String(), "TestWatchdogCheckStalled")
+}
+
+func TestWatchdogCheckAlreadyReported(t *testing.T) {
+	out := &bytes.Buffer{}
+	obj := &watchdog{accepted: 3, completed: 2, out: out}
+
+	last, waiting, reported := obj.check(2, true, true)
+
+	assert.Equal(t, int64(2), last)
+	assert.True(t, waiting)
+	assert.True(t, reported)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestWatchdogStartFinish(t *testing.T) {
+	out := &lockedBuffer{}
+	obj := &watchdog{kind: "kind", interval: time.Millisecond, out: out}
+	obj.accept()
+
+	obj.start()
+	require.Eventually(t, func() bool {
+		return out.String() != ""
+	}, time.Second, time.Millisecond)
+	obj.finish()
+
+	assert.Equal(t, 1, strings.Count(out.String(), "has made no progress"))
+	select {
+	case <-obj.done:
+	default:
+		assert.Fail(t, "watchdog still running")
+	}
+}
+
+func TestGoWorkerWaitFromRun(t *testing.T) {
+	errs := make(chan error, 1)
+	runner := &MockRunner{}
+	obj := NewGoWorker(runner, 1)
+	runner.On("Run", "data").Return("result").Run(func(args mock.Arguments) {
+		_, err := obj.Wait()
+		errs <- err
+	})
+	runner.On("Integrate", obj, &Result{Result: "result"})
+	runner.On("Result").Return("final")
+	require.NoError(t, obj.Call("data"))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "final", result)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerWaitFromIntegrate(t *testing.T) {
+	errs := make(chan error, 1)
+	runner := &MockRunner{}
+	obj := NewGoWorker(runner, 1)
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"}).Run(func(args mock.Arguments) {
+		_, err := args[0].(Worker).Wait()
+		errs <- err
+	})
+	runner.On("Result").Return("final")
+	require.NoError(t, obj.Call("data"))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "final", result)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerWatchdog(t *testing.T) {
+	out := &lockedBuffer{}
+	gate := make(chan struct{})
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result").Run(func(args mock.Arguments) {
+		<-gate
+	})
+	runner.On("Integrate", mock.Anything, &Result{Result: "result"})
+	runner.On("Result").Return("final")
+	obj := NewGoWorker(runner, 1, WithWatchdog(time.Millisecond, out))
+	require.NoError(t, obj.Call("data"))
+
+	require.Eventually(t, func() bool {
+		return out.String() != ""
+	}, time.Second, time.Millisecond)
+	close(gate)
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "final", result)
+	assert.Contains(t, out.String(), "parallelizer: NewGoWorker has made no progress for 1ms: 1 items accepted, 0 completed, 1 outstanding")
+	runner.AssertExpectations(t)
+}
+
+func TestSerializerCallFromDo(t *testing.T) {
+	errs := make(chan error, 2)
+	doer := &MockDoer{}
+	obj := NewSerializer(doer)
+	doer.On("Do", "data").Return("result").Run(func(args mock.Arguments) {
+		_, err := obj.Call("other")
+		errs <- err
+		_, err = obj.(ContextSerializer).CallContext(context.Background(), "other")
+		errs <- err
+	})
+	doer.On("Finish").Return("final")
+
+	result, err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	assert.Equal(t, "final", obj.Wait())
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCallAsyncFromDo(t *testing.T) {
+	errs := make(chan error, 2)
+	doer := &MockDoer{}
+	obj := NewSerializer(doer)
+	doer.On("Do", "data").Return("result").Run(func(args mock.Arguments) {
+		cr, err := obj.CallAsync("other")
+		assert.Nil(t, cr)
+		errs <- err
+		errs <- obj.CallOnly("other")
+	})
+	doer.On("Finish").Return("final")
+
+	result, err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	assert.Same(t, ErrWouldDeadlock, <-errs)
+	assert.Equal(t, "final", obj.Wait())
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerWaitFromDo(t *testing.T) {
+	doer := &MockDoer{}
+	obj := NewSerializer(doer)
+	doer.On("Do", "data").Return("result").Run(func(args mock.Arguments) {
+		obj.Wait()
+	})
+	doer.On("Finish").Return("final")
+
+	result, err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Same(t, ErrWouldDeadlock, result.Panic)
+	assert.Equal(t, "final", obj.Wait())
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerWatchdog(t *testing.T) {
+	out := &lockedBuffer{}
+	gate := make(chan struct{})
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return("result").Run(func(args mock.Arguments) {
+		<-gate
+	})
+	doer.On("Finish").Return("final")
+	obj := NewSerializer(doer, WithWatchdog(time.Millisecond, out))
+	require.NoError(t, obj.CallOnly("data"))
+
+	require.Eventually(t, func() bool {
+		return out.String() != ""
+	}, time.Second, time.Millisecond)
+	close(gate)
+	result := obj.Wait()
+
+	assert.Equal(t, "final", result)
+	assert.Contains(t, out.String(), "parallelizer: NewSerializer has made no progress for 1ms: 1 items accepted, 0 completed, 1 outstanding")
+	doer.AssertExpectations(t)
+}
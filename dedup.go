@@ -0,0 +1,143 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupDone describes a remembered completed data item.
+type dedupDone struct {
+	result  *Result   // The result of the item
+	expires time.Time // When to forget the item; zero for never
+}
+
+// deduper implements duplicate suppression for the workers.  Data
+// items are claimed before they are worked, and released after they
+// have been integrated; items with keys that are in flight, or that
+// have been remembered, are suppressed.
+type deduper struct {
+	sync.Mutex
+	key        KeyFunc                          // Key function
+	remember   bool                             // Remember completed keys
+	ttl        time.Duration                    // How long to remember keys
+	inflight   map[interface{}][]chan<- *Result // Waiters for in-flight keys
+	done       map[interface{}]dedupDone        // Remembered completed keys
+	duplicates int64                            // Number of suppressed items
+	nextSweep  time.Time                        // When to next sweep expired keys
+	now        func() time.Time                 // Source of the current time
+}
+
+// newDeduper constructs a deduper from the options, or returns nil if
+// duplicate suppression was not requested.
+func newDeduper(opts *options) *deduper {
+	if opts.dedupKey == nil {
+		return nil
+	}
+
+	return &deduper{
+		key:      opts.dedupKey,
+		remember: opts.dedupRemember,
+		ttl:      opts.dedupTTL,
+		inflight: map[interface{}][]chan<- *Result{},
+		done:     map[interface{}]dedupDone{},
+		now:      time.Now,
+	}
+}
+
+// claim computes the key for a work item and determines whether it
+// should be worked.  If it returns false, the item is a duplicate and
+// has been suppressed; its result channel, if any, will receive the
+// result of the original item.
+func (d *deduper) claim(item *workItem) bool {
+	item.key = d.key(item.data)
+
+	d.Lock()
+	defer d.Unlock()
+
+	// Is the item in flight?
+	if waiters, ok := d.inflight[item.key]; ok {
+		d.duplicates++
+		if item.result != nil {
+			d.inflight[item.key] = append(waiters, item.result)
+		}
+		return false
+	}
+
+	// Has the item already been completed?
+	if done, ok := d.done[item.key]; ok {
+		if done.expires.IsZero() || d.now().Before(done.expires) {
+			d.duplicates++
+			item.resolve(done.result)
+			return false
+		}
+
+		// Expired; forget it
+		delete(d.done, item.key)
+	}
+
+	// Claim the key
+	d.inflight[item.key] = nil
+	return true
+}
+
+// release is called once a claimed work item has been integrated.  It
+// sends the result to the waiting duplicates, and remembers the key
+// if requested.
+func (d *deduper) release(item *workItem, result *Result) {
+	d.Lock()
+	defer d.Unlock()
+
+	// Notify the waiters
+	for _, waiter := range d.inflight[item.key] {
+		waiter <- result
+	}
+	delete(d.inflight, item.key)
+
+	// Remember the key
+	if d.remember {
+		done := dedupDone{result: result}
+		if d.ttl > 0 {
+			now := d.now()
+			done.expires = now.Add(d.ttl)
+			d.sweep(now)
+		}
+		d.done[item.key] = done
+	}
+}
+
+// sweep forgets remembered keys that have expired.  To bound the cost,
+// a sweep is only performed once per TTL interval.
+func (d *deduper) sweep(now time.Time) {
+	if now.Before(d.nextSweep) {
+		return
+	}
+	d.nextSweep = now.Add(d.ttl)
+
+	for key, done := range d.done {
+		if !now.Before(done.expires) {
+			delete(d.done, key)
+		}
+	}
+}
+
+// count returns the number of suppressed duplicates.
+func (d *deduper) count() int64 {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.duplicates
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDeduperDisabled(t *testing.T) {
+	result := newDeduper(&options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewDeduperEnabled(t *testing.T) {
+	result := newDeduper(&options{
+		dedupKey:      identityKey,
+		dedupRemember: true,
+		dedupTTL:      time.Second,
+	})
+
+	require.NotNil(t, result)
+	assert.NotNil(t, result.key)
+	assert.True(t, result.remember)
+	assert.Equal(t, time.Second, result.ttl)
+	assert.NotNil(t, result.inflight)
+	assert.NotNil(t, result.done)
+	assert.NotNil(t, result.now)
+}
+
+func TestDeduperClaimNew(t *testing.T) {
+	obj := newDeduper(&options{dedupKey: identityKey})
+	item := &workItem{data: "data"}
+
+	result := obj.claim(item)
+
+	assert.True(t, result)
+	assert.Equal(t, "data", item.key)
+	assert.Contains(t, obj.inflight, "data")
+	assert.Equal(t, int64(0), obj.duplicates)
+}
+
+func TestDeduperClaimInFlight(t *testing.T) {
+	obj := newDeduper(&options{dedupKey: identityKey})
+	obj.claim(&workItem{data: "data"})
+	item, cr := newAsyncItem("data")
+
+	result := obj.claim(item)
+
+	assert.False(t, result)
+	assert.Len(t, obj.inflight["data"], 1)
+	assert.Equal(t, int64(1), obj.duplicates)
+	_, ok := cr.TryWait()
+	assert.True(t, ok)
+}
+
+func TestDeduperClaimRemembered(t *testing.T) {
+	obj := newDeduper(&options{dedupKey: identityKey})
+	obj.done["data"] = dedupDone{result: &Result{Result: "result"}}
+	item, cr := newAsyncItem("data")
+
+	result := obj.claim(item)
+
+	assert.False(t, result)
+	assert.Equal(t, int64(1), obj.duplicates)
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+}
+
+func TestDeduperClaimUnexpired(t *testing.T) {
+	now := time.Now()
+	obj := newDeduper(&options{dedupKey: identityKey})
+	obj.now = func() time.Time { return now }
+	obj.done["data"] = dedupDone{expires: now.Add(time.Second)}
+
+	result := obj.claim(&workItem{data: "data"})
+
+	assert.False(t, result)
+	assert.Equal(t, int64(1), obj.duplicates)
+}
+
+func TestDeduperClaimExpired(t *testing.T) {
+	now := time.Now()
+	obj := newDeduper(&options{dedupKey: identityKey})
+	obj.now = func() time.Time { return now }
+	obj.done["data"] = dedupDone{expires: now}
+
+	result := obj.claim(&workItem{data: "data"})
+
+	assert.True(t, result)
+	assert.NotContains(t, obj.done, "data")
+	assert.Equal(t, int64(0), obj.duplicates)
+}
+
+func TestDeduperReleaseBase(t *testing.T) {
+	obj := newDeduper(&options{dedupKey: identityKey})
+	item := &workItem{data: "data"}
+	obj.claim(item)
+	dup, cr := newAsyncItem("data")
+	obj.claim(dup)
+
+	obj.release(item, &Result{Result: "result"})
+
+	assert.NotContains(t, obj.inflight, "data")
+	assert.NotContains(t, obj.done, "data")
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+}
+
+func TestDeduperReleaseRemember(t *testing.T) {
+	obj := newDeduper(&options{
+		dedupKey:      identityKey,
+		dedupRemember: true,
+	})
+	item := &workItem{data: "data"}
+	obj.claim(item)
+
+	obj.release(item, &Result{Result: "result"})
+
+	assert.Equal(t, dedupDone{result: &Result{Result: "result"}}, obj.done["data"])
+}
+
+func TestDeduperReleaseRememberTTL(t *testing.T) {
+	now := time.Now()
+	obj := newDeduper(&options{
+		dedupKey:      identityKey,
+		dedupRemember: true,
+		dedupTTL:      time.Second,
+	})
+	obj.now = func() time.Time { return now }
+	obj.done["old"] = dedupDone{expires: now}
+	item := &workItem{data: "data"}
+	obj.claim(item)
+
+	obj.release(item, &Result{Result: "result"})
+
+	assert.Equal(t, dedupDone{
+		result:  &Result{Result: "result"},
+		expires: now.Add(time.Second),
+	}, obj.done["data"])
+	assert.NotContains(t, obj.done, "old")
+	assert.Equal(t, now.Add(time.Second), obj.nextSweep)
+}
+
+func TestDeduperSweepSkipped(t *testing.T) {
+	now := time.Now()
+	obj := newDeduper(&options{dedupKey: identityKey})
+	obj.nextSweep = now.Add(time.Second)
+	obj.done["old"] = dedupDone{expires: now}
+
+	obj.sweep(now)
+
+	assert.Contains(t, obj.done, "old")
+}
+
+func TestDeduperCount(t *testing.T) {
+	obj := &deduper{duplicates: 3}
+
+	result := obj.count()
+
+	assert.Equal(t, int64(3), result)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Maximum size of a frame.
+const maxFrameSize = 64 << 20
+
+// ErrFrameTooLarge is returned when a frame exceeding the maximum
+// frame size of 64 MiB is sent or received.
+var ErrFrameTooLarge = errors.New("Frame exceeds maximum frame size")
+
+// writeFrame writes a frame, consisting of a 4-byte big-endian length
+// followed by the payload.
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame.  It returns io.EOF
+// if the reader is at a clean end of stream, and
+// io.ErrUnexpectedEOF if the stream ends in the middle of a frame.
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	err1 := writeFrame(buf, []byte("one"))
+	err2 := writeFrame(buf, []byte{})
+	result1, err3 := readFrame(buf)
+	result2, err4 := readFrame(buf)
+	_, err5 := readFrame(buf)
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.NoError(t, err3)
+	assert.NoError(t, err4)
+	assert.Equal(t, io.EOF, err5)
+	assert.Equal(t, []byte("one"), result1)
+	assert.Equal(t, []byte{}, result2)
+}
+
+func TestWriteFrameTooLarge(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	err := writeFrame(buf, make([]byte, maxFrameSize+1))
+
+	assert.Same(t, ErrFrameTooLarge, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	buf := bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})
+
+	_, err := readFrame(buf)
+
+	assert.Same(t, ErrFrameTooLarge, err)
+}
+
+func TestReadFrameShortHeader(t *testing.T) {
+	buf := bytes.NewReader([]byte{0, 0})
+
+	_, err := readFrame(buf)
+
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadFrameShortPayload(t *testing.T) {
+	buf := bytes.NewReader([]byte{0, 0, 0, 4, 'a'})
+
+	_, err := readFrame(buf)
+
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadFrameNoPayload(t *testing.T) {
+	buf := bytes.NewReader([]byte{0, 0, 0, 4})
+
+	_, err := readFrame(buf)
+
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
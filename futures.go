@@ -0,0 +1,281 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// selectable is implemented by CallResult implementations that allow
+// their result channel to be selected on without closing the
+// CallResult.  This allows WaitAny to leave the CallResult objects it
+// did not select untouched.
+type selectable interface {
+	// selectChannel returns the channel to select on, or nil if
+	// the CallResult has been closed.
+	selectChannel() <-chan *Result
+
+	// selected is called when the channel returned by
+	// selectChannel has been received from.  It closes the
+	// CallResult.
+	selected()
+}
+
+// WaitAll waits for all the specified CallResult objects and returns
+// their results, in the same order as the CallResult objects.
+func WaitAll(results ...CallResult) []*Result {
+	responses := make([]*Result, len(results))
+	for i, cr := range results {
+		responses[i] = cr.Wait()
+	}
+
+	return responses
+}
+
+// WaitAllContext is a variant of WaitAll that gives up waiting when
+// the context is canceled or its deadline expires.  In that case, the
+// results retrieved so far are returned, along with the context's
+// error; calls that have not yet been passed to Doer.Do are skipped
+// entirely.  CallResult objects that do not implement
+// ContextCallResult are waited upon through their Channel method.
+func WaitAllContext(ctx context.Context, results ...CallResult) ([]*Result, error) {
+	responses := make([]*Result, len(results))
+	for i, cr := range results {
+		response, err := waitContext(ctx, cr)
+		if err != nil {
+			// Give up on the rest of them
+			for _, rest := range results[i+1:] {
+				waitContext(ctx, rest)
+			}
+			return responses, err
+		}
+		responses[i] = response
+	}
+
+	return responses, nil
+}
+
+// waitContext is a helper for WaitAllContext that waits for the result
+// of a CallResult, giving up when the context is done.  If the
+// CallResult does not implement ContextCallResult, its Channel method
+// is used to wait for the result.
+func waitContext(ctx context.Context, cr CallResult) (*Result, error) {
+	if ccr, ok := cr.(ContextCallResult); ok {
+		return ccr.WaitContext(ctx)
+	}
+
+	// Select on the channel; a nil channel means the CallResult
+	// was already closed
+	channel := cr.Channel()
+	if channel == nil {
+		return nil, nil
+	}
+	select {
+	case response := <-channel:
+		return response, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WaitAny waits for the first of the specified CallResult objects to
+// complete, and returns its index and its result.  Only the selected
+// CallResult is closed; the others may still be waited upon later.
+// (Other CallResult implementations, such as those provided by
+// MockCallResult, are closed through their Channel method, and so
+// should not be waited upon again; this does not apply to
+// SharedCallResult objects, which are never closed.)  If there are no
+// CallResult objects that have not already been closed, WaitAny
+// returns an index of -1 and a nil result.
+func WaitAny(results ...CallResult) (int, *Result) {
+	// Build the list of select cases
+	cases := make([]reflect.SelectCase, 0, len(results))
+	indexes := make([]int, 0, len(results))
+	for i, cr := range results {
+		var channel <-chan *Result
+		if sel, ok := cr.(selectable); ok {
+			channel = sel.selectChannel()
+		} else {
+			channel = cr.Channel()
+		}
+
+		// Skip closed CallResult objects
+		if channel == nil {
+			continue
+		}
+
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(channel),
+		})
+		indexes = append(indexes, i)
+	}
+
+	// Nothing to wait on?
+	if len(cases) <= 0 {
+		return -1, nil
+	}
+
+	// Run the select and close the selected CallResult
+	chosen, value, ok := reflect.Select(cases)
+	idx := indexes[chosen]
+	if sel, isSel := results[idx].(selectable); isSel {
+		sel.selected()
+	}
+	if !ok {
+		return idx, nil
+	}
+
+	return idx, value.Interface().(*Result)
+}
+
+// Then constructs a derived CallResult.  Once the result of the
+// specified CallResult becomes available, it is passed to the
+// specified function, and the return value of that function becomes
+// the result of the derived CallResult.  A panic in the function is
+// captured in the same way as panics in Doer.Do.  The function runs
+// in a separate goroutine, and will not be called if the original
+// CallResult yields a nil result; in that case, the derived
+// CallResult will also yield a nil result.
+func Then(cr CallResult, fn func(result *Result) interface{}) CallResult {
+	response := make(chan *Result, 1)
+
+	go func() {
+		result := cr.Wait()
+		if result == nil {
+			close(response)
+			return
+		}
+
+		response <- panicer("Then", func(data interface{}) interface{} {
+			return fn(data.(*Result))
+		}, result)
+	}()
+
+	return &callResult{response: response}
+}
+
+// sharedCallResult is an implementation of the SharedCallResult
+// interface.
+type sharedCallResult struct {
+	sync.Mutex
+	done      chan struct{}    // Closed when the result is available
+	result    *Result          // The cached result
+	listeners []chan<- *Result // Channels returned by Channel
+}
+
+// Share constructs a SharedCallResult from a CallResult.  The
+// SharedCallResult takes ownership of the CallResult; a goroutine is
+// started to wait for its result, which is then cached in the
+// SharedCallResult.  If the CallResult is already a SharedCallResult,
+// it is returned unchanged.
+func Share(cr CallResult) SharedCallResult {
+	// Don't double-wrap
+	if shared, ok := cr.(SharedCallResult); ok {
+		return shared
+	}
+
+	obj := &sharedCallResult{
+		done: make(chan struct{}),
+	}
+
+	// Wait for the result
+	go obj.receive(cr)
+
+	return obj
+}
+
+// receive waits for the result of the wrapped CallResult, caches it,
+// and notifies all waiters.
+func (c *sharedCallResult) receive(cr CallResult) {
+	result := cr.Wait()
+
+	c.Lock()
+	defer c.Unlock()
+
+	// Save the result and signal the waiters
+	c.result = result
+	close(c.done)
+	for _, listener := range c.listeners {
+		listener <- result
+	}
+	c.listeners = nil
+}
+
+// Wait is used to retrieve the result of the call.  The result is
+// cached, so subsequent calls to Wait will return the same result.
+func (c *sharedCallResult) Wait() *Result {
+	<-c.done
+
+	return c.result
+}
+
+// WaitContext is a variant of Wait that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  Since other goroutines may be waiting for the
+// result, the call is not skipped when the caller gives up.
+func (c *sharedCallResult) WaitContext(ctx context.Context) (*Result, error) {
+	select {
+	case <-c.done:
+		return c.result, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TryWait is a non-blocking variant of Wait.  It returns the result,
+// if it is available, and a boolean value that is always true, since
+// a SharedCallResult is never closed.
+func (c *sharedCallResult) TryWait() (*Result, bool) {
+	select {
+	case <-c.done:
+		return c.result, true
+
+	default:
+		return nil, true
+	}
+}
+
+// Channel returns a channel that will receive the result of the call
+// once it is available.  Each call to Channel returns a new channel,
+// and a SharedCallResult is never closed, so Channel may be called
+// any number of times.
+func (c *sharedCallResult) Channel() <-chan *Result {
+	c.Lock()
+	defer c.Unlock()
+
+	// Construct the channel
+	channel := make(chan *Result, 1)
+	select {
+	case <-c.done:
+		channel <- c.result
+
+	default:
+		c.listeners = append(c.listeners, channel)
+	}
+
+	return channel
+}
+
+// Done returns a channel that is closed once the result of the call
+// is available.  It may be used to select on the completion of the
+// call from any number of goroutines.
+func (c *sharedCallResult) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readyCallResult(result *Result) *callResult {
+	response := make(chan *Result, 1)
+	response <- result
+	return &callResult{response: response}
+}
+
+func TestCallResultImplementsSelectable(t *testing.T) {
+	assert.Implements(t, (*selectable)(nil), &callResult{})
+}
+
+func TestCallResultSelectChannelBase(t *testing.T) {
+	response := make(chan *Result, 1)
+	obj := &callResult{response: response}
+
+	channel := obj.selectChannel()
+
+	assert.Equal(t, (<-chan *Result)(response), channel)
+	assert.False(t, obj.closed)
+}
+
+func TestCallResultSelectChannelClosed(t *testing.T) {
+	obj := &callResult{
+		response: make(chan *Result, 1),
+		closed:   true,
+	}
+
+	channel := obj.selectChannel()
+
+	assert.Nil(t, channel)
+}
+
+func TestCallResultSelected(t *testing.T) {
+	obj := &callResult{}
+
+	obj.selected()
+
+	assert.True(t, obj.closed)
+}
+
+func TestWaitAll(t *testing.T) {
+	cr1 := readyCallResult(&Result{Result: 1})
+	cr2 := readyCallResult(&Result{Result: 2})
+
+	result := WaitAll(cr1, cr2)
+
+	assert.Equal(t, []*Result{{Result: 1}, {Result: 2}}, result)
+	assert.True(t, cr1.closed)
+	assert.True(t, cr2.closed)
+}
+
+func TestWaitAllContextBase(t *testing.T) {
+	cr1 := readyCallResult(&Result{Result: 1})
+	cr2 := readyCallResult(&Result{Result: 2})
+
+	result, err := WaitAllContext(context.Background(), cr1, cr2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Result{{Result: 1}, {Result: 2}}, result)
+}
+
+func TestWaitAllContextCanceled(t *testing.T) {
+	canceled := false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cr1 := &callResult{response: make(chan *Result, 1)}
+	cr2 := &callResult{
+		response: make(chan *Result, 1),
+		cancel: func() {
+			canceled = true
+		},
+	}
+
+	result, err := WaitAllContext(ctx, cr1, cr2)
+
+	assert.Same(t, context.Canceled, err)
+	assert.Equal(t, []*Result{nil, nil}, result)
+	assert.True(t, cr1.closed)
+	assert.True(t, cr2.closed)
+	assert.True(t, canceled)
+}
+
+func TestWaitAllContextChannel(t *testing.T) {
+	cr1 := struct{ CallResult }{readyCallResult(&Result{Result: 1})}
+	cr2 := struct{ CallResult }{readyCallResult(&Result{Result: 2})}
+
+	result, err := WaitAllContext(context.Background(), cr1, cr2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Result{{Result: 1}, {Result: 2}}, result)
+}
+
+func TestWaitAllContextChannelCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cr1 := &callResult{response: make(chan *Result, 1)}
+	cr2 := &callResult{response: make(chan *Result, 1)}
+
+	result, err := WaitAllContext(ctx, struct{ CallResult }{cr1}, struct{ CallResult }{cr2})
+
+	assert.Same(t, context.Canceled, err)
+	assert.Equal(t, []*Result{nil, nil}, result)
+	assert.True(t, cr1.closed)
+	assert.True(t, cr2.closed)
+}
+
+func TestWaitAnyBase(t *testing.T) {
+	cr1 := &callResult{response: make(chan *Result, 1)}
+	cr2 := readyCallResult(&Result{Result: 2})
+
+	idx, result := WaitAny(cr1, cr2)
+
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, &Result{Result: 2}, result)
+	assert.False(t, cr1.closed)
+	assert.True(t, cr2.closed)
+}
+
+func TestWaitAnySkipsClosed(t *testing.T) {
+	cr1 := readyCallResult(&Result{Result: 1})
+	cr1.closed = true
+	cr2 := readyCallResult(&Result{Result: 2})
+
+	idx, result := WaitAny(cr1, cr2)
+
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, &Result{Result: 2}, result)
+}
+
+func TestWaitAnyChannelClosed(t *testing.T) {
+	response := make(chan *Result, 1)
+	close(response)
+	cr := &callResult{response: response}
+
+	idx, result := WaitAny(cr)
+
+	assert.Equal(t, 0, idx)
+	assert.Nil(t, result)
+	assert.True(t, cr.closed)
+}
+
+func TestWaitAnyForeign(t *testing.T) {
+	response := make(chan *Result, 1)
+	response <- &Result{Result: "result"}
+	cr := &MockCallResult{}
+	cr.On("Channel").Return((<-chan *Result)(response))
+
+	idx, result := WaitAny(cr)
+
+	assert.Equal(t, 0, idx)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	cr.AssertExpectations(t)
+}
+
+func TestWaitAnyEmpty(t *testing.T) {
+	idx, result := WaitAny(&callResult{closed: true})
+
+	assert.Equal(t, -1, idx)
+	assert.Nil(t, result)
+}
+
+func TestThenBase(t *testing.T) {
+	cr := readyCallResult(&Result{Result: 2})
+
+	result := Then(cr, func(result *Result) interface{} {
+		return result.Result.(int) * 2
+	})
+
+	assert.Equal(t, &Result{Result: 4}, result.Wait())
+}
+
+func TestThenPanic(t *testing.T) {
+	cr := readyCallResult(&Result{Result: 2})
+
+	result := Then(cr, func(result *Result) interface{} {
+		panic("this is a test")
+	})
+
+	r := result.Wait()
+	assert.Equal(t, "this is a test", r.Panic)
+	assert.Equal(t, "Then", r.PanicError.Source)
+	assert.Equal(t, &Result{Result: 2}, r.PanicError.Data)
+}
+
+func TestThenNil(t *testing.T) {
+	cr := &callResult{closed: true}
+
+	result := Then(cr, func(result *Result) interface{} {
+		t.Error("function should not be called")
+		return nil
+	})
+
+	assert.Nil(t, result.Wait())
+}
+
+func TestSharedCallResultImplementsSharedCallResult(t *testing.T) {
+	assert.Implements(t, (*SharedCallResult)(nil), &sharedCallResult{})
+}
+
+func TestShareBase(t *testing.T) {
+	cr := readyCallResult(&Result{Result: "result"})
+
+	result := Share(cr)
+
+	obj, ok := result.(*sharedCallResult)
+	require.True(t, ok)
+	<-obj.Done()
+	assert.Equal(t, &Result{Result: "result"}, obj.result)
+	assert.True(t, cr.closed)
+}
+
+func TestShareShared(t *testing.T) {
+	cr := &sharedCallResult{}
+
+	result := Share(cr)
+
+	assert.Same(t, cr, result)
+}
+
+func TestSharedCallResultReceive(t *testing.T) {
+	listener := make(chan *Result, 1)
+	obj := &sharedCallResult{
+		done:      make(chan struct{}),
+		listeners: []chan<- *Result{listener},
+	}
+
+	obj.receive(readyCallResult(&Result{Result: "result"}))
+
+	assert.Equal(t, &Result{Result: "result"}, obj.result)
+	assert.Nil(t, obj.listeners)
+	assert.Equal(t, &Result{Result: "result"}, <-listener)
+	<-obj.done
+}
+
+func TestSharedCallResultWait(t *testing.T) {
+	response := make(chan *Result, 1)
+	obj := Share(&callResult{response: response})
+	results := make(chan *Result, 3)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- obj.Wait()
+		}()
+	}
+
+	response <- &Result{Result: "result"}
+	wg.Wait()
+
+	close(results)
+	for result := range results {
+		assert.Equal(t, &Result{Result: "result"}, result)
+	}
+	assert.Equal(t, &Result{Result: "result"}, obj.Wait())
+}
+
+func TestSharedCallResultWaitContextBase(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	obj := &sharedCallResult{
+		done:   done,
+		result: &Result{Result: "result"},
+	}
+
+	result, err := obj.WaitContext(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Result: "result"}, result)
+}
+
+func TestSharedCallResultWaitContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := &sharedCallResult{
+		done: make(chan struct{}),
+	}
+
+	result, err := obj.WaitContext(ctx)
+
+	assert.Same(t, context.Canceled, err)
+	assert.Nil(t, result)
+}
+
+func TestSharedCallResultTryWaitReady(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	obj := &sharedCallResult{
+		done:   done,
+		result: &Result{Result: "result"},
+	}
+
+	result, ok := obj.TryWait()
+
+	assert.Equal(t, &Result{Result: "result"}, result)
+	assert.True(t, ok)
+}
+
+func TestSharedCallResultTryWaitNotReady(t *testing.T) {
+	obj := &sharedCallResult{
+		done: make(chan struct{}),
+	}
+
+	result, ok := obj.TryWait()
+
+	assert.Nil(t, result)
+	assert.True(t, ok)
+}
+
+func TestSharedCallResultChannelReady(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	obj := &sharedCallResult{
+		done:   done,
+		result: &Result{Result: "result"},
+	}
+
+	channel := obj.Channel()
+
+	assert.Equal(t, &Result{Result: "result"}, <-channel)
+	assert.Nil(t, obj.listeners)
+}
+
+func TestSharedCallResultChannelNotReady(t *testing.T) {
+	obj := &sharedCallResult{
+		done: make(chan struct{}),
+	}
+
+	channel := obj.Channel()
+
+	assert.Len(t, obj.listeners, 1)
+	assert.Len(t, channel, 0)
+}
+
+func TestSharedCallResultDone(t *testing.T) {
+	done := make(chan struct{})
+	obj := &sharedCallResult{
+		done: done,
+	}
+
+	result := obj.Done()
+
+	assert.Equal(t, (<-chan struct{})(done), result)
+}
+
+func TestWaitAnyShared(t *testing.T) {
+	cr1 := Share(readyCallResult(&Result{Result: 1}))
+	<-cr1.Done()
+
+	idx, result := WaitAny(cr1)
+
+	assert.Equal(t, 0, idx)
+	assert.Equal(t, &Result{Result: 1}, result)
+	assert.Equal(t, &Result{Result: 1}, cr1.Wait())
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"sync"
+)
+
+// closedChan is a channel that is always closed.
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// idleTracker tracks the number of data items a worker has accepted
+// but not yet finished with, so that callers may wait for the worker
+// to become idle.
+type idleTracker struct {
+	sync.Mutex
+	count int           // Number of items outstanding
+	idle  chan struct{} // Closed once count drops to 0
+}
+
+// newIdleTracker constructs an idleTracker for an idle worker.
+func newIdleTracker() *idleTracker {
+	return &idleTracker{
+		idle: closedChan,
+	}
+}
+
+// add records that an item has been accepted.  It is safe to call on
+// a nil idleTracker.
+func (t *idleTracker) add() {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.count == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.count++
+}
+
+// done records that an item has been finished with, including any
+// items it caused to be submitted.  It is safe to call on a nil
+// idleTracker.
+func (t *idleTracker) done() {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.count--
+	if t.count == 0 {
+		close(t.idle)
+	}
+}
+
+// channel returns a channel that is closed once the worker is idle.
+func (t *idleTracker) channel() <-chan struct{} {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.idle
+}
+
+// wait waits for the worker to become idle, or for the context to be
+// done, in which case the context's error is returned.  If the worker
+// is already idle, it returns nil even if the context is done.
+func (t *idleTracker) wait(ctx context.Context) error {
+	idle := t.channel()
+
+	// Prefer reporting idleness
+	select {
+	case <-idle:
+		return nil
+	default:
+	}
+
+	select {
+	case <-idle:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
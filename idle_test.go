@@ -0,0 +1,132 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// isClosed reports whether a channel is closed, without blocking.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// flushRunner is a Runner that waits for the gate, if set, in Run,
+// and records the errors from calling WaitIdle and whether the worker
+// was idle in Integrate.
+type flushRunner struct {
+	gate  chan struct{}
+	errs  []error
+	idles []bool
+}
+
+func (r *flushRunner) Run(data interface{}) interface{} {
+	if r.gate != nil {
+		<-r.gate
+	}
+
+	return data
+}
+
+func (r *flushRunner) Integrate(worker Worker, result *Result) {
+	iw := worker.(IdleWorker)
+	r.errs = append(r.errs, iw.WaitIdle(context.Background()))
+	r.idles = append(r.idles, isClosed(iw.Idle()))
+}
+
+func (r *flushRunner) Result() interface{} {
+	return len(r.errs)
+}
+
+func TestNewIdleTracker(t *testing.T) {
+	result := newIdleTracker()
+
+	assert.Equal(t, 0, result.count)
+	assert.True(t, isClosed(result.idle))
+}
+
+func TestIdleTrackerAdd(t *testing.T) {
+	obj := newIdleTracker()
+
+	obj.add()
+	obj.add()
+
+	assert.Equal(t, 2, obj.count)
+	assert.False(t, isClosed(obj.channel()))
+}
+
+func TestIdleTrackerDone(t *testing.T) {
+	obj := newIdleTracker()
+	obj.add()
+	obj.add()
+	idle := obj.channel()
+
+	obj.done()
+	assert.False(t, isClosed(idle))
+	obj.done()
+
+	assert.Equal(t, 0, obj.count)
+	assert.True(t, isClosed(idle))
+	assert.True(t, isClosed(obj.channel()))
+}
+
+func TestIdleTrackerNil(t *testing.T) {
+	var obj *idleTracker
+
+	assert.NotPanics(t, func() {
+		obj.add()
+		obj.done()
+	})
+}
+
+func TestIdleTrackerWaitIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := newIdleTracker()
+
+	err := obj.wait(ctx)
+
+	assert.NoError(t, err)
+}
+
+func TestIdleTrackerWaitBusy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	obj := newIdleTracker()
+	obj.add()
+
+	err := obj.wait(ctx)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestIdleTrackerWaitDone(t *testing.T) {
+	obj := newIdleTracker()
+	obj.add()
+	go obj.done()
+
+	err := obj.wait(context.Background())
+
+	assert.NoError(t, err)
+}
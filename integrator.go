@@ -0,0 +1,85 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+// integration describes a result waiting to be integrated.
+type integration struct {
+	item   *workItem // The item that was worked
+	result *Result   // The result of working the item
+}
+
+// integrator implements the WithIntegrator option.  Results are
+// handed to a single integrator goroutine through a bounded buffer,
+// so that the goroutines running Runner.Run never wait for each other
+// to call Runner.Integrate.
+type integrator struct {
+	results chan integration // Results waiting to be integrated
+	done    chan struct{}    // Closed when the integrator exits
+}
+
+// newIntegrator constructs an integrator from the options, or returns
+// nil if the option was not used.
+func newIntegrator(opts *options) *integrator {
+	if !opts.integrator {
+		return nil
+	}
+
+	buffer := opts.integratorBuffer
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	return &integrator{
+		results: make(chan integration, buffer),
+	}
+}
+
+// run is the integrator goroutine.  It marks itself with the marker,
+// then passes each result to the function.
+func (i *integrator) run(active *goroutineMarker, fn func(item *workItem, result *Result)) {
+	defer close(i.done)
+
+	active.run(func() {
+		for r := range i.results {
+			fn(r.item, r.result)
+		}
+	})
+}
+
+// start starts the integrator goroutine, which calls the function for
+// each result submitted.
+func (i *integrator) start(active *goroutineMarker, fn func(item *workItem, result *Result)) {
+	i.done = make(chan struct{})
+
+	go i.run(active, fn)
+}
+
+// submit hands a result to the integrator goroutine.  It blocks if
+// the buffer is full.
+func (i *integrator) submit(item *workItem, result *Result) {
+	i.results <- integration{
+		item:   item,
+		result: result,
+	}
+}
+
+// finish stops the integrator goroutine, if it was started.  It must
+// only be called once all results have been integrated.
+func (i *integrator) finish() {
+	close(i.results)
+	if i.done != nil {
+		<-i.done
+	}
+}
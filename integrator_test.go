@@ -0,0 +1,161 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIntegratorDisabled(t *testing.T) {
+	result := newIntegrator(&options{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewIntegratorBase(t *testing.T) {
+	result := newIntegrator(&options{integrator: true, integratorBuffer: 5})
+
+	require.NotNil(t, result)
+	assert.Equal(t, 5, cap(result.results))
+	assert.Nil(t, result.done)
+}
+
+func TestNewIntegratorNegative(t *testing.T) {
+	result := newIntegrator(&options{integrator: true, integratorBuffer: -1})
+
+	require.NotNil(t, result)
+	assert.Equal(t, 0, cap(result.results))
+}
+
+func TestIntegratorBase(t *testing.T) {
+	active := newGoroutineMarker()
+	obj := &integrator{results: make(chan integration, 2)}
+	item1 := &workItem{data: 1}
+	item2 := &workItem{data: 2}
+	result1 := &Result{Result: 1}
+	result2 := &Result{Result: 2}
+	calls := []integration{}
+	marked := []bool{}
+
+	obj.start(active, func(item *workItem, result *Result) {
+		calls = append(calls, integration{item: item, result: result})
+		marked = append(marked, active.contains())
+	})
+	obj.submit(item1, result1)
+	obj.submit(item2, result2)
+	obj.finish()
+
+	assert.Equal(t, []integration{
+		{item: item1, result: result1},
+		{item: item2, result: result2},
+	}, calls)
+	assert.Equal(t, []bool{true, true}, marked)
+	assert.False(t, active.contains())
+}
+
+func TestIntegratorFinishNotStarted(t *testing.T) {
+	obj := &integrator{results: make(chan integration)}
+
+	obj.finish()
+
+	_, ok := <-obj.results
+	assert.False(t, ok)
+}
+
+// integratorRunner is a Runner that records the goroutines calling
+// Integrate and the errors from calling Worker.Wait there, and counts
+// the calls to Run.  If the gate is set, Integrate waits for it to be
+// closed.
+type integratorRunner struct {
+	runs int32
+	ids  map[uint64]bool
+	errs []error
+	gate chan struct{}
+}
+
+func (r *integratorRunner) Run(data interface{}) interface{} {
+	atomic.AddInt32(&r.runs, 1)
+	return data
+}
+
+func (r *integratorRunner) Integrate(worker Worker, result *Result) {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.ids[goid()] = true
+	_, err := worker.Wait()
+	r.errs = append(r.errs, err)
+}
+
+func (r *integratorRunner) Result() interface{} {
+	return len(r.errs)
+}
+
+func TestGoWorkerIntegrator(t *testing.T) {
+	runner := &integratorRunner{ids: map[uint64]bool{}}
+	obj := NewGoWorker(runner, 4, WithIntegrator(2))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, result)
+	assert.Len(t, runner.ids, 1)
+	for _, err := range runner.errs {
+		assert.Same(t, ErrWouldDeadlock, err)
+	}
+}
+
+func TestGoWorkerIntegratorBackpressure(t *testing.T) {
+	runner := &integratorRunner{
+		ids:  map[uint64]bool{},
+		gate: make(chan struct{}),
+	}
+	obj := NewGoWorker(runner, 1, WithIntegrator(0))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runner.runs) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runner.runs))
+	close(runner.gate)
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	assert.Equal(t, int32(5), runner.runs)
+}
+
+func TestGoWorkerIntegratorWaitNew(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	obj := NewGoWorker(runner, 1, WithIntegrator(1))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	runner.AssertExpectations(t)
+}
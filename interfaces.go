@@ -57,6 +57,8 @@
 // Serializer.Wait.
 package parallelizer
 
+import "context"
+
 // Runner is an interface describing the work to be done.  A Worker is
 // typically instantiated by passing it a Runner, which it will then
 // use to process the submitted data.
@@ -72,7 +74,8 @@ type Runner interface {
 	// It is not safe for Run to make any calls to Worker.Call;
 	// this may potentially lead to a deadlock scenario.  Instead,
 	// return those items and handle the calls to Worker.Call from
-	// the Integrate method.
+	// the Integrate method, or implement SpawningRunner to submit
+	// them directly.
 	Run(data interface{}) interface{}
 
 	// Integrate is used to combine all the data returned by Run
@@ -99,8 +102,10 @@ type Runner interface {
 	// intended to work in conjunction with Integrate to enable
 	// the final result of the work to be reported to the caller
 	// of Worker.Wait.  It runs in the same goroutine as
-	// Worker.Wait, and need not worry about any other goroutine
-	// calling any other method from the Runner.
+	// Worker.Wait, or in a separate goroutine if the worker was
+	// shut down with ClosableWorker.Close, and need not worry
+	// about any other goroutine calling any other method from the
+	// Runner.
 	Result() interface{}
 }
 
@@ -128,6 +133,240 @@ type Worker interface {
 	Wait() (interface{}, error)
 }
 
+// AsyncWorker is a variant of Worker that allows the outcome of
+// individual data items to be waited upon.  All of the Worker
+// implementations provided by this package, including those passed
+// to Runner.Integrate, also implement AsyncWorker.
+type AsyncWorker interface {
+	Worker
+
+	// CallAsync is a variant of Call that also returns a
+	// CallResult object, which may be used to wait for the result
+	// of calling the Runner.Run method with the data.  The result
+	// is still passed to Runner.Integrate as usual; the
+	// CallResult will not receive the result until
+	// Runner.Integrate has returned.  Note that giving up on
+	// waiting via ContextCallResult.WaitContext does not prevent
+	// the data from being processed.
+	CallAsync(data interface{}) (CallResult, error)
+}
+
+// StatsWorker is a variant of Worker that reports statistics about
+// its operation.  The workers returned by NewGoWorker and
+// NewSynchronousWorker implement StatsWorker.
+type StatsWorker interface {
+	Worker
+
+	// Stats returns a snapshot of the statistics for the worker.
+	Stats() Stats
+}
+
+// IdleWorker is a variant of Worker that allows waiting for all the
+// data submitted so far to be processed without shutting down the
+// worker, so that a long-lived worker may process work in batches.
+// The worker is idle when every data item accepted by Call, including
+// those submitted from Runner.Integrate or spawned, has been passed
+// to Runner.Integrate.  The workers returned by NewGoWorker,
+// NewSynchronousWorker, and NewStealingWorker implement IdleWorker.
+type IdleWorker interface {
+	Worker
+
+	// Flush blocks until the worker is idle.  Unlike Wait, it
+	// leaves the worker open for further calls to Call.  It is
+	// equivalent to WaitIdle with a background context.
+	Flush() error
+
+	// WaitIdle is a variant of Flush that gives up waiting when
+	// the context is canceled or its deadline expires, returning
+	// the context's error.  If WaitIdle is called from
+	// Runner.Run or Runner.Integrate, it returns
+	// ErrWouldDeadlock.
+	WaitIdle(ctx context.Context) error
+
+	// Idle returns a channel that is closed once the worker is
+	// idle.  If the worker is idle when Idle is called, the
+	// channel is already closed.  Calls made after the channel
+	// is closed do not affect it; call Idle again to wait for
+	// them.
+	Idle() <-chan struct{}
+}
+
+// ClosableWorker is a variant of Worker that may be shut down without
+// blocking, so that its completion may be waited upon alongside other
+// events, such as in a select loop.  The workers returned by
+// NewGoWorker, NewSynchronousWorker, and NewStealingWorker implement
+// ClosableWorker.
+type ClosableWorker interface {
+	Worker
+
+	// Close shuts down the worker like Wait, but without waiting
+	// for it to finish.  No further calls to Call may be made,
+	// save from Runner.Integrate; the data already submitted
+	// continues to be processed, and Runner.Result is called once
+	// it has been.  Close may be called any number of times, and
+	// from any goroutine; Wait may still be called to obtain the
+	// final result.
+	Close()
+
+	// Done returns a channel that is closed once Runner.Result
+	// has been called and its result saved, after either Close
+	// or Wait.
+	Done() <-chan struct{}
+
+	// OnComplete registers a function to be called with the final
+	// result and error, as would be returned by Wait, once
+	// Runner.Result has been called.  If the WithRepanic option
+	// was used and any call to Runner.Run panicked, the error is
+	// the *PanicError describing the first such panic.  The
+	// functions are called in the order they were registered,
+	// from the goroutine that called Runner.Result, and should
+	// not block; if the result has already been computed, the
+	// function is called immediately.
+	OnComplete(fn func(result interface{}, err error))
+}
+
+// SteppingWorker is a variant of AsyncWorker whose progress is driven
+// explicitly by its caller, one step at a time.  The worker returned
+// by NewScheduledWorker implements SteppingWorker.
+type SteppingWorker interface {
+	AsyncWorker
+
+	// Step performs a single scheduling step: either calling
+	// Runner.Run for one submitted data item, or calling
+	// Runner.Integrate for one result.  It returns false if there
+	// was nothing to do.
+	Step() bool
+}
+
+// LocalRunner is an interface that may optionally be implemented by a
+// Runner that needs expensive worker-local resources, such as a
+// database connection or a scratch buffer.  Workers call Setup to
+// create the worker-local state, pass it to each call to RunLocal in
+// place of Runner.Run, and call Teardown once it is no longer needed.
+// The workers returned by NewParallelWorker and NewStealingWorker,
+// and that returned by NewGoWorker with the WithPool option, set up
+// one worker-local state for each of their goroutines, tearing it
+// down when the goroutine exits; the worker returned by
+// NewSynchronousWorker sets up only one.  The worker returned by
+// NewGoWorker without the WithPool option keeps a set of worker-local
+// states, with one in use by each goroutine running RunLocal, and
+// tears them down in Worker.Wait.  If RunLocal panics, its
+// worker-local state is torn down, and a new one is set up for the
+// next call.
+type LocalRunner interface {
+	Runner
+
+	// Setup creates a worker-local state.  It is called from the
+	// goroutine that will pass it to RunLocal.  If Setup panics,
+	// the panic is reported as the result of the data item it was
+	// called for.
+	Setup() interface{}
+
+	// RunLocal is called in place of Runner.Run to process the
+	// data.  It is passed a worker-local state, returned by
+	// Setup, that no other goroutine is using.
+	RunLocal(local interface{}, data interface{}) interface{}
+
+	// Teardown releases the resources held by a worker-local
+	// state.  It is not called from any particular goroutine.
+	Teardown(local interface{})
+}
+
+// SpawningRunner is an interface that may optionally be implemented by
+// a Runner that discovers further data items while processing data,
+// such as when walking a directory tree or exploring a graph.  Instead
+// of returning those items for Runner.Integrate to submit with
+// Worker.Call, RunWithSpawner may submit them directly, without risk
+// of deadlock, by calling the spawn function it is passed.  Spawned
+// data items are processed exactly as if they had been submitted with
+// Worker.Call, even if Worker.Wait has been called, and their results
+// are passed to Runner.Integrate as usual; Worker.Wait waits for all
+// of them.  Spawning is supported by the workers returned by
+// NewGoWorker, NewSynchronousWorker, NewParallelWorker, and
+// NewStealingWorker; the worker returned by NewParallelWorker submits
+// the spawned data items once RunWithSpawner returns.  A Runner may
+// not also implement StreamingRunner; those constructors panic with
+// ErrSpawningStreaming if it does.  If the Runner also implements
+// LocalRunner, RunWithSpawner is called instead of
+// LocalRunner.RunLocal.  Note that data items whose results are found
+// in a Cache are not run, and so spawn nothing.
+type SpawningRunner interface {
+	Runner
+
+	// RunWithSpawner is called in place of Runner.Run to process
+	// the data.  It may call the spawn function any number of
+	// times before it returns, but not after, to submit further
+	// data items.
+	RunWithSpawner(data interface{}, spawn func(data interface{})) interface{}
+}
+
+// StreamingRunner is an interface that may optionally be implemented
+// by a Runner whose data items produce many results over a long time,
+// such as when paging through an API, so that the results need not be
+// buffered until the data item is complete.  Instead of Runner.Run,
+// the worker calls RunStreaming, passing it an emit function; each
+// value passed to the emit function is passed to Runner.Integrate as
+// it arrives, in a Result with the Partial field set, serialized with
+// other calls to Runner.Integrate as usual.  The value returned by
+// RunStreaming is passed to Runner.Integrate last, exactly as if it
+// had been returned by Runner.Run.  Streaming is supported by the
+// workers returned by NewGoWorker, NewSynchronousWorker,
+// NewParallelWorker, and NewStealingWorker.  A Runner may not also
+// implement SpawningRunner; those constructors panic with
+// ErrSpawningStreaming if it does.  If the Runner also implements
+// LocalRunner, RunStreaming is called instead of
+// LocalRunner.RunLocal.  Note that partial results are not cached,
+// and that a data item interrupted before it completes, such as by
+// resuming from a checkpoint, is run again from the start, so its
+// partial results may be integrated twice.
+type StreamingRunner interface {
+	Runner
+
+	// RunStreaming is called in place of Runner.Run to process
+	// the data.  It may call the emit function any number of
+	// times before it returns, but not after, to pass partial
+	// results to Runner.Integrate.  Note that the emit function
+	// may block until the partial result has been integrated.
+	RunStreaming(data interface{}, emit func(result interface{})) interface{}
+}
+
+// ShardedRunner is an interface that may optionally be implemented by
+// a Runner whose integration is an associative reduction, such as a
+// sum or a map merge, to allow results to be integrated in parallel.
+// Instead of calling Runner.Integrate, which is serialized, the
+// worker integrates each result into one of several shards using
+// IntegrateShard; each shard is used by only one goroutine at a time,
+// so no locking is needed.  Before calling Runner.Result, Worker.Wait
+// calls Merge with all the shards, so that they may be combined.
+// Sharded integration is used by the workers returned by NewGoWorker
+// and NewSynchronousWorker.  If the WithCheckpoint option is used,
+// Merge is also called before each checkpoint is saved, so that the
+// state returned by Snapshotter.Snapshot includes the shards; the
+// merged shards are then discarded, and new ones are created as
+// needed.
+type ShardedRunner interface {
+	Runner
+
+	// NewShard returns a new, empty shard.  It is called whenever
+	// a result must be integrated and no idle shard is available.
+	NewShard() interface{}
+
+	// IntegrateShard combines a result into a shard.  It is
+	// passed a Worker object, which it may use to make additional
+	// calls to Worker.Call, exactly as Runner.Integrate may.  Calls
+	// to IntegrateShard may run in parallel, but never with the
+	// same shard.
+	IntegrateShard(worker Worker, shard interface{}, result *Result)
+
+	// Merge combines the shards into the integrated state.  It is
+	// called by Worker.Wait once all data has been processed,
+	// before Runner.Result is called.  If the WithCheckpoint
+	// option is used, it is also called before each checkpoint
+	// with the shards created since the previous call, so it must
+	// add them to the integrated state rather than replace it.
+	Merge(shards []interface{})
+}
+
 // Doer is an interface describing an operation to be done in a
 // synchronized fashion, such as building a data structure.
 type Doer interface {
@@ -143,9 +382,9 @@ type Doer interface {
 }
 
 // CallResult is an interface describing a "future" returned by
-// Serializer.CallAsync.  It allows the call to be made without
-// blocking the goroutine calling Serializer.CallAsync, but the result
-// of the call may still be waited upon at a later date.
+// Serializer.CallAsync or AsyncWorker.CallAsync.  It allows the call
+// to be made without blocking the goroutine calling CallAsync, but
+// the result of the call may still be waited upon at a later date.
 type CallResult interface {
 	// Wait is used to retrieve the result of the call.  The
 	// result is not cached in the CallResult object, so
@@ -168,6 +407,40 @@ type CallResult interface {
 	Channel() <-chan *Result
 }
 
+// ContextCallResult is a variant of CallResult that allows waiting to
+// be given up when a context is done.  The CallResult objects
+// returned by Serializer.CallAsync and AsyncWorker.CallAsync, and by
+// Then, implement ContextCallResult, as do all SharedCallResult
+// objects.
+type ContextCallResult interface {
+	CallResult
+
+	// WaitContext is a variant of Wait that gives up waiting when
+	// the context is canceled or its deadline expires, returning
+	// the context's error.  For a CallResult returned by
+	// Serializer.CallAsync, if the call has not yet been passed
+	// to Doer.Do when the caller gives up, it will be skipped
+	// entirely; otherwise, giving up does not prevent the data
+	// from being processed.  As with Wait, the result is not
+	// cached, and subsequent calls will return nil.
+	WaitContext(ctx context.Context) (*Result, error)
+}
+
+// SharedCallResult is a variant of CallResult that may be shared by
+// any number of goroutines.  Unlike CallResult, the result is cached
+// once it has been received, so every call to Wait returns the same
+// result, and all methods are safe to call concurrently.  A
+// SharedCallResult may be constructed from any CallResult by passing
+// it to Share.
+type SharedCallResult interface {
+	ContextCallResult
+
+	// Done returns a channel that is closed once the result of
+	// the call is available.  It may be used to select on the
+	// completion of the call from any number of goroutines.
+	Done() <-chan struct{}
+}
+
 // Serializer is an interface for doing the opposite of parallelizing
 // an operation.  The use case for Serializer is when something must
 // be done in a single goroutine, for synchronization, but the calls
@@ -198,3 +471,49 @@ type Serializer interface {
 	// calls to Wait.
 	Wait() interface{}
 }
+
+// ContextSerializer is a variant of Serializer that allows waiting
+// for a call to be given up when a context is done.  The Serializer
+// returned by NewSerializer implements ContextSerializer.
+type ContextSerializer interface {
+	Serializer
+
+	// CallContext is a variant of Call that gives up waiting for
+	// the Doer.Do method to complete when the context is canceled
+	// or its deadline expires, returning the context's error.  If
+	// the call has not yet been passed to Doer.Do when the caller
+	// gives up, it will be skipped entirely.
+	CallContext(ctx context.Context, data interface{}) (*Result, error)
+}
+
+// ClosableSerializer is a variant of Serializer that may be shut down
+// without blocking, so that its completion may be waited upon
+// alongside other events, such as in a select loop.  The Serializer
+// returned by NewSerializer implements ClosableSerializer.
+type ClosableSerializer interface {
+	Serializer
+
+	// Close signals the manager goroutine to exit like Wait, but
+	// without waiting for it to do so.  No further Call* methods
+	// may be called; the requests already made are still passed
+	// to Doer.Do, and Doer.Finish is called once they have been.
+	// Close may be called any number of times, and from any
+	// goroutine; Wait may still be called to obtain the result.
+	Close()
+
+	// Done returns a channel that is closed once Doer.Finish has
+	// been called and its result saved, after either Close or
+	// Wait.
+	Done() <-chan struct{}
+
+	// OnComplete registers a function to be called with the
+	// result of Doer.Finish once it has been called.  The error
+	// is nil, unless the WithRepanic option was used and any
+	// call to Doer.Do panicked, in which case it is the
+	// *PanicError describing the first such panic.  The functions
+	// are called in the order they were registered, from the
+	// goroutine that called Doer.Finish, and should not block; if
+	// the result has already been computed, the function is
+	// called immediately.
+	OnComplete(fn func(result interface{}, err error))
+}
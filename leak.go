@@ -0,0 +1,219 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of lifecycle violations retained for CheckLeaks; the
+// oldest are discarded beyond it.
+const (
+	maxLeakViolations = 1000
+)
+
+// leakEnabled is non-zero if leak tracking is enabled.  It must be
+// accessed atomically.
+var leakEnabled int32
+
+// leakRecord describes a Worker or Serializer tracked for leaks.
+type leakRecord struct {
+	kind   string // The constructor that created the object
+	stack  []byte // The stack at creation
+	seq    uint64 // Sequence number, for scoping CheckLeaks
+	waited int32  // Non-zero once Wait is called; accessed atomically
+}
+
+// leakViolation describes a recorded lifecycle violation.
+type leakViolation struct {
+	seq uint64 // Sequence number, for scoping CheckLeaks
+	msg string // Description of the violation
+}
+
+// leakRegistry contains the objects being tracked for leaks and the
+// recorded lifecycle violations.  Each object and violation is given
+// a sequence number, so that CheckLeaks may report only those that
+// came after it was called.  Objects are removed once they are waited
+// on or garbage collected, and only the most recent violations are
+// retained.
+var leakRegistry = struct {
+	sync.Mutex
+	seq        uint64                                   // Last sequence number issued
+	records    map[*leakRecord]bool                     // Live objects not yet waited on
+	violations []leakViolation                          // Recorded violations
+	logf       func(format string, args ...interface{}) // Where to send warnings
+}{
+	records: map[*leakRecord]bool{},
+	logf:    log.Printf,
+}
+
+// SetLeakTracking enables or disables leak tracking.  While leak
+// tracking is enabled, every Worker and Serializer constructed by this
+// package records the stack at which it was created, so that those
+// that are never waited on may be reported by CheckLeaks, or by a
+// warning logged when they are garbage collected; calls made after
+// Wait are also recorded and logged.  Leak tracking has a cost, and
+// is intended for debugging and tests.  It may also be enabled by
+// building with the "parallelizer_leaks" build tag.  Objects
+// constructed while leak tracking is disabled are never tracked.
+func SetLeakTracking(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&leakEnabled, value)
+}
+
+// LeakTrackingEnabled returns true if leak tracking is enabled.
+func LeakTrackingEnabled() bool {
+	return atomic.LoadInt32(&leakEnabled) != 0
+}
+
+// trackLeaks begins tracking an object for leaks, if leak tracking is
+// enabled.  It returns the leakRecord, or nil if tracking is disabled.
+func trackLeaks(kind string, obj interface{}) *leakRecord {
+	if !LeakTrackingEnabled() {
+		return nil
+	}
+
+	rec := &leakRecord{
+		kind:  kind,
+		stack: debug.Stack(),
+	}
+
+	leakRegistry.Lock()
+	leakRegistry.seq++
+	rec.seq = leakRegistry.seq
+	leakRegistry.records[rec] = true
+	leakRegistry.Unlock()
+
+	runtime.SetFinalizer(obj, func(interface{}) { rec.finalize() })
+
+	return rec
+}
+
+// markWaited records that Wait has been called on the object.
+func (r *leakRecord) markWaited() {
+	if r == nil || !atomic.CompareAndSwapInt32(&r.waited, 0, 1) {
+		return
+	}
+
+	leakRegistry.Lock()
+	delete(leakRegistry.records, r)
+	leakRegistry.Unlock()
+}
+
+// markClosedCall records a call made after Wait has been called on
+// the object.
+func (r *leakRecord) markClosedCall() {
+	if r == nil {
+		return
+	}
+
+	leakRegistry.Lock()
+	defer leakRegistry.Unlock()
+
+	msg := "parallelizer: call after Wait on object from " + r.kind + " created at:\n" + string(r.stack) + "called at:\n" + string(debug.Stack())
+	leakRegistry.seq++
+	leakRegistry.violations = append(leakRegistry.violations, leakViolation{
+		seq: leakRegistry.seq,
+		msg: msg,
+	})
+	if n := len(leakRegistry.violations) - maxLeakViolations; n > 0 {
+		copy(leakRegistry.violations, leakRegistry.violations[n:])
+		leakRegistry.violations = leakRegistry.violations[:maxLeakViolations]
+	}
+	leakRegistry.logf("%s", msg)
+}
+
+// finalize is called when the object is garbage collected.  It logs a
+// warning if Wait was never called.
+func (r *leakRecord) finalize() {
+	leakRegistry.Lock()
+	defer leakRegistry.Unlock()
+
+	if !leakRegistry.records[r] {
+		return
+	}
+	delete(leakRegistry.records, r)
+	leakRegistry.logf("parallelizer: object from %s garbage collected without Wait being called; created at:\n%s", r.kind, r.stack)
+}
+
+// LeakTestingT is the subset of the testing.T interface used by
+// CheckLeaks.
+type LeakTestingT interface {
+	// Errorf reports a formatted error and marks the test as
+	// failed.
+	Errorf(format string, args ...interface{})
+
+	// Cleanup registers a function to be called when the test
+	// completes.
+	Cleanup(fn func())
+}
+
+// CheckLeaks arranges for each tracked Worker or Serializer that is
+// constructed during the test and has not been waited on by the time
+// the test completes to be reported to t, along with the stack at
+// which it was created, and likewise each call made after Wait during
+// the test.  CheckLeaks is intended to be called at the start of a
+// test; objects constructed before it is called are not reported.
+// Note that objects constructed and calls made by tests running in
+// parallel with the test are also reported.  CheckLeaks does nothing
+// unless leak tracking has been enabled with SetLeakTracking or the
+// "parallelizer_leaks" build tag.
+func CheckLeaks(t LeakTestingT) {
+	leakRegistry.Lock()
+	start := leakRegistry.seq
+	leakRegistry.Unlock()
+
+	t.Cleanup(func() {
+		reportLeaks(t, start)
+	})
+}
+
+// reportLeaks is a helper for CheckLeaks that reports the objects not
+// waited on and the violations recorded after the specified sequence
+// number, in the order they were recorded.
+func reportLeaks(t LeakTestingT, start uint64) {
+	leakRegistry.Lock()
+	records := []*leakRecord{}
+	for rec := range leakRegistry.records {
+		if rec.seq > start {
+			records = append(records, rec)
+		}
+	}
+	violations := []leakViolation{}
+	for _, v := range leakRegistry.violations {
+		if v.seq > start {
+			violations = append(violations, v)
+		}
+	}
+	leakRegistry.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+	for _, rec := range records {
+		t.Errorf("parallelizer: object from %s never waited on; created at:\n%s", rec.kind, rec.stack)
+	}
+	for _, v := range violations {
+		t.Errorf("%s", v.msg)
+	}
+}
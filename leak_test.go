@@ -0,0 +1,261 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableLeakTracking enables leak tracking for the duration of a test,
+// capturing the logged warnings.  It returns a function that returns
+// the warnings logged so far.
+func enableLeakTracking(t *testing.T) func() []string {
+	SetLeakTracking(true)
+
+	warnings := []string{}
+	leakRegistry.Lock()
+	leakRegistry.logf = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	leakRegistry.Unlock()
+
+	t.Cleanup(func() {
+		SetLeakTracking(false)
+		leakRegistry.Lock()
+		leakRegistry.logf = func(format string, args ...interface{}) {}
+		leakRegistry.Unlock()
+	})
+
+	return func() []string {
+		leakRegistry.Lock()
+		defer leakRegistry.Unlock()
+
+		return append([]string{}, warnings...)
+	}
+}
+
+func TestSetLeakTracking(t *testing.T) {
+	defer SetLeakTracking(false)
+
+	SetLeakTracking(true)
+	assert.True(t, LeakTrackingEnabled())
+	SetLeakTracking(false)
+	assert.False(t, LeakTrackingEnabled())
+}
+
+func TestTrackLeaksDisabled(t *testing.T) {
+	result := trackLeaks("kind", new(int))
+
+	assert.Nil(t, result)
+}
+
+func TestTrackLeaksEnabled(t *testing.T) {
+	enableLeakTracking(t)
+	other := trackLeaks("other", new(int))
+	other.markWaited()
+
+	result := trackLeaks("kind", new(int))
+
+	require.NotNil(t, result)
+	assert.Equal(t, "kind", result.kind)
+	assert.Contains(t, string(result.stack), "TestTrackLeaksEnabled")
+	assert.Equal(t, other.seq+1, result.seq)
+	assert.True(t, leakRegistry.records[result])
+	result.markWaited()
+}
+
+func TestLeakRecordNil(t *testing.T) {
+	var obj *leakRecord
+
+	obj.markWaited()
+	obj.markClosedCall()
+}
+
+func TestLeakRecordMarkWaited(t *testing.T) {
+	enableLeakTracking(t)
+	obj := trackLeaks("kind", new(int))
+
+	obj.markWaited()
+
+	assert.Equal(t, int32(1), obj.waited)
+	assert.False(t, leakRegistry.records[obj])
+}
+
+func TestLeakRecordMarkClosedCallCapped(t *testing.T) {
+	enableLeakTracking(t)
+	obj := trackLeaks("kind", new(int))
+	defer obj.markWaited()
+	leakRegistry.Lock()
+	saved := leakRegistry.violations
+	leakRegistry.violations = nil
+	leakRegistry.Unlock()
+	defer func() {
+		leakRegistry.Lock()
+		leakRegistry.violations = saved
+		leakRegistry.Unlock()
+	}()
+
+	for i := 0; i < maxLeakViolations+5; i++ {
+		obj.markClosedCall()
+	}
+
+	leakRegistry.Lock()
+	defer leakRegistry.Unlock()
+	assert.Len(t, leakRegistry.violations, maxLeakViolations)
+	assert.Equal(t, leakRegistry.seq, leakRegistry.violations[maxLeakViolations-1].seq)
+}
+
+func TestLeakRecordFinalize(t *testing.T) {
+	warnings := enableLeakTracking(t)
+	obj := trackLeaks("kind", new(int))
+
+	obj.finalize()
+
+	assert.False(t, leakRegistry.records[obj])
+	require.Len(t, warnings(), 1)
+	assert.Contains(t, warnings()[0], "object from kind garbage collected without Wait being called")
+	assert.Contains(t, warnings()[0], "TestLeakRecordFinalize")
+}
+
+func TestLeakRecordFinalizeWaited(t *testing.T) {
+	warnings := enableLeakTracking(t)
+	obj := trackLeaks("kind", new(int))
+	obj.markWaited()
+
+	obj.finalize()
+
+	assert.Len(t, warnings(), 0)
+}
+
+func TestTrackLeaksFinalizer(t *testing.T) {
+	warnings := enableLeakTracking(t)
+	NewGoWorker(&MockRunner{}, 1)
+
+	assert.Eventually(t, func() bool {
+		runtime.GC()
+		return len(warnings()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Contains(t, warnings()[0], "object from NewGoWorker garbage collected without Wait being called")
+}
+
+func TestCheckLeaksWaited(t *testing.T) {
+	enableLeakTracking(t)
+	ft := &fakeT{}
+	CheckLeaks(ft)
+	w := NewGoWorker(&MockRunner{}, 1)
+	s := NewSerializer(&MockDoer{})
+	w.(*goWorker).runner.(*MockRunner).On("Result").Return(nil)
+	s.(*serializer).doer.(*MockDoer).On("Finish").Return(nil)
+	w.Wait()
+	s.Wait()
+
+	ft.finish()
+
+	assert.Len(t, ft.errors, 0)
+}
+
+func TestCheckLeaksNotWaited(t *testing.T) {
+	enableLeakTracking(t)
+	ft := &fakeT{}
+	CheckLeaks(ft)
+	runner := &MockRunner{}
+	runner.On("Result").Return(nil)
+	doer := &MockDoer{}
+	doer.On("Finish").Return(nil)
+	objs := []interface{}{
+		NewGoWorker(runner, 1),
+		NewSynchronousWorker(runner),
+		NewParallelWorker(runner, 1),
+		NewSerializer(doer),
+	}
+
+	ft.finish()
+
+	require.Len(t, ft.errors, 4)
+	for i, kind := range []string{"NewGoWorker", "NewSynchronousWorker", "NewParallelWorker", "NewSerializer"} {
+		assert.Contains(t, ft.errors[i], "object from "+kind+" never waited on")
+		assert.Contains(t, ft.errors[i], "TestCheckLeaksNotWaited")
+	}
+	for _, obj := range objs[:3] {
+		obj.(Worker).Wait()
+	}
+	objs[3].(Serializer).Wait()
+}
+
+func TestCheckLeaksScoped(t *testing.T) {
+	enableLeakTracking(t)
+	doer := &MockDoer{}
+	doer.On("Finish").Return(nil)
+	before := NewSerializer(doer)
+	ft := &fakeT{}
+	CheckLeaks(ft)
+	during := NewSerializer(doer)
+
+	ft.finish()
+
+	require.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], "object from NewSerializer never waited on")
+	ft2 := &fakeT{}
+	CheckLeaks(ft2)
+	ft2.finish()
+	assert.Len(t, ft2.errors, 0)
+	before.Wait()
+	during.Wait()
+}
+
+func TestCheckLeaksCallAfterWait(t *testing.T) {
+	warnings := enableLeakTracking(t)
+	ft := &fakeT{}
+	CheckLeaks(ft)
+	runner := &MockRunner{}
+	runner.On("Result").Return(nil)
+	doer := &MockDoer{}
+	doer.On("Finish").Return(nil)
+	workers := []Worker{
+		NewGoWorker(runner, 1),
+		NewSynchronousWorker(runner),
+		NewParallelWorker(runner, 1),
+	}
+	s := NewSerializer(doer)
+	for _, w := range workers {
+		w.Wait()
+		assert.Same(t, ErrClosed, w.Call("data"))
+	}
+	s.Wait()
+	_, err1 := s.Call("data")
+	_, err2 := s.CallAsync("data")
+	err3 := s.CallOnly("data")
+	_, err4 := s.(ContextSerializer).CallContext(context.Background(), "data")
+
+	ft.finish()
+
+	assert.Same(t, ErrClosed, err1)
+	assert.Same(t, ErrClosed, err2)
+	assert.Same(t, ErrClosed, err3)
+	assert.Same(t, ErrClosed, err4)
+	assert.Len(t, ft.errors, 7)
+	assert.Equal(t, ft.errors, warnings())
+	for _, msg := range ft.errors {
+		assert.Contains(t, msg, "call after Wait")
+		assert.Contains(t, msg, "TestCheckLeaksCallAfterWait")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import "sync"
+
+// workerLocal holds the worker-local state for a LocalRunner.  The
+// state is set up lazily, on the first call to run.
+type workerLocal struct {
+	runner LocalRunner // The runner that owns the state
+	value  interface{} // The state returned by LocalRunner.Setup
+	ready  bool        // Set once the state has been set up
+}
+
+// newWorkerLocal constructs a workerLocal if the runner implements
+// LocalRunner, or returns nil otherwise.
+func newWorkerLocal(runner Runner) *workerLocal {
+	lr, ok := runner.(LocalRunner)
+	if !ok {
+		return nil
+	}
+
+	return &workerLocal{runner: lr}
+}
+
+// run calls LocalRunner.RunLocal with the worker-local state, setting
+// it up if needed, and captures any panics.  If a panic occurs, the
+// state is torn down, so that a new state is set up for the next
+// call.
+func (l *workerLocal) run(source string, data interface{}) *Result {
+	result := panicer(source, func(data interface{}) interface{} {
+		if !l.ready {
+			l.value = l.runner.Setup()
+			l.ready = true
+		}
+
+		return l.runner.RunLocal(l.value, data)
+	}, data)
+
+	// Replace the state after a panic
+	if result.Panic != nil {
+		l.close()
+	}
+
+	return result
+}
+
+// close tears down the worker-local state, if it has been set up.  It
+// is safe to call on a nil workerLocal.
+func (l *workerLocal) close() {
+	if l == nil || !l.ready {
+		return
+	}
+
+	l.runner.Teardown(l.value)
+	l.value = nil
+	l.ready = false
+}
+
+// localSet is a set of worker-local states for a LocalRunner, used
+// when the worker does not have long-lived goroutines.  Each state is
+// used by only one goroutine at a time.
+type localSet struct {
+	sync.Mutex
+	runner LocalRunner    // The runner that owns the states
+	free   []*workerLocal // The states not in use
+}
+
+// newLocalSet constructs a localSet if the runner implements
+// LocalRunner, or returns nil otherwise.
+func newLocalSet(runner Runner) *localSet {
+	lr, ok := runner.(LocalRunner)
+	if !ok {
+		return nil
+	}
+
+	return &localSet{runner: lr}
+}
+
+// get takes a worker-local state out of the set, creating one if none
+// is available.
+func (s *localSet) get() *workerLocal {
+	s.Lock()
+	defer s.Unlock()
+
+	// Reuse a free state
+	if n := len(s.free); n > 0 {
+		local := s.free[n-1]
+		s.free = s.free[:n-1]
+		return local
+	}
+
+	return &workerLocal{runner: s.runner}
+}
+
+// put returns a worker-local state to the set.
+func (s *localSet) put(local *workerLocal) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.free = append(s.free, local)
+}
+
+// close tears down all the worker-local states in the set.
+func (s *localSet) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, local := range s.free {
+		local.close()
+	}
+	s.free = nil
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localState is the worker-local state used by localRunner.
+type localState struct {
+	busy int32 // Non-zero while the state is in use
+	down bool  // Set once the state has been torn down
+}
+
+// localRunner is a LocalRunner that counts the calls to its hooks and
+// the results, and detects states used by more than one goroutine at
+// a time or after being torn down.  It panics if the data is "panic".
+type localRunner struct {
+	sync.Mutex
+	setups    int
+	teardowns int
+	shared    int32
+	sum       int
+}
+
+func (r *localRunner) Run(data interface{}) interface{} {
+	panic("Run called")
+}
+
+func (r *localRunner) Integrate(worker Worker, result *Result) {
+	if result.Panic == nil {
+		r.sum += result.Result.(int)
+	}
+}
+
+func (r *localRunner) Result() interface{} {
+	return r.sum
+}
+
+func (r *localRunner) Setup() interface{} {
+	r.Lock()
+	defer r.Unlock()
+
+	r.setups++
+	return &localState{}
+}
+
+func (r *localRunner) RunLocal(local interface{}, data interface{}) interface{} {
+	s := local.(*localState)
+	if s.down || !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+		atomic.AddInt32(&r.shared, 1)
+		return 0
+	}
+	defer atomic.StoreInt32(&s.busy, 0)
+
+	if data == "panic" {
+		panic("panic")
+	}
+
+	return data
+}
+
+func (r *localRunner) Teardown(local interface{}) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.teardowns++
+	local.(*localState).down = true
+}
+
+func TestNewWorkerLocalNotLocal(t *testing.T) {
+	result := newWorkerLocal(&MockRunner{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewWorkerLocalLocal(t *testing.T) {
+	runner := &localRunner{}
+
+	result := newWorkerLocal(runner)
+
+	assert.Equal(t, &workerLocal{runner: runner}, result)
+}
+
+func TestWorkerLocalRunSetup(t *testing.T) {
+	runner := &localRunner{}
+	obj := &workerLocal{runner: runner}
+
+	result1 := obj.run("test", 1)
+	result2 := obj.run("test", 2)
+
+	assert.Equal(t, &Result{Result: 1}, result1)
+	assert.Equal(t, &Result{Result: 2}, result2)
+	assert.True(t, obj.ready)
+	assert.Equal(t, &localState{}, obj.value)
+	assert.Equal(t, 1, runner.setups)
+	assert.Equal(t, 0, runner.teardowns)
+}
+
+func TestWorkerLocalRunPanic(t *testing.T) {
+	runner := &localRunner{}
+	obj := &workerLocal{runner: runner}
+	obj.run("test", 1)
+
+	result := obj.run("test", "panic")
+
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "panic", result.Panic)
+	assert.Equal(t, "test", result.PanicError.Source)
+	assert.False(t, obj.ready)
+	assert.Nil(t, obj.value)
+	assert.Equal(t, 1, runner.setups)
+	assert.Equal(t, 1, runner.teardowns)
+	assert.Equal(t, &Result{Result: 3}, obj.run("test", 3))
+	assert.Equal(t, 2, runner.setups)
+}
+
+func TestWorkerLocalCloseReady(t *testing.T) {
+	runner := &localRunner{}
+	state := &localState{}
+	obj := &workerLocal{runner: runner, value: state, ready: true}
+
+	obj.close()
+
+	assert.False(t, obj.ready)
+	assert.Nil(t, obj.value)
+	assert.True(t, state.down)
+	assert.Equal(t, 1, runner.teardowns)
+}
+
+func TestWorkerLocalCloseNotReady(t *testing.T) {
+	runner := &localRunner{}
+	obj := &workerLocal{runner: runner}
+
+	obj.close()
+
+	assert.Equal(t, 0, runner.teardowns)
+}
+
+func TestWorkerLocalCloseNil(t *testing.T) {
+	var obj *workerLocal
+
+	assert.NotPanics(t, obj.close)
+}
+
+func TestNewLocalSetNotLocal(t *testing.T) {
+	result := newLocalSet(&MockRunner{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewLocalSetLocal(t *testing.T) {
+	runner := &localRunner{}
+
+	result := newLocalSet(runner)
+
+	assert.Equal(t, &localSet{runner: runner}, result)
+}
+
+func TestLocalSetGetNew(t *testing.T) {
+	runner := &localRunner{}
+	obj := &localSet{runner: runner}
+
+	result := obj.get()
+
+	assert.Equal(t, &workerLocal{runner: runner}, result)
+	assert.Equal(t, 0, runner.setups)
+}
+
+func TestLocalSetGetFree(t *testing.T) {
+	local := &workerLocal{}
+	obj := &localSet{free: []*workerLocal{local}}
+
+	result := obj.get()
+
+	assert.Same(t, local, result)
+	assert.Len(t, obj.free, 0)
+}
+
+func TestLocalSetPut(t *testing.T) {
+	local := &workerLocal{}
+	obj := &localSet{}
+
+	obj.put(local)
+
+	assert.Equal(t, []*workerLocal{local}, obj.free)
+}
+
+func TestLocalSetClose(t *testing.T) {
+	runner := &localRunner{}
+	obj := &localSet{
+		runner: runner,
+		free: []*workerLocal{
+			{runner: runner, value: &localState{}, ready: true},
+			{runner: runner},
+		},
+	}
+
+	obj.close()
+
+	assert.Nil(t, obj.free)
+	assert.Equal(t, 1, runner.teardowns)
+}
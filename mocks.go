@@ -14,7 +14,11 @@
 
 package parallelizer
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
 
 // MockRunner is a mock for the Runner interface.  It is provided to
 // facilitate internal testing of the Runner implementations, but may
@@ -125,8 +129,9 @@ func (m *MockDoer) Finish() interface{} {
 	return args.Get(0)
 }
 
-// MockCallResult is a mock for the CallResult interface.  It is
-// provided to facilitate testing code that utilizes the serializer.
+// MockCallResult is a mock for the CallResult and ContextCallResult
+// interfaces.  It is provided to facilitate testing code that utilizes
+// the serializer.
 type MockCallResult struct {
 	mock.Mock
 }
@@ -144,6 +149,22 @@ func (m *MockCallResult) Wait() *Result {
 	return nil
 }
 
+// WaitContext is a variant of Wait that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  If the call has not yet been passed to Doer.Do
+// when the caller gives up, it will be skipped entirely.  As with
+// Wait, the result is not cached, and subsequent calls will return
+// nil.
+func (m *MockCallResult) WaitContext(ctx context.Context) (*Result, error) {
+	args := m.MethodCalled("WaitContext", ctx)
+
+	if result := args.Get(0); result != nil {
+		return result.(*Result), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
 // TryWait is a non-blocking variant of Wait.  It attempts to retrieve
 // the result, and returns the value and a boolean value that
 // indicates whether the result has already been retrieved.
@@ -173,9 +194,29 @@ func (m *MockCallResult) Channel() <-chan *Result {
 	return nil
 }
 
-// MockSerializer is a mock for the Serializer interface.  It is
-// provided to facilitate testing code that utilizes Serializer
-// implementations.
+// MockSharedCallResult is a mock for the SharedCallResult interface.
+// It is provided to facilitate testing code that utilizes shared
+// futures.
+type MockSharedCallResult struct {
+	MockCallResult
+}
+
+// Done returns a channel that is closed once the result of the call
+// is available.  It may be used to select on the completion of the
+// call from any number of goroutines.
+func (m *MockSharedCallResult) Done() <-chan struct{} {
+	args := m.MethodCalled("Done")
+
+	if doneChan := args.Get(0); doneChan != nil {
+		return doneChan.(<-chan struct{})
+	}
+
+	return nil
+}
+
+// MockSerializer is a mock for the Serializer and ContextSerializer
+// interfaces.  It is provided to facilitate testing code that utilizes
+// Serializer implementations.
 type MockSerializer struct {
 	mock.Mock
 }
@@ -194,6 +235,21 @@ func (m *MockSerializer) Call(data interface{}) (*Result, error) {
 	return nil, args.Error(1)
 }
 
+// CallContext is a variant of Call that gives up waiting for the
+// Doer.Do method to complete when the context is canceled or its
+// deadline expires, returning the context's error.  If the call has
+// not yet been passed to Doer.Do when the caller gives up, it will be
+// skipped entirely.
+func (m *MockSerializer) CallContext(ctx context.Context, data interface{}) (*Result, error) {
+	args := m.MethodCalled("CallContext", ctx, data)
+
+	if result := args.Get(0); result != nil {
+		return result.(*Result), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
 // CallAsync is used to invoke the Doer.Do method, like Call, but it
 // does not block; instead, it returns a CallResult object, which may
 // be queried later for the result of the call.
@@ -224,3 +280,87 @@ func (m *MockSerializer) Wait() interface{} {
 
 	return args.Get(0)
 }
+
+// MockCache is a mock for the Cache interface.  It is provided to
+// facilitate testing code that utilizes the WithCache option.
+type MockCache struct {
+	mock.Mock
+}
+
+// Get looks up the result stored under a key.  It returns the result
+// and a boolean value that is true if the result was found.
+func (m *MockCache) Get(key interface{}) (interface{}, bool) {
+	args := m.MethodCalled("Get", key)
+
+	return args.Get(0), args.Bool(1)
+}
+
+// Put stores a result under a key.
+func (m *MockCache) Put(key interface{}, value interface{}) {
+	m.MethodCalled("Put", key, value)
+}
+
+// MockCodec is a mock for the Codec interface.  It is provided to
+// facilitate testing code that utilizes a Codec.
+type MockCodec struct {
+	mock.Mock
+}
+
+// Encode converts a value to bytes.
+func (m *MockCodec) Encode(value interface{}) ([]byte, error) {
+	args := m.MethodCalled("Encode", value)
+
+	if data := args.Get(0); data != nil {
+		return data.([]byte), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+// Decode converts bytes produced by Encode back into a value.
+func (m *MockCodec) Decode(data []byte) (interface{}, error) {
+	args := m.MethodCalled("Decode", data)
+
+	return args.Get(0), args.Error(1)
+}
+
+// MockCheckpointStore is a mock for the CheckpointStore interface.
+// It is provided to facilitate testing code that utilizes the
+// WithCheckpoint option or ResumeGoWorker.
+type MockCheckpointStore struct {
+	mock.Mock
+}
+
+// Save saves a checkpoint, replacing any previously saved checkpoint.
+func (m *MockCheckpointStore) Save(data []byte) error {
+	args := m.MethodCalled("Save", data)
+
+	return args.Error(0)
+}
+
+// Load loads the most recently saved checkpoint.  If no checkpoint
+// has been saved, it returns ErrNoCheckpoint.
+func (m *MockCheckpointStore) Load() ([]byte, error) {
+	args := m.MethodCalled("Load")
+
+	if data := args.Get(0); data != nil {
+		return data.([]byte), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+// MockScheduler is a mock for the Scheduler interface.  It is
+// provided to facilitate testing code that utilizes
+// NewScheduledWorker.
+type MockScheduler struct {
+	mock.Mock
+}
+
+// Choose selects one of n possible actions, returning a number from 0
+// to n-1.  It is only called with n greater than 1.
+func (m *MockScheduler) Choose(n int) int {
+	args := m.MethodCalled("Choose", n)
+
+	return args.Int(0)
+}
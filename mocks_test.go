@@ -15,6 +15,7 @@
 package parallelizer
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -107,6 +108,10 @@ func TestMockCallResultImplementsCallResult(t *testing.T) {
 	assert.Implements(t, (*CallResult)(nil), &MockCallResult{})
 }
 
+func TestMockCallResultImplementsContextCallResult(t *testing.T) {
+	assert.Implements(t, (*ContextCallResult)(nil), &MockCallResult{})
+}
+
 func TestMockCallResultWaitNil(t *testing.T) {
 	obj := &MockCallResult{}
 	obj.On("Wait").Return(nil)
@@ -128,6 +133,31 @@ func TestMockCallResultWaitNonNil(t *testing.T) {
 	obj.AssertExpectations(t)
 }
 
+func TestMockCallResultWaitContextNil(t *testing.T) {
+	ctx := context.Background()
+	obj := &MockCallResult{}
+	obj.On("WaitContext", ctx).Return(nil, assert.AnError)
+
+	result, err := obj.WaitContext(ctx)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCallResultWaitContextNonNil(t *testing.T) {
+	ctx := context.Background()
+	expected := &Result{}
+	obj := &MockCallResult{}
+	obj.On("WaitContext", ctx).Return(expected, nil)
+
+	result, err := obj.WaitContext(ctx)
+
+	assert.NoError(t, err)
+	assert.Same(t, expected, result)
+	obj.AssertExpectations(t)
+}
+
 func TestMockCallResultTryWaitNil(t *testing.T) {
 	obj := &MockCallResult{}
 	obj.On("TryWait").Return(nil, true)
@@ -172,10 +202,39 @@ func TestMockCallResultChannelNonNil(t *testing.T) {
 	obj.AssertExpectations(t)
 }
 
+func TestMockSharedCallResultImplementsSharedCallResult(t *testing.T) {
+	assert.Implements(t, (*SharedCallResult)(nil), &MockSharedCallResult{})
+}
+
+func TestMockSharedCallResultDoneNil(t *testing.T) {
+	obj := &MockSharedCallResult{}
+	obj.On("Done").Return(nil)
+
+	result := obj.Done()
+
+	assert.Nil(t, result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockSharedCallResultDoneNonNil(t *testing.T) {
+	expected := make(<-chan struct{})
+	obj := &MockSharedCallResult{}
+	obj.On("Done").Return(expected)
+
+	result := obj.Done()
+
+	assert.Equal(t, expected, result)
+	obj.AssertExpectations(t)
+}
+
 func TestMockSerializerImplementsSerializer(t *testing.T) {
 	assert.Implements(t, (*Serializer)(nil), &MockSerializer{})
 }
 
+func TestMockSerializerImplementsContextSerializer(t *testing.T) {
+	assert.Implements(t, (*ContextSerializer)(nil), &MockSerializer{})
+}
+
 func TestMockSerializerCallNil(t *testing.T) {
 	obj := &MockSerializer{}
 	obj.On("Call", "data").Return(nil, assert.AnError)
@@ -199,6 +258,31 @@ func TestMockSerializerCallNonNil(t *testing.T) {
 	obj.AssertExpectations(t)
 }
 
+func TestMockSerializerCallContextNil(t *testing.T) {
+	ctx := context.Background()
+	obj := &MockSerializer{}
+	obj.On("CallContext", ctx, "data").Return(nil, assert.AnError)
+
+	result, err := obj.CallContext(ctx, "data")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockSerializerCallContextNonNil(t *testing.T) {
+	ctx := context.Background()
+	expected := &Result{}
+	obj := &MockSerializer{}
+	obj.On("CallContext", ctx, "data").Return(expected, assert.AnError)
+
+	result, err := obj.CallContext(ctx, "data")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Same(t, expected, result)
+	obj.AssertExpectations(t)
+}
+
 func TestMockSerializerCallAsyncNil(t *testing.T) {
 	obj := &MockSerializer{}
 	obj.On("CallAsync", "data").Return(nil, assert.AnError)
@@ -241,3 +325,114 @@ func TestMockSerializerWait(t *testing.T) {
 	assert.Equal(t, "result", result)
 	obj.AssertExpectations(t)
 }
+
+func TestMockCacheImplementsCache(t *testing.T) {
+	assert.Implements(t, (*Cache)(nil), &MockCache{})
+}
+
+func TestMockCacheGet(t *testing.T) {
+	obj := &MockCache{}
+	obj.On("Get", "key").Return("value", true)
+
+	result, ok := obj.Get("key")
+
+	assert.Equal(t, "value", result)
+	assert.True(t, ok)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCachePut(t *testing.T) {
+	obj := &MockCache{}
+	obj.On("Put", "key", "value")
+
+	obj.Put("key", "value")
+
+	obj.AssertExpectations(t)
+}
+
+func TestMockCodecImplementsCodec(t *testing.T) {
+	assert.Implements(t, (*Codec)(nil), &MockCodec{})
+}
+
+func TestMockCodecEncodeNil(t *testing.T) {
+	obj := &MockCodec{}
+	obj.On("Encode", "value").Return(nil, assert.AnError)
+
+	result, err := obj.Encode("value")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCodecEncodeNonNil(t *testing.T) {
+	obj := &MockCodec{}
+	obj.On("Encode", "value").Return([]byte("data"), nil)
+
+	result, err := obj.Encode("value")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCodecDecode(t *testing.T) {
+	obj := &MockCodec{}
+	obj.On("Decode", []byte("data")).Return("value", nil)
+
+	result, err := obj.Decode([]byte("data"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "value", result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCheckpointStoreImplementsCheckpointStore(t *testing.T) {
+	assert.Implements(t, (*CheckpointStore)(nil), &MockCheckpointStore{})
+}
+
+func TestMockCheckpointStoreSave(t *testing.T) {
+	obj := &MockCheckpointStore{}
+	obj.On("Save", []byte("data")).Return(assert.AnError)
+
+	err := obj.Save([]byte("data"))
+
+	assert.Same(t, assert.AnError, err)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCheckpointStoreLoadNil(t *testing.T) {
+	obj := &MockCheckpointStore{}
+	obj.On("Load").Return(nil, assert.AnError)
+
+	result, err := obj.Load()
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockCheckpointStoreLoadNonNil(t *testing.T) {
+	obj := &MockCheckpointStore{}
+	obj.On("Load").Return([]byte("data"), nil)
+
+	result, err := obj.Load()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), result)
+	obj.AssertExpectations(t)
+}
+
+func TestMockSchedulerImplementsScheduler(t *testing.T) {
+	assert.Implements(t, (*Scheduler)(nil), &MockScheduler{})
+}
+
+func TestMockSchedulerChoose(t *testing.T) {
+	obj := &MockScheduler{}
+	obj.On("Choose", 3).Return(2)
+
+	result := obj.Choose(3)
+
+	assert.Equal(t, 2, result)
+	obj.AssertExpectations(t)
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrUnsupportedOption is wrapped by the error with which a
+// constructor panics, or which it returns, if it is passed an Option
+// it does not support.
+var ErrUnsupportedOption = errors.New("Option not supported")
+
+// KeyFunc is a function that computes a key identifying a data item
+// submitted to a Worker.  Data items with equal keys are considered
+// to be equivalent.  The key must be usable as a map key.
+type KeyFunc func(data interface{}) interface{}
+
+// options contains the configuration assembled from a list of
+// Option values.
+type options struct {
+	dedupKey      KeyFunc       // Key function for duplicate suppression
+	dedupRemember bool          // Remember completed keys
+	dedupTTL      time.Duration // How long to remember completed keys
+	cache         Cache         // Cache for Runner.Run results
+	cacheKey      KeyFunc       // Key function for the cache
+
+	checkpointStore    CheckpointStore // Where to save checkpoints
+	checkpointCodec    Codec           // Codec for checkpoint contents
+	checkpointInterval time.Duration   // Interval between checkpoints
+
+	processCommand []string // Command to run for child processes
+
+	heartbeatInterval time.Duration // Interval between heartbeats
+	leaseDuration     time.Duration // How long before an agent is lost
+
+	watchdog         bool          // Whether to run a watchdog
+	watchdogInterval time.Duration // Interval without progress to report
+	watchdogOut      io.Writer     // Where to write watchdog reports
+
+	repanic bool // Whether Wait should re-raise captured panics
+
+	pool     bool          // Whether to use a goroutine pool
+	poolIdle time.Duration // How long idle pool goroutines wait
+
+	integrator       bool // Whether to use an integrator goroutine
+	integratorBuffer int  // Results buffered for the integrator
+
+	name string // Name identifying the worker in panics
+
+	set optionSet // The Options that have been applied
+}
+
+// optionSet is a set of flags identifying Options.
+type optionSet uint
+
+// Flags identifying each of the Options.
+const (
+	optDedup optionSet = 1 << iota
+	optDedupMemory
+	optCache
+	optCheckpoint
+	optProcessCommand
+	optHeartbeat
+	optWatchdog
+	optRepanic
+	optPool
+	optIntegrator
+	optName
+)
+
+// optionNames contains the names of the Options, in the order of
+// their flags.
+var optionNames = []string{
+	"WithDedup",
+	"WithDedupMemory",
+	"WithCache",
+	"WithCheckpoint",
+	"WithProcessCommand",
+	"WithHeartbeat",
+	"WithWatchdog",
+	"WithRepanic",
+	"WithPool",
+	"WithIntegrator",
+	"WithName",
+}
+
+// goOptions is the set of Options supported by NewGoWorker and the
+// constructors built on it.
+const goOptions = optDedup | optDedupMemory | optCache | optCheckpoint |
+	optWatchdog | optRepanic | optPool | optIntegrator | optName
+
+// Option describes an option that may be passed to the Worker
+// constructors and to NewSerializer.  Each Option documents the
+// constructors that support it; passing an Option to a constructor
+// that does not support it is an error wrapping ErrUnsupportedOption.
+type Option func(opts *options)
+
+// newOptions constructs an options structure from a list of Option
+// values.
+func newOptions(opts []Option) *options {
+	result := &options{}
+	for _, opt := range opts {
+		opt(result)
+	}
+
+	return result
+}
+
+// mustOptions is a helper for the constructors that panic, rather
+// than returning an error, if they are passed an unsupported Option.
+// It constructs an options structure from a list of Option values
+// and checks it against the set of Options the named constructor
+// supports.
+func mustOptions(ctor string, supported optionSet, opts []Option) *options {
+	o := newOptions(opts)
+	if err := o.check(ctor, supported); err != nil {
+		panic(err)
+	}
+
+	return o
+}
+
+// check returns an error wrapping ErrUnsupportedOption if any Option
+// has been applied that is not in the set of Options supported by
+// the named constructor.
+func (o *options) check(ctor string, supported optionSet) error {
+	for i, name := range optionNames {
+		if o.set&^supported&(1<<uint(i)) != 0 {
+			return fmt.Errorf("%w: %s does not support %s", ErrUnsupportedOption, ctor, name)
+		}
+	}
+
+	return nil
+}
+
+// WithDedup is an Option that enables suppression of duplicate data
+// items.  The key function is used to compute a key for each data
+// item passed to Worker.Call; if an item with the same key is
+// already being processed, the new item is not passed to Runner.Run
+// or Runner.Integrate, and any CallResult returned for it by
+// AsyncWorker.CallAsync receives the result of the item already
+// being processed.  Suppressed items are counted in the Duplicates
+// field of Stats.  This option is supported by NewGoWorker and
+// NewSynchronousWorker.
+func WithDedup(key KeyFunc) Option {
+	return func(opts *options) {
+		opts.set |= optDedup
+		opts.dedupKey = key
+	}
+}
+
+// WithDedupMemory is an Option that, when used with WithDedup, causes
+// the keys of completed data items to be remembered, so that later
+// items with the same key will also be suppressed.  If the ttl is
+// greater than 0, keys are only remembered for that long after the
+// item completes; otherwise, they are remembered for the lifetime of
+// the Worker.  This option is supported by NewGoWorker and
+// NewSynchronousWorker.
+func WithDedupMemory(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.set |= optDedupMemory
+		opts.dedupRemember = true
+		opts.dedupTTL = ttl
+	}
+}
+
+// WithCache is an Option that enables caching of the results of
+// Runner.Run.  The key function is used to compute a key for each
+// data item, which is then looked up in the cache.  If a result is
+// found, Runner.Run is not called, and the worker's concurrency limit
+// does not apply; the cached result is passed to Runner.Integrate
+// with the Cached field of the Result set.  Otherwise, the result of
+// Runner.Run is stored in the cache, unless Runner.Run panicked.
+// Cache hits are counted in the CacheHits field of Stats.  This
+// option is supported by NewGoWorker and NewSynchronousWorker.
+func WithCache(cache Cache, key KeyFunc) Option {
+	return func(opts *options) {
+		opts.set |= optCache
+		opts.cache = cache
+		opts.cacheKey = key
+	}
+}
+
+// WithCheckpoint is an Option that causes the worker to periodically
+// save a checkpoint to the store.  The checkpoint contains the data
+// items that have been accepted but not yet integrated, encoded with
+// the Codec, and, if the Runner implements Snapshotter, the
+// integrated state.  If the interval is less than or equal to 0, a
+// default of one minute is used.  A final checkpoint is saved by
+// Worker.Wait, which returns any error encountered while saving it;
+// errors saving the periodic checkpoints are ignored.  A worker may
+// be resumed from the checkpoint using ResumeGoWorker.  This option
+// is only supported by NewGoWorker.
+func WithCheckpoint(store CheckpointStore, codec Codec, interval time.Duration) Option {
+	return func(opts *options) {
+		opts.set |= optCheckpoint
+		opts.checkpointStore = store
+		opts.checkpointCodec = codec
+		opts.checkpointInterval = interval
+	}
+}
+
+// WithProcessCommand is an Option that specifies the command to run
+// for the child processes of a process worker, in place of the current
+// executable.  The command must call ServeProcess when IsProcessChild
+// returns true.  This option is only supported by NewProcessWorker.
+func WithProcessCommand(name string, args ...string) Option {
+	return func(opts *options) {
+		opts.set |= optProcessCommand
+		opts.processCommand = append([]string{name}, args...)
+	}
+}
+
+// WithHeartbeat is an Option that sets the interval between the
+// heartbeats exchanged between a coordinator and its agents, and the
+// lease duration after which an agent that has not been heard from is
+// considered lost.  The lease duration should be several times the
+// heartbeat interval.  Values less than or equal to 0 select the
+// defaults of 1 second and 5 seconds.  This option is only supported
+// by NewCoordinator.
+func WithHeartbeat(interval, lease time.Duration) Option {
+	return func(opts *options) {
+		opts.set |= optHeartbeat
+		opts.heartbeatInterval = interval
+		opts.leaseDuration = lease
+	}
+}
+
+// WithWatchdog is an Option that starts a watchdog goroutine, which
+// reports when data items are outstanding but none has completed for
+// the specified interval, which often indicates a deadlock in the
+// Runner or Doer.  The report describes the stall and includes the
+// stacks of all goroutines; it is written to the writer, or to
+// standard error if the writer is nil, once for each stall.  If the
+// interval is less than or equal to 0, a default of one minute is
+// used.  This option is only supported by NewGoWorker and
+// NewSerializer.
+func WithWatchdog(interval time.Duration, out io.Writer) Option {
+	return func(opts *options) {
+		opts.set |= optWatchdog
+		opts.watchdog = true
+		opts.watchdogInterval = interval
+		opts.watchdogOut = out
+	}
+}
+
+// WithRepanic is an Option that causes Wait to panic, once all the
+// data items have been processed and the final result obtained, if
+// any call to Runner.Run or Doer.Do panicked.  The value passed to
+// panic is the *PanicError describing the first such panic, which
+// includes the stack at the time of the original panic.  Panics are
+// still captured and passed to Runner.Integrate or returned to the
+// caller as usual.  This option is supported by NewGoWorker,
+// NewSynchronousWorker, NewStealingWorker, and NewSerializer.
+func WithRepanic() Option {
+	return func(opts *options) {
+		opts.set |= optRepanic
+		opts.repanic = true
+	}
+}
+
+// WithPool is an Option that causes the worker to run Runner.Run in a
+// pool of long-lived goroutines pulling data items from an internal
+// queue, rather than starting a goroutine for each data item.  The
+// pool grows lazily, up to the worker's limit on simultaneously
+// executing goroutines, or the number of CPUs if there is no limit;
+// goroutines exit once they have been idle for the idle timeout.  If
+// the idle timeout is less than or equal to 0, goroutines exit as
+// soon as the queue is empty.  This reduces the scheduler and memory
+// overhead of large backlogs.  Note that, with this option, cached
+// results are also subject to the limit.  This option is only
+// supported by NewGoWorker.
+func WithPool(idle time.Duration) Option {
+	return func(opts *options) {
+		opts.set |= optPool
+		opts.pool = true
+		opts.poolIdle = idle
+	}
+}
+
+// WithIntegrator is an Option that causes Runner.Integrate to be
+// called from a single, dedicated integrator goroutine, rather than
+// from the goroutines that called Runner.Run.  Results are handed to
+// the integrator through a buffer holding the specified number of
+// results; when the buffer is full, the goroutines handing off
+// results keep their place in the worker's limit, so that no further
+// calls to Runner.Run start until the integrator catches up.  This
+// option is only supported by NewGoWorker.
+func WithIntegrator(buffer int) Option {
+	return func(opts *options) {
+		opts.set |= optIntegrator
+		opts.integrator = true
+		opts.integratorBuffer = buffer
+	}
+}
+
+// WithName is an Option that gives the worker or serializer a name,
+// which is recorded in the Name field of any PanicError it captures
+// and included in the error message.  This distinguishes the panics
+// of workers constructed by the same function.  This option is
+// supported by NewGoWorker, NewSynchronousWorker, NewStealingWorker,
+// and NewSerializer.
+func WithName(name string) Option {
+	return func(opts *options) {
+		opts.set |= optName
+		opts.name = name
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func identityKey(data interface{}) interface{} {
+	return data
+}
+
+func TestNewOptionsEmpty(t *testing.T) {
+	result := newOptions(nil)
+
+	assert.Equal(t, &options{}, result)
+}
+
+func TestNewOptionsApplies(t *testing.T) {
+	called := 0
+	opt := func(opts *options) {
+		called++
+	}
+
+	newOptions([]Option{opt, opt})
+
+	assert.Equal(t, 2, called)
+}
+
+func TestWithDedup(t *testing.T) {
+	opts := &options{}
+
+	WithDedup(identityKey)(opts)
+
+	assert.NotNil(t, opts.dedupKey)
+	assert.False(t, opts.dedupRemember)
+}
+
+func TestWithDedupMemory(t *testing.T) {
+	opts := &options{}
+
+	WithDedupMemory(time.Second)(opts)
+
+	assert.True(t, opts.dedupRemember)
+	assert.Equal(t, time.Second, opts.dedupTTL)
+}
+
+func TestWithCache(t *testing.T) {
+	cache := &MockCache{}
+	opts := &options{}
+
+	WithCache(cache, identityKey)(opts)
+
+	assert.Same(t, cache, opts.cache)
+	assert.NotNil(t, opts.cacheKey)
+}
+
+func TestWithCheckpoint(t *testing.T) {
+	store := &MockCheckpointStore{}
+	opts := &options{}
+
+	WithCheckpoint(store, JSONCodec{}, time.Second)(opts)
+
+	assert.Same(t, store, opts.checkpointStore)
+	assert.Equal(t, JSONCodec{}, opts.checkpointCodec)
+	assert.Equal(t, time.Second, opts.checkpointInterval)
+}
+
+func TestWithProcessCommand(t *testing.T) {
+	opts := &options{}
+
+	WithProcessCommand("cmd", "arg1", "arg2")(opts)
+
+	assert.Equal(t, []string{"cmd", "arg1", "arg2"}, opts.processCommand)
+}
+
+func TestWithHeartbeat(t *testing.T) {
+	opts := &options{}
+
+	WithHeartbeat(time.Second, 5*time.Second)(opts)
+
+	assert.Equal(t, time.Second, opts.heartbeatInterval)
+	assert.Equal(t, 5*time.Second, opts.leaseDuration)
+}
+
+func TestWithWatchdog(t *testing.T) {
+	out := &bytes.Buffer{}
+	opts := &options{}
+
+	WithWatchdog(time.Second, out)(opts)
+
+	assert.True(t, opts.watchdog)
+	assert.Equal(t, time.Second, opts.watchdogInterval)
+	assert.Same(t, out, opts.watchdogOut)
+}
+
+func TestWithRepanic(t *testing.T) {
+	opts := &options{}
+
+	WithRepanic()(opts)
+
+	assert.True(t, opts.repanic)
+}
+
+func TestWithPool(t *testing.T) {
+	opts := &options{}
+
+	WithPool(time.Second)(opts)
+
+	assert.True(t, opts.pool)
+	assert.Equal(t, time.Second, opts.poolIdle)
+}
+
+func TestWithIntegrator(t *testing.T) {
+	opts := &options{}
+
+	WithIntegrator(5)(opts)
+
+	assert.True(t, opts.integrator)
+	assert.Equal(t, 5, opts.integratorBuffer)
+}
+
+func TestWithName(t *testing.T) {
+	opts := &options{}
+
+	WithName("fetch")(opts)
+
+	assert.Equal(t, "fetch", opts.name)
+}
+
+func TestOptionsCheckSupported(t *testing.T) {
+	obj := newOptions([]Option{WithRepanic(), WithName("fetch")})
+
+	err := obj.check("test", optRepanic|optName)
+
+	assert.NoError(t, err)
+}
+
+func TestOptionsCheckUnsupported(t *testing.T) {
+	obj := newOptions([]Option{WithRepanic(), WithPool(0)})
+
+	err := obj.check("test", optRepanic)
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	assert.EqualError(t, err, "Option not supported: test does not support WithPool")
+}
+
+func TestMustOptionsSupported(t *testing.T) {
+	result := mustOptions("test", optRepanic, []Option{WithRepanic()})
+
+	assert.True(t, result.repanic)
+}
+
+func TestMustOptionsUnsupported(t *testing.T) {
+	assert.PanicsWithError(t, "Option not supported: test does not support WithPool", func() {
+		mustOptions("test", optRepanic, []Option{WithPool(0)})
+	})
+}
@@ -24,13 +24,15 @@ import (
 )
 
 // managerItem is a structure for communicating to the manager.  When
-// coming from parallelWorker.Call, it contains data to work; when
+// coming from parallelWorker.Call, it contains an item to work; when
 // coming from parallelWorker.Wait, it contains an instruction for the
-// manager to exit.  From workers, it either contains a result or a
-// flag that the worker has exited.
+// manager to exit.  From workers, it either contains the worked item
+// and its result or a flag that the worker has exited.
 type managerItem struct {
-	data interface{} // Data to work or result to integrate
-	done bool        // If true, exit or has exited
+	item    *workItem   // Item to work or that was worked
+	result  *Result     // Result to integrate
+	spawned []*workItem // Items spawned while working the item
+	done    bool        // If true, exit or has exited
 }
 
 // parallelWorker is an implementation of the Worker interface that
@@ -45,6 +47,7 @@ type parallelWorker struct {
 	manager *parallelManager // The manager for the workers
 	gonner  *sync.Once       // A once incarnation for getting the result
 	result  interface{}      // The result from the work
+	leak    *leakRecord      // Leak tracking record
 }
 
 // NewParallelWorker constructs a worker utilizing a pool of worker
@@ -60,16 +63,21 @@ type parallelWorker struct {
 // NewParallelWorker has been deprecated since 0.3.4 and should not be
 // used in new code.
 func NewParallelWorker(runner Runner, workers int) Worker {
+	checkRunner(runner)
+
 	// Normalize workers
 	if workers <= 0 {
 		workers = runtime.NumCPU()
 	}
 
-	return &parallelWorker{
+	w := &parallelWorker{
 		runner:  runner,
 		workers: workers,
 		gonner:  &sync.Once{},
 	}
+	w.leak = trackLeaks("NewParallelWorker", w)
+
+	return w
 }
 
 // startManager initializes the manager and sets it running.
@@ -79,7 +87,7 @@ func (w *parallelWorker) startManager() {
 		worker:  w,
 		queue:   &list.List{},
 		submit:  make(chan *managerItem, w.workers),
-		work:    make(chan interface{}, w.workers),
+		work:    make(chan *workItem, w.workers),
 		results: make(chan *managerItem, w.workers),
 		done:    make(chan bool, 1),
 	}
@@ -98,10 +106,9 @@ func (w *parallelWorker) getResult() {
 	w.state = pResult
 }
 
-// Call is the method used to submit data to be worked in a call to
-// the Runner.Run method.  It may return an error if the worker has
-// been shut down through a call to Wait.
-func (w *parallelWorker) Call(data interface{}) error {
+// call is a helper for Call and CallAsync that submits a work item to
+// the manager.
+func (w *parallelWorker) call(item *workItem) error {
 	// Lock the mutex
 	w.Lock()
 	defer w.Unlock()
@@ -112,15 +119,39 @@ func (w *parallelWorker) Call(data interface{}) error {
 		w.state = pRunning
 
 	case pClosed, pResult: // Oh, we're closed
+		w.leak.markClosedCall()
 		return ErrClosed
 	}
 
 	// OK, submit the data
-	w.manager.submit <- &managerItem{data: data}
+	w.manager.submit <- &managerItem{item: item}
 
 	return nil
 }
 
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  It may return an error if the worker has
+// been shut down through a call to Wait.
+func (w *parallelWorker) Call(data interface{}) error {
+	return w.call(&workItem{data: data})
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (w *parallelWorker) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	if err := w.call(item); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
 // Wait is called to shut down the worker and return the final result;
 // it will block the caller until all data has been processed and all
 // worker goroutines have stopped.  Note that the final result,
@@ -129,6 +160,8 @@ func (w *parallelWorker) Call(data interface{}) error {
 // worker will go straight to a stopped state, and no further Call
 // calls may be made; no error will be returned in that case.
 func (w *parallelWorker) Wait() (interface{}, error) {
+	w.leak.markWaited()
+
 	// Lock the mutex
 	w.Lock()
 
@@ -168,20 +201,48 @@ type parallelManager struct {
 	queue   *list.List        // A queue of submitted work items
 	waiting int               // Number of results we're waiting on
 	submit  chan *managerItem // A channel for submitting run requests
-	work    chan interface{}  // A channel for sending work to the workers
+	work    chan *workItem    // A channel for sending work to the workers
 	results chan *managerItem // A channel for workers to return results
 	done    chan bool         // A channel to tell Wait the manager is done
 }
 
-// workRunner is the actual worker routine.
-func (w *parallelManager) workRunner(work <-chan interface{}) {
+// workRunner is the actual worker routine.  Items spawned by
+// SpawningRunner.RunWithSpawner are collected and returned to the
+// manager along with the result, since sending them to the manager
+// from here could deadlock.  Partial results emitted by
+// StreamingRunner.RunStreaming are sent to the manager as they
+// arrive.
+func (w *parallelManager) workRunner(work <-chan *workItem) {
 	// Make sure to signal manager when we exit
 	defer func() { w.results <- &managerItem{done: true} }()
 
+	// Set up the worker-local state lazily, tearing it down on exit
+	local := newWorkerLocal(w.worker.runner)
+	defer local.close()
+
+	// Collect spawned items and send partial results
+	var spawned []*workItem
+	hooks := runHooks{
+		local: local,
+		spawn: func(data interface{}) {
+			spawned = append(spawned, &workItem{data: data, spawn: true})
+		},
+		emit: func(result interface{}) {
+			w.results <- &managerItem{
+				result: &Result{Result: result, Partial: true},
+			}
+		},
+	}
+
 	// Do the work
-	for data := range work {
-		result := panicer(w.worker.runner.Run, data)
-		w.results <- &managerItem{data: result}
+	for item := range work {
+		spawned = nil
+		result := runItem("NewParallelWorker", w.worker.runner, hooks, item.data)
+		w.results <- &managerItem{
+			item:    item,
+			result:  result,
+			spawned: spawned,
+		}
 	}
 }
 
@@ -204,11 +265,13 @@ func (w *parallelManager) receiveWork(newItem *managerItem) {
 	}
 
 	// Push the work item onto the queue
-	w.queue.PushBack(newItem.data)
+	w.queue.PushBack(newItem.item)
 }
 
 // receiveResult handles the receipt of a result from a worker.  It
-// will call the Runner.Integrate method with that result.
+// will queue any items spawned while working the item, then call the
+// Runner.Integrate method with that result.  Partial results are only
+// passed to Runner.Integrate.
 func (w *parallelManager) receiveResult(result *managerItem) {
 	// Is it an indication that the worker exited?
 	if result.done {
@@ -216,50 +279,69 @@ func (w *parallelManager) receiveResult(result *managerItem) {
 		return
 	}
 
+	// Is it a partial result?
+	if result.result.Partial {
+		w.worker.runner.Integrate(w, result.result)
+		return
+	}
+
 	// Received a result, so decrement the waiting count
 	w.waiting--
 
+	// Queue the spawned items
+	for _, item := range result.spawned {
+		w.queue.PushBack(item)
+	}
+
 	// Integrate the result
-	w.worker.runner.Integrate(w, result.data.(*Result))
+	w.worker.runner.Integrate(w, result.result)
+	result.item.resolve(result.result)
 }
 
 // managerSelect performs an appropriate select call to coordinate the
-// various channels used by the manager.
+// various channels used by the manager.  Cases that don't apply are
+// disabled by selecting on a nil channel, which never proceeds.
 func (w *parallelManager) managerSelect() bool {
-	selectors := []selector{}
-
-	// If there are any elements in the queue, add a selector to
-	// send the top one to the workers
+	// If there are any elements in the queue, enable sending the
+	// top one to the workers
+	var work chan<- *workItem
+	var next *workItem
 	if w.queue.Len() > 0 {
-		selectors = append(selectors, selectSend(w.work, w.queue.Front().Value, func() {
-			w.queue.Remove(w.queue.Front())
-			w.waiting++
-		}))
+		work = w.work
+		next = w.queue.Front().Value.(*workItem)
 	}
 
-	// If we're not exiting, add a selector to receive from the
-	// submit channel
+	// If we're not exiting, enable receiving from the submit
+	// channel
+	var submit <-chan *managerItem
 	if !w.exiting {
-		selectors = append(selectors, selectRecv(w.submit, func(value interface{}, ok bool) {
-			w.receiveWork(value.(*managerItem))
-		}))
+		submit = w.submit
 	}
 
-	// If we still have workers, add a selector to receive results
-	// from the results channel
+	// If we still have workers, enable receiving results from the
+	// results channel
+	var results <-chan *managerItem
 	if w.count > 0 {
-		selectors = append(selectors, selectRecv(w.results, func(value interface{}, ok bool) {
-			w.receiveResult(value.(*managerItem))
-		}))
+		results = w.results
 	}
 
-	// If we have no selectors, do nothing
-	if len(selectors) <= 0 {
+	// If we have no cases, do nothing
+	if work == nil && submit == nil && results == nil {
 		return false
 	}
 
 	// Do the select
-	doSelect(selectors)
+	select {
+	case work <- next:
+		w.queue.Remove(w.queue.Front())
+		w.waiting++
+
+	case item := <-submit:
+		w.receiveWork(item)
+
+	case result := <-results:
+		w.receiveResult(result)
+	}
 
 	return true
 }
@@ -289,11 +371,26 @@ func (w *parallelManager) manager() {
 // been shut down through a call to Wait.
 func (w *parallelManager) Call(data interface{}) error {
 	// Add the data to the queue
-	w.queue.PushBack(data)
+	w.queue.PushBack(&workItem{data: data})
 
 	return nil
 }
 
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that the
+// CallResult must not be waited upon from Runner.Integrate, as that
+// would deadlock.
+func (w *parallelManager) CallAsync(data interface{}) (CallResult, error) {
+	// Add the item to the queue
+	item, cr := newAsyncItem(data)
+	w.queue.PushBack(item)
+
+	return cr, nil
+}
+
 // Wait is called to shut down the worker and return the final result;
 // it will block the caller until all data has been processed and all
 // worker goroutines have stopped.  Note that the final result,
@@ -310,13 +407,31 @@ func (w *parallelManager) Wait() (interface{}, error) {
 // of goroutines allowed to operate at once.
 type goWorker struct {
 	sync.Mutex
-	state  pState              // State of the worker
-	serial *sync.Mutex         // A mutex for serializing Runner.Integrate
-	runner Runner              // The runner to be invoked by the workers
-	gonner *sync.Once          // A once incarnation for getting the result
-	result interface{}         // The result from the work
-	limit  *semaphore.Weighted // Semaphore to limit concurrent execution
-	wg     *sync.WaitGroup     // Wait group to use for waits
+	state   pState              // State of the worker
+	serial  *sync.Mutex         // A mutex for serializing Runner.Integrate
+	runner  Runner              // The runner to be invoked by the workers
+	gonner  *sync.Once          // A once incarnation for getting the result
+	result  interface{}         // The result from the work
+	limit   *semaphore.Weighted // Semaphore to limit concurrent execution
+	wg      *sync.WaitGroup     // Wait group to use for waits
+	dedup   *deduper            // Optional duplicate suppression
+	memo    *memoizer           // Optional result cache
+	ckpt    *checkpointer       // Optional checkpointing
+	wal     *walQueue           // Optional durable queue
+	calls   int64               // Number of items accepted by Call
+	err     error               // Error from the final checkpoint or log
+	leak    *leakRecord         // Leak tracking record
+	active  *goroutineMarker    // Marks goroutines running the Runner
+	inInteg *goroutineMarker    // Marks goroutines running Integrate
+	watch   *watchdog           // Optional stall watchdog
+	repanic *repanicker         // Optional re-raising of panics in Wait
+	name    string              // Name given with WithName
+	pool    *workerPool         // Optional goroutine pool
+	integ   *integrator         // Optional integrator goroutine
+	shards  *shardSet           // Shards for a ShardedRunner
+	locals  *localSet           // Worker-local states for a LocalRunner
+	idle    *idleTracker        // Tracks items for IdleWorker
+	done    *completion         // Signals completion for ClosableWorker
 }
 
 // NewGoWorker constructs a worker utilizing a semaphore to limit
@@ -324,92 +439,384 @@ type goWorker struct {
 // Wait invocations from any goroutine.  A go worker is initialived
 // with a desired maximum number of simultaneously executing
 // goroutines; if that number is less than or equal to 0, no limit is
-// enforced on the number of simultaneous goroutines.
-func NewGoWorker(runner Runner, workers int) Worker {
+// enforced on the number of simultaneous goroutines.  Passing an
+// Option that NewGoWorker does not support, such as WithHeartbeat,
+// panics with an error wrapping ErrUnsupportedOption.  The returned
+// Worker also implements AsyncWorker, StatsWorker, IdleWorker, and
+// ClosableWorker.
+func NewGoWorker(runner Runner, workers int, opts ...Option) Worker {
+	return newGoWorker(runner, workers, mustOptions("NewGoWorker", goOptions, opts))
+}
+
+// newGoWorker is a helper for NewGoWorker and the constructors built
+// on it that constructs the worker from checked options.
+func newGoWorker(runner Runner, workers int, o *options) *goWorker {
+	checkRunner(runner)
+
 	// Initialize a semaphore
 	var sem *semaphore.Weighted
 	if workers > 0 {
 		sem = semaphore.NewWeighted(int64(workers))
 	}
 
-	return &goWorker{
-		serial: &sync.Mutex{},
-		runner: runner,
-		gonner: &sync.Once{},
-		limit:  sem,
-		wg:     &sync.WaitGroup{},
+	w := &goWorker{
+		serial:  &sync.Mutex{},
+		runner:  runner,
+		gonner:  &sync.Once{},
+		limit:   sem,
+		wg:      &sync.WaitGroup{},
+		active:  newGoroutineMarker(),
+		inInteg: newGoroutineMarker(),
+		dedup:   newDeduper(o),
+		memo:    newMemoizer(o),
+		ckpt:    newCheckpointer(o),
+		watch:   newWatchdog("NewGoWorker", o),
+		repanic: newRepanicker(o),
+		name:    o.name,
+		integ:   newIntegrator(o),
+		shards:  newShardSet(runner),
+		locals:  newLocalSet(runner),
+		idle:    newIdleTracker(),
+		done:    newCompletion(),
+	}
+	w.leak = trackLeaks("NewGoWorker", w)
+
+	// Set up the goroutine pool; it enforces the limit itself
+	if o.pool {
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		w.pool = newWorkerPool(workers, o.poolIdle, runner, w.work)
+		w.limit = nil
 	}
+
+	return w
 }
 
-// work is a helper that executes in a fresh goroutine.  It acquires
-// the semaphore, executes the runner's Run method with the desired
-// data, runs the runner's Integrate method with the result, then
-// dones the wait group.
-func (w *goWorker) work(data interface{}) {
-	// Signal done when we're done
-	defer w.wg.Done()
+// work is a helper that executes in a fresh goroutine.  It marks the
+// goroutine, so that re-entrant waits can be detected, then calls
+// process.  When called from a pool goroutine, the goroutine's
+// worker-local state is passed in.
+func (w *goWorker) work(item *workItem, local *workerLocal) {
+	w.active.run(func() {
+		w.process(item, local)
+	})
+}
 
-	// First, acquire the semaphore; this limits the parallelism
-	if w.limit != nil {
-		w.limit.Acquire(context.Background(), 1) // cannot error with background context
+// process is a helper for work that acquires the semaphore, executes
+// the runner's Run method with the desired data, then integrates the
+// result.  If the result is found in the cache, the semaphore is not
+// acquired and the Run method is not called.  If the worker has an
+// integrator, the result is handed off to it before the semaphore is
+// released, so that a full buffer holds back further calls to the Run
+// method.
+func (w *goWorker) process(item *workItem, local *workerLocal) {
+	// Check the cache
+	var key interface{}
+	var result *Result
+	if w.memo != nil {
+		key, result = w.memo.get(item.data)
 	}
 
-	// Now we can run the runner
-	result := panicer(w.runner.Run, data)
+	if result == nil {
+		// First, acquire the semaphore; this limits the
+		// parallelism
+		if w.limit != nil {
+			w.limit.Acquire(context.Background(), 1) // cannot error with background context
+		}
+
+		// Now we can run the runner
+		result = w.run(item.data, local)
 
-	// Release the semaphore
-	if w.limit != nil {
-		w.limit.Release(1)
+		// Save the result in the cache
+		if w.memo != nil {
+			w.memo.put(key, result)
+		}
+
+		// Hand off the result to the integrator
+		if w.integ != nil {
+			w.integ.submit(item, result)
+		}
+
+		// Release the semaphore
+		if w.limit != nil {
+			w.limit.Release(1)
+		}
+	} else if w.integ != nil {
+		w.integ.submit(item, result)
 	}
 
-	// Next, lock the serialization mutex
+	if w.integ == nil {
+		w.integrate(item, result)
+	}
+}
+
+// integrate is a helper that runs the runner's Integrate method with
+// the result of working an item, then dones the wait group.  It is
+// called by work, or by the integrator goroutine if there is one.  If
+// the runner implements ShardedRunner, the result is integrated into
+// a shard with ShardedRunner.IntegrateShard instead, without locking
+// the serialization mutex.  Partial results, from emit, are only
+// integrated.
+func (w *goWorker) integrate(item *workItem, result *Result) {
+	// Signal done when we're done
+	if !result.Partial {
+		defer w.wg.Done()
+		defer w.idle.done()
+	}
+
+	// Integrate the result
+	w.repanic.record(result)
+	w.integrateResult(item, result)
+	if result.Partial {
+		return
+	}
+	item.resolve(result)
+	if w.dedup != nil {
+		w.dedup.release(item, result)
+	}
+	w.watch.complete()
+}
+
+// integrateResult is a helper for integrate that passes the result to
+// the runner.  It marks the goroutine for the duration of the call,
+// so that call can accept items the runner submits after Close.  The
+// item is settled before the lock serializing integration is
+// released, so that a checkpoint never saves the integrated state
+// with the item still pending.
+func (w *goWorker) integrateResult(item *workItem, result *Result) {
+	if w.shards != nil {
+		w.inInteg.run(func() {
+			w.shards.integrate(w, result, func() {
+				w.settle(item, result)
+			})
+		})
+		return
+	}
+
+	// Lock the serialization mutex
 	w.serial.Lock()
 	defer w.serial.Unlock()
 
-	// Integrate the result
-	w.runner.Integrate(w, result)
+	w.inInteg.run(func() {
+		w.runner.Integrate(w, result)
+	})
+	w.settle(item, result)
+}
+
+// settle is a helper for integrateResult that records that an item
+// has been integrated, removing it from the checkpoint and
+// acknowledging it in the durable queue.  Partial results leave the
+// item pending.
+func (w *goWorker) settle(item *workItem, result *Result) {
+	if result.Partial {
+		return
+	}
+	if w.ckpt != nil {
+		w.ckpt.remove(item)
+	}
+	if w.wal != nil {
+		w.wal.ack(item)
+	}
+}
+
+// run is a helper for work that runs the runner with the data.  If
+// the runner is a LocalRunner and no worker-local state was passed
+// in, one is taken from the set for the duration of the call.
+func (w *goWorker) run(data interface{}, local *workerLocal) *Result {
+	if local == nil && w.locals != nil {
+		local = w.locals.get()
+		defer w.locals.put(local)
+	}
+
+	return nameResult(runItem("NewGoWorker", w.runner, runHooks{
+		local: local,
+		spawn: w.spawn,
+		emit:  w.emit,
+	}, data), w.name)
+}
+
+// spawn submits an item spawned by SpawningRunner.RunWithSpawner.  An
+// error, such as from the durable queue, is raised as a panic, so
+// that it is reported as the result of the spawning data item.
+func (w *goWorker) spawn(data interface{}) {
+	if err := w.call(&workItem{data: data, spawn: true}); err != nil {
+		panic(err)
+	}
+}
+
+// emit integrates a partial result emitted by
+// StreamingRunner.RunStreaming, handing it to the integrator if there
+// is one.
+func (w *goWorker) emit(result interface{}) {
+	partial := &Result{Result: result, Partial: true}
+	if w.integ != nil {
+		w.integ.submit(nil, partial)
+	} else {
+		w.integrate(nil, partial)
+	}
+}
+
+// checkpointLock returns the lock the checkpointer holds while
+// taking a snapshot.  For a ShardedRunner, it also merges the shards,
+// so that the snapshot includes them.
+func (w *goWorker) checkpointLock() sync.Locker {
+	if w.shards != nil {
+		return (*shardLock)(w.shards)
+	}
+
+	return w.serial
 }
 
 // getResult is a helper for Wait to retrieve the result.  It's called
-// with goWorker.gonner to ensure that it only gets called once.
+// with goWorker.gonner to ensure that it only gets called once.  If
+// checkpointing is enabled, it also saves the final checkpoint, and if
+// the worker has a durable queue, it closes the queue's log.  Once the
+// result is saved, it signals completion.
 func (w *goWorker) getResult() {
-	w.Lock()
-	defer w.Unlock()
+	w.watch.finish()
+	if w.pool != nil {
+		w.pool.close()
+	}
+	if w.integ != nil {
+		w.integ.finish()
+	}
+	if w.locals != nil {
+		w.locals.close()
+	}
+	if w.shards != nil {
+		w.shards.merge()
+	}
 
+	var err error
+	if w.ckpt != nil {
+		err = w.ckpt.finish(w.checkpointLock(), w.runner)
+	}
+	if w.wal != nil {
+		if walErr := w.wal.close(); err == nil {
+			err = walErr
+		}
+	}
+
+	w.Lock()
 	w.result = w.runner.Result()
+	w.err = err
 	w.state = pResult
+	w.Unlock()
+
+	// Signal completion
+	if err == nil {
+		err = w.repanic.first()
+	}
+	w.done.fire(w.result, err)
 }
 
-// Call is the method used to submit data to be worked in a call to
-// the Runner.Run method.  It may return an error if the worker has
-// been shut down through a call to Wait.
-func (w *goWorker) Call(data interface{}) error {
+// call is a helper for Call and CallAsync that starts a goroutine to
+// work the item.
+func (w *goWorker) call(item *workItem) error {
 	// Check the state
 	w.Lock()
 	switch w.state {
 	case pNew: // Need to start up
 		w.state = pRunning
+		if w.ckpt != nil {
+			w.ckpt.start(w.checkpointLock(), w.runner)
+		}
+		w.watch.start()
+		if w.integ != nil {
+			w.integ.start(w.active, w.integrate)
+		}
 
 	case pClosed, pResult: // Oh, we're closed
-		return ErrClosed
+		// Accept spawned items, and items submitted by the
+		// runner after Close, even when closed, so we work all
+		// items
+		if !item.spawn && !w.inInteg.contains() {
+			w.Unlock()
+			w.leak.markClosedCall()
+			return ErrClosed
+		}
+	}
+
+	// Durably record the item, unless it is being redelivered
+	if w.wal != nil && item.logID == 0 {
+		if err := w.wal.append(item); err != nil {
+			w.Unlock()
+			return err
+		}
+	}
+	w.calls++
+
+	// Suppress duplicates
+	if w.dedup != nil && !w.dedup.claim(item) {
+		if w.wal != nil {
+			w.wal.ack(item)
+		}
+		w.Unlock()
+		return nil
 	}
-	w.Unlock()
 
-	// Start a new worker
+	// Record the item for checkpoints
+	if w.ckpt != nil {
+		w.ckpt.add(item)
+	}
+	w.watch.accept()
 	w.wg.Add(1)
-	go w.work(data)
+	w.idle.add()
+	w.Unlock()
+
+	// Start a new worker, or hand the item to the pool
+	if w.pool != nil {
+		w.pool.submit(item)
+	} else {
+		go w.work(item, nil)
+	}
 
 	return nil
 }
 
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  It may return an error if the worker has
+// been shut down through a call to Wait.
+func (w *goWorker) Call(data interface{}) error {
+	return w.call(&workItem{data: data})
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (w *goWorker) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	if err := w.call(item); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
 // Wait is called to shut down the worker and return the final result;
 // it will block the caller until all data has been processed and all
 // worker goroutines have stopped.  Note that the final result,
 // generated by Runner.Result, is saved by Worker to satisfy later
 // calls to Wait.  If Wait is called before any calls to Call, the
 // worker will go straight to a stopped state, and no further Call
-// calls may be made; no error will be returned in that case.
+// calls may be made; no error will be returned in that case.  If
+// Wait is called from Runner.Run or Runner.Integrate, it returns
+// ErrWouldDeadlock.  If the WithRepanic option was used and any call
+// to Runner.Run panicked, Wait panics with the *PanicError describing
+// the first such panic.
 func (w *goWorker) Wait() (interface{}, error) {
+	// Waiting from a worker goroutine would wait on itself
+	if w.active.contains() {
+		return nil, ErrWouldDeadlock
+	}
+
+	w.leak.markWaited()
+
 	// Wait for all outstanding work to be completed
 	w.wg.Wait()
 
@@ -435,6 +842,86 @@ func (w *goWorker) Wait() (interface{}, error) {
 	case pResult: // Have result, just need to unlock
 		w.Unlock()
 	}
+	w.repanic.check()
 
-	return w.result, nil
+	return w.result, w.err
+}
+
+// Close shuts down the worker like Wait, but without waiting for it to
+// finish.  No further calls to Call may be made, save from
+// Runner.Integrate; the data already submitted continues to be
+// processed, and Runner.Result is called from a separate goroutine
+// once it has been.
+func (w *goWorker) Close() {
+	w.leak.markWaited()
+
+	w.Lock()
+	defer w.Unlock()
+
+	// Close the worker, if it hasn't been already
+	switch w.state {
+	case pNew, pRunning:
+		w.state = pClosed
+		go func() {
+			w.wg.Wait()
+			w.gonner.Do(w.getResult)
+		}()
+	}
+}
+
+// Done returns a channel that is closed once Runner.Result has been
+// called and its result saved.
+func (w *goWorker) Done() <-chan struct{} {
+	return w.done.channel()
+}
+
+// OnComplete registers a function to be called with the final result
+// and error once Runner.Result has been called.  If the result has
+// already been computed, the function is called immediately.
+func (w *goWorker) OnComplete(fn func(result interface{}, err error)) {
+	w.done.onComplete(fn)
+}
+
+// Flush blocks until the worker is idle; that is, until every data
+// item accepted by Call has been passed to Runner.Integrate.  Unlike
+// Wait, it leaves the worker open for further calls to Call.  It is
+// equivalent to WaitIdle with a background context.
+func (w *goWorker) Flush() error {
+	return w.WaitIdle(context.Background())
+}
+
+// WaitIdle is a variant of Flush that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  If WaitIdle is called from Runner.Run or
+// Runner.Integrate, it returns ErrWouldDeadlock.
+func (w *goWorker) WaitIdle(ctx context.Context) error {
+	// Waiting from a worker goroutine would wait on itself
+	if w.active.contains() {
+		return ErrWouldDeadlock
+	}
+
+	return w.idle.wait(ctx)
+}
+
+// Idle returns a channel that is closed once the worker is idle.  If
+// the worker is idle when Idle is called, the channel is already
+// closed.
+func (w *goWorker) Idle() <-chan struct{} {
+	return w.idle.channel()
+}
+
+// Stats returns a snapshot of the statistics for the worker.
+func (w *goWorker) Stats() Stats {
+	w.Lock()
+	defer w.Unlock()
+
+	stats := Stats{Calls: w.calls}
+	if w.dedup != nil {
+		stats.Duplicates = w.dedup.count()
+	}
+	if w.memo != nil {
+		stats.CacheHits = w.memo.count()
+	}
+
+	return stats
 }
@@ -16,9 +16,12 @@ package parallelizer
 
 import (
 	"container/list"
+	"context"
+	"reflect"
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -30,6 +33,10 @@ func TestParallelWorkerImplementsWorker(t *testing.T) {
 	assert.Implements(t, (*Worker)(nil), &parallelWorker{})
 }
 
+func TestParallelWorkerImplementsAsyncWorker(t *testing.T) {
+	assert.Implements(t, (*AsyncWorker)(nil), &parallelWorker{})
+}
+
 func TestNewParallelWorkerBase(t *testing.T) {
 	runner := &MockRunner{}
 
@@ -54,6 +61,12 @@ func TestNewParallelWorkerNumCPU(t *testing.T) {
 	}, result)
 }
 
+func TestNewParallelWorkerSpawningStreaming(t *testing.T) {
+	assert.PanicsWithValue(t, ErrSpawningStreaming, func() {
+		NewParallelWorker(&spawnStreamRunner{}, 1)
+	})
+}
+
 func TestParallelWorkerStartManager(t *testing.T) {
 	obj := &parallelWorker{
 		workers: 3,
@@ -122,7 +135,38 @@ func TestParallelWorkerCallRunning(t *testing.T) {
 	assert.Equal(t, pRunning, obj.state)
 	assert.Same(t, manager, obj.manager)
 	value := <-manager.submit
-	assert.Equal(t, &managerItem{data: "data"}, value)
+	assert.Equal(t, &managerItem{item: &workItem{data: "data"}}, value)
+}
+
+func TestParallelWorkerCallAsyncRunning(t *testing.T) {
+	manager := &parallelManager{
+		submit: make(chan *managerItem, 1),
+	}
+	obj := &parallelWorker{
+		state:   pRunning,
+		manager: manager,
+	}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, cr)
+	value := <-manager.submit
+	assert.Equal(t, "data", value.item.data)
+	value.item.resolve(&Result{Result: "result"})
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+}
+
+func TestParallelWorkerCallAsyncClosed(t *testing.T) {
+	obj := &parallelWorker{
+		state: pClosed,
+	}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Nil(t, cr)
+	assert.Nil(t, obj.manager)
 }
 
 func TestParallelWorkerCallClosed(t *testing.T) {
@@ -241,10 +285,39 @@ func TestParallelManagerImplementsWorker(t *testing.T) {
 	assert.Implements(t, (*Worker)(nil), &parallelManager{})
 }
 
+func TestParallelManagerImplementsAsyncWorker(t *testing.T) {
+	assert.Implements(t, (*AsyncWorker)(nil), &parallelManager{})
+}
+
+func TestParallelManagerWorkRunnerLocal(t *testing.T) {
+	work := make(chan *workItem, 2)
+	work <- &workItem{data: 1}
+	work <- &workItem{data: 2}
+	close(work)
+	runner := &localRunner{}
+	obj := &parallelManager{
+		worker: &parallelWorker{
+			runner: runner,
+		},
+		results: make(chan *managerItem, 3),
+	}
+
+	obj.workRunner(work)
+
+	assert.Equal(t, &Result{Result: 1}, (<-obj.results).result)
+	assert.Equal(t, &Result{Result: 2}, (<-obj.results).result)
+	assert.True(t, (<-obj.results).done)
+	assert.Equal(t, 1, runner.setups)
+	assert.Equal(t, 1, runner.teardowns)
+	assert.Equal(t, int32(0), runner.shared)
+}
+
 func TestParallelManagerWorkRunner(t *testing.T) {
-	work := make(chan interface{}, 2)
-	work <- "data1"
-	work <- "data2"
+	item1 := &workItem{data: "data1"}
+	item2 := &workItem{data: "data2"}
+	work := make(chan *workItem, 2)
+	work <- item1
+	work <- item2
 	close(work)
 	runner := &MockRunner{}
 	runner.On("Run", "data1").Return("result1")
@@ -264,8 +337,8 @@ func TestParallelManagerWorkRunner(t *testing.T) {
 		results = append(results, item)
 	}
 	assert.Equal(t, []*managerItem{
-		{data: &Result{Result: "result1"}},
-		{data: &Result{Result: "result2"}},
+		{item: item1, result: &Result{Result: "result1"}},
+		{item: item2, result: &Result{Result: "result2"}},
 		{done: true},
 	}, results)
 	runner.AssertExpectations(t)
@@ -276,7 +349,7 @@ func TestParallelManagerStartWorkers(t *testing.T) {
 		worker: &parallelWorker{
 			workers: 3,
 		},
-		work:    make(chan interface{}),
+		work:    make(chan *workItem),
 		results: make(chan *managerItem),
 	}
 
@@ -294,13 +367,13 @@ func TestParallelManagerReceiveWorkItem(t *testing.T) {
 	obj := &parallelManager{
 		queue: &list.List{},
 	}
-	item := &managerItem{data: "data"}
+	item := &managerItem{item: &workItem{data: "data"}}
 
 	obj.receiveWork(item)
 
 	assert.False(t, obj.exiting)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Same(t, item.item, obj.queue.Front().Value)
 }
 
 func TestParallelManagerReceiveWorkExit(t *testing.T) {
@@ -325,7 +398,10 @@ func TestParallelManagerReceiveResultData(t *testing.T) {
 		waiting: 3,
 	}
 	runner.On("Integrate", obj, &Result{Result: "data"})
-	item := &managerItem{data: &Result{Result: "data"}}
+	item := &managerItem{
+		item:   &workItem{data: "data"},
+		result: &Result{Result: "data"},
+	}
 
 	obj.receiveResult(item)
 
@@ -334,6 +410,56 @@ func TestParallelManagerReceiveResultData(t *testing.T) {
 	runner.AssertExpectations(t)
 }
 
+func TestParallelManagerReceiveResultResolves(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &parallelManager{
+		worker: &parallelWorker{
+			runner: runner,
+		},
+		count:   5,
+		waiting: 3,
+	}
+	runner.On("Integrate", obj, &Result{Result: "data"})
+	wi, cr := newAsyncItem("data")
+	item := &managerItem{
+		item:   wi,
+		result: &Result{Result: "data"},
+	}
+
+	obj.receiveResult(item)
+
+	assert.Equal(t, &Result{Result: "data"}, cr.Wait())
+	runner.AssertExpectations(t)
+}
+
+func TestParallelManagerReceiveResultSpawned(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &parallelManager{
+		worker: &parallelWorker{
+			runner: runner,
+		},
+		count:   5,
+		waiting: 3,
+		queue:   &list.List{},
+	}
+	runner.On("Integrate", obj, &Result{Result: "data"})
+	child1 := &workItem{data: "child1", spawn: true}
+	child2 := &workItem{data: "child2", spawn: true}
+	item := &managerItem{
+		item:    &workItem{data: "data"},
+		result:  &Result{Result: "data"},
+		spawned: []*workItem{child1, child2},
+	}
+
+	obj.receiveResult(item)
+
+	assert.Equal(t, 2, obj.waiting)
+	require.Equal(t, 2, obj.queue.Len())
+	assert.Same(t, child1, obj.queue.Front().Value)
+	assert.Same(t, child2, obj.queue.Back().Value)
+	runner.AssertExpectations(t)
+}
+
 func TestParallelManagerReceiveResultDone(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &parallelManager{
@@ -356,16 +482,17 @@ func TestParallelManagerManagerSelectSendWork(t *testing.T) {
 	obj := &parallelManager{
 		exiting: true,
 		queue:   &list.List{},
-		work:    make(chan interface{}, 1),
+		work:    make(chan *workItem, 1),
 	}
-	obj.queue.PushBack("data")
+	item := &workItem{data: "data"}
+	obj.queue.PushBack(item)
 
 	result := obj.managerSelect()
 
 	assert.True(t, result)
 	work, ok := <-obj.work
 	require.True(t, ok)
-	assert.Equal(t, "data", work)
+	assert.Same(t, item, work)
 	assert.Equal(t, 1, obj.waiting)
 }
 
@@ -375,13 +502,13 @@ func TestParallelManagerManagerSelectRecvWork(t *testing.T) {
 		queue:   &list.List{},
 		submit:  make(chan *managerItem, 1),
 	}
-	obj.submit <- &managerItem{data: "data"}
+	obj.submit <- &managerItem{item: &workItem{data: "data"}}
 
 	result := obj.managerSelect()
 
 	assert.True(t, result)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Equal(t, &workItem{data: "data"}, obj.queue.Front().Value)
 }
 
 func TestParallelManagerManagerSelectRecvResults(t *testing.T) {
@@ -412,6 +539,96 @@ func TestParallelManagerManagerSelectDone(t *testing.T) {
 	assert.False(t, result)
 }
 
+// managerSelectReflect is the previous implementation of
+// parallelManager.managerSelect, built on reflect.Select, retained
+// as a baseline for the benchmarks.
+func managerSelectReflect(w *parallelManager) bool {
+	cases := []reflect.SelectCase{}
+	fns := []func(value reflect.Value){}
+
+	if w.queue.Len() > 0 {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectSend,
+			Chan: reflect.ValueOf(w.work),
+			Send: reflect.ValueOf(w.queue.Front().Value),
+		})
+		fns = append(fns, func(value reflect.Value) {
+			w.queue.Remove(w.queue.Front())
+			w.waiting++
+		})
+	}
+	if !w.exiting {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(w.submit),
+		})
+		fns = append(fns, func(value reflect.Value) {
+			w.receiveWork(value.Interface().(*managerItem))
+		})
+	}
+	if w.count > 0 {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(w.results),
+		})
+		fns = append(fns, func(value reflect.Value) {
+			w.receiveResult(value.Interface().(*managerItem))
+		})
+	}
+	if len(cases) <= 0 {
+		return false
+	}
+
+	chosen, value, _ := reflect.Select(cases)
+	fns[chosen](value)
+
+	return true
+}
+
+// benchmarkManagerSelect measures the cost of passing an item through
+// the manager: sending it to a worker, then integrating its result.
+func benchmarkManagerSelect(b *testing.B, sel func(w *parallelManager) bool) {
+	obj := &parallelManager{
+		worker:  &parallelWorker{runner: &countRunner{}},
+		exiting: true,
+		count:   1,
+		queue:   &list.List{},
+		work:    make(chan *workItem, 1),
+		results: make(chan *managerItem, 1),
+	}
+	item := &workItem{data: "data"}
+	result := &managerItem{item: item, result: &Result{Result: "data"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj.queue.PushBack(item)
+		sel(obj)
+		<-obj.work
+		obj.results <- result
+		sel(obj)
+	}
+}
+
+func BenchmarkParallelManagerManagerSelect(b *testing.B) {
+	benchmarkManagerSelect(b, (*parallelManager).managerSelect)
+}
+
+func BenchmarkParallelManagerManagerSelectReflect(b *testing.B) {
+	benchmarkManagerSelect(b, managerSelectReflect)
+}
+
+func BenchmarkParallelWorker(b *testing.B) {
+	obj := NewParallelWorker(&countRunner{}, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj.Call(i)
+	}
+	obj.Wait()
+}
+
 func TestParallelManagerManagerBase(t *testing.T) {
 	obj := &parallelManager{
 		queue:  &list.List{},
@@ -428,7 +645,7 @@ func TestParallelManagerManagerBase(t *testing.T) {
 }
 
 func TestParallelManagerManagerClosesWork(t *testing.T) {
-	work := make(chan interface{}, 1)
+	work := make(chan *workItem, 1)
 	obj := &parallelManager{
 		queue:  &list.List{},
 		submit: make(chan *managerItem, 1),
@@ -448,7 +665,7 @@ func TestParallelManagerManagerClosesWork(t *testing.T) {
 }
 
 func TestParallelManagerManagerStartsWorkers(t *testing.T) {
-	work := make(chan interface{}, 1)
+	work := make(chan *workItem, 1)
 	obj := &parallelManager{
 		worker:  &parallelWorker{},
 		queue:   &list.List{},
@@ -457,7 +674,7 @@ func TestParallelManagerManagerStartsWorkers(t *testing.T) {
 		work:    work,
 		done:    make(chan bool, 1),
 	}
-	obj.queue.PushBack("work")
+	obj.queue.PushBack(&workItem{data: "work"})
 	obj.submit <- &managerItem{done: true}
 
 	obj.manager()
@@ -466,7 +683,7 @@ func TestParallelManagerManagerStartsWorkers(t *testing.T) {
 	assert.Nil(t, obj.work)
 	data, ok := <-work
 	require.True(t, ok)
-	assert.Equal(t, "work", data)
+	assert.Equal(t, &workItem{data: "work"}, data)
 	_, ok = <-work
 	assert.False(t, ok)
 	done := <-obj.done
@@ -482,7 +699,23 @@ func TestParallelManagerCall(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Equal(t, &workItem{data: "data"}, obj.queue.Front().Value)
+}
+
+func TestParallelManagerCallAsync(t *testing.T) {
+	obj := &parallelManager{
+		queue: &list.List{},
+	}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, cr)
+	assert.Equal(t, 1, obj.queue.Len())
+	item := obj.queue.Front().Value.(*workItem)
+	assert.Equal(t, "data", item.data)
+	item.resolve(&Result{Result: "result"})
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
 }
 
 func TestParallelManagerWait(t *testing.T) {
@@ -498,17 +731,37 @@ func TestGoWorkerImplementsWorker(t *testing.T) {
 	assert.Implements(t, (*Worker)(nil), &goWorker{})
 }
 
+func TestGoWorkerImplementsAsyncWorker(t *testing.T) {
+	assert.Implements(t, (*AsyncWorker)(nil), &goWorker{})
+}
+
+func TestGoWorkerImplementsStatsWorker(t *testing.T) {
+	assert.Implements(t, (*StatsWorker)(nil), &goWorker{})
+}
+
+func TestGoWorkerImplementsIdleWorker(t *testing.T) {
+	assert.Implements(t, (*IdleWorker)(nil), &goWorker{})
+}
+
 func TestNewGoWorkerBase(t *testing.T) {
 	runner := &MockRunner{}
 
 	result := NewGoWorker(runner, 5)
 
+	obj, ok := result.(*goWorker)
+	require.True(t, ok)
+	assert.NotNil(t, obj.active)
+	assert.NotNil(t, obj.inInteg)
 	assert.Equal(t, &goWorker{
-		serial: &sync.Mutex{},
-		runner: runner,
-		gonner: &sync.Once{},
-		limit:  semaphore.NewWeighted(5),
-		wg:     &sync.WaitGroup{},
+		serial:  &sync.Mutex{},
+		runner:  runner,
+		gonner:  &sync.Once{},
+		limit:   semaphore.NewWeighted(5),
+		wg:      &sync.WaitGroup{},
+		active:  obj.active,
+		inInteg: obj.inInteg,
+		idle:    newIdleTracker(),
+		done:    newCompletion(),
 	}, result)
 }
 
@@ -517,14 +770,38 @@ func TestNewGoWorkerZeroWorkers(t *testing.T) {
 
 	result := NewGoWorker(runner, 0)
 
+	obj, ok := result.(*goWorker)
+	require.True(t, ok)
+	assert.NotNil(t, obj.active)
+	assert.NotNil(t, obj.inInteg)
 	assert.Equal(t, &goWorker{
-		serial: &sync.Mutex{},
-		runner: runner,
-		gonner: &sync.Once{},
-		wg:     &sync.WaitGroup{},
+		serial:  &sync.Mutex{},
+		runner:  runner,
+		gonner:  &sync.Once{},
+		wg:      &sync.WaitGroup{},
+		active:  obj.active,
+		inInteg: obj.inInteg,
+		idle:    newIdleTracker(),
+		done:    newCompletion(),
 	}, result)
 }
 
+func TestNewGoWorkerSpawningStreaming(t *testing.T) {
+	assert.PanicsWithValue(t, ErrSpawningStreaming, func() {
+		NewGoWorker(&spawnStreamRunner{}, 1)
+	})
+}
+
+func TestNewGoWorkerOptions(t *testing.T) {
+	runner := &MockRunner{}
+
+	result := NewGoWorker(runner, 0, WithDedup(identityKey))
+
+	obj, ok := result.(*goWorker)
+	require.True(t, ok)
+	assert.NotNil(t, obj.dedup)
+}
+
 func TestGoWorkerWorkBase(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &goWorker{
@@ -536,7 +813,7 @@ func TestGoWorkerWorkBase(t *testing.T) {
 	runner.On("Integrate", obj, &Result{Result: "result"})
 
 	obj.wg.Add(1)
-	obj.work("data")
+	obj.work(&workItem{data: "data"}, nil)
 
 	runner.AssertExpectations(t)
 }
@@ -553,9 +830,136 @@ func TestGoWorkerWorkWithLimit(t *testing.T) {
 	runner.On("Integrate", obj, &Result{Result: "result"})
 
 	obj.wg.Add(1)
-	obj.work("data")
+	obj.work(&workItem{data: "data"}, nil)
+
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerWorkReleases(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+		dedup:  newDeduper(&options{dedupKey: identityKey}),
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+	item := &workItem{data: "data"}
+	obj.dedup.claim(item)
+
+	obj.wg.Add(1)
+	obj.work(item, nil)
+
+	assert.NotContains(t, obj.dedup.inflight, "data")
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerWorkCacheHit(t *testing.T) {
+	runner := &MockRunner{}
+	cache := &MockCache{}
+	cache.On("Get", "data").Return("cached", true)
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		limit:  semaphore.NewWeighted(1),
+		wg:     &sync.WaitGroup{},
+		memo: &memoizer{
+			cache: cache,
+			key:   identityKey,
+		},
+	}
+	runner.On("Integrate", obj, &Result{Result: "cached", Cached: true})
+	require.True(t, obj.limit.TryAcquire(1)) // hits bypass the semaphore
+
+	obj.wg.Add(1)
+	obj.work(&workItem{data: "data"}, nil)
+
+	assert.Equal(t, Stats{CacheHits: 1}, obj.Stats())
+	runner.AssertExpectations(t)
+	cache.AssertExpectations(t)
+}
+
+func TestGoWorkerWorkCacheMiss(t *testing.T) {
+	runner := &MockRunner{}
+	cache := &MockCache{}
+	cache.On("Get", "data").Return(nil, false)
+	cache.On("Put", "data", "result")
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+		memo: &memoizer{
+			cache: cache,
+			key:   identityKey,
+		},
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+
+	obj.wg.Add(1)
+	obj.work(&workItem{data: "data"}, nil)
+
+	assert.Equal(t, Stats{}, obj.Stats())
+	runner.AssertExpectations(t)
+	cache.AssertExpectations(t)
+}
+
+func TestGoWorkerWorkCheckpoint(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+		ckpt:   newCheckpointer(&options{checkpointStore: &MockCheckpointStore{}}),
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+	item := &workItem{data: "data"}
+	obj.ckpt.add(item)
+
+	obj.wg.Add(1)
+	obj.work(item, nil)
+
+	assert.Len(t, obj.ckpt.pending, 0)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerWaitCheckpointError(t *testing.T) {
+	runner := &MockRunner{}
+	store := &MockCheckpointStore{}
+	store.On("Save", mock.Anything).Return(assert.AnError)
+	obj := &goWorker{
+		state:  pRunning,
+		serial: &sync.Mutex{},
+		runner: runner,
+		gonner: &sync.Once{},
+		wg:     &sync.WaitGroup{},
+		ckpt: newCheckpointer(&options{
+			checkpointStore: store,
+			checkpointCodec: JSONCodec{},
+		}),
+	}
+	runner.On("Result").Return("result")
+
+	result, err := obj.Wait()
 
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, "result", result)
 	runner.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestGoWorkerStats(t *testing.T) {
+	obj := &goWorker{
+		calls: 5,
+		dedup: &deduper{duplicates: 2},
+		memo:  &memoizer{hits: 3},
+	}
+
+	result := obj.Stats()
+
+	assert.Equal(t, Stats{Calls: 5, Duplicates: 2, CacheHits: 3}, result)
 }
 
 func TestGoWorkerGetResult(t *testing.T) {
@@ -610,6 +1014,84 @@ func TestGoWorkerCallRunning(t *testing.T) {
 	runner.AssertExpectations(t)
 }
 
+func TestGoWorkerCallAsyncBase(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		state:  pRunning,
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+
+	cr, err := obj.CallAsync("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, cr)
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+	obj.wg.Wait()
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerCallAsyncClosed(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		state:  pClosed,
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+	}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Nil(t, cr)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerCallDuplicate(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		state:  pRunning,
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+		dedup:  newDeduper(&options{dedupKey: identityKey}),
+	}
+	obj.dedup.claim(&workItem{data: "data"})
+
+	err := obj.Call("data")
+	obj.wg.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Calls: 1, Duplicates: 1}, obj.Stats())
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerDedup(t *testing.T) {
+	runner := &MockRunner{}
+	obj := NewGoWorker(runner, 2, WithDedup(identityKey), WithDedupMemory(0))
+	runner.On("Run", "root").Return([]string{"a", "b", "root"})
+	runner.On("Run", "a").Return([]string{"b", "root"})
+	runner.On("Run", "b").Return([]string{"a"})
+	runner.On("Integrate", obj, mock.Anything).Run(func(args mock.Arguments) {
+		for _, child := range args.Get(1).(*Result).Result.([]string) {
+			assert.NoError(t, obj.Call(child))
+		}
+	})
+	runner.On("Result").Return("result")
+
+	assert.NoError(t, obj.Call("root"))
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	runner.AssertNumberOfCalls(t, "Run", 3)
+	runner.AssertNumberOfCalls(t, "Integrate", 3)
+	assert.Equal(t, Stats{Calls: 7, Duplicates: 4}, obj.(StatsWorker).Stats())
+}
+
 func TestGoWorkerCallClosed(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &goWorker{
@@ -627,6 +1109,24 @@ func TestGoWorkerCallClosed(t *testing.T) {
 	runner.AssertExpectations(t)
 }
 
+func TestGoWorkerCallClosedSpawned(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		state:  pClosed,
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+
+	err := obj.call(&workItem{data: "data", spawn: true})
+	obj.wg.Wait()
+
+	assert.NoError(t, err)
+	runner.AssertExpectations(t)
+}
+
 func TestGoWorkerCallResult(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &goWorker{
@@ -713,3 +1213,474 @@ func TestGoWorkerWaitResult(t *testing.T) {
 	assert.Equal(t, pResult, obj.state)
 	runner.AssertExpectations(t)
 }
+
+func TestGoWorkerWaitRepanic(t *testing.T) {
+	err := &PanicError{Value: "boom"}
+	runner := &MockRunner{}
+	obj := &goWorker{
+		state:   pResult,
+		runner:  runner,
+		gonner:  &sync.Once{},
+		wg:      &sync.WaitGroup{},
+		result:  "result",
+		repanic: &repanicker{err: err},
+	}
+
+	assert.PanicsWithValue(t, err, func() { obj.Wait() })
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerRepanic(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewGoWorker(runner, 1, WithRepanic())
+	require.NoError(t, obj.Call("data"))
+
+	defer func() {
+		panicData := recover()
+		require.IsType(t, &PanicError{}, panicData)
+		err := panicData.(*PanicError)
+		assert.Equal(t, "boom", err.Value)
+		assert.Equal(t, "data", err.Data)
+		assert.Equal(t, "NewGoWorker", err.Source)
+		assert.Contains(t, string(err.Stack), "TestGoWorkerRepanic")
+		runner.AssertExpectations(t)
+	}()
+	obj.Wait()
+	assert.Fail(t, "Wait did not panic")
+}
+
+func TestGoWorkerCloseRejectsOutsideCalls(t *testing.T) {
+	gate := make(chan struct{})
+	integrating := make(chan struct{})
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", mock.Anything, &Result{Result: "result"}).Run(func(args mock.Arguments) {
+		close(integrating)
+		<-gate
+	})
+	runner.On("Result").Return("final")
+	obj := NewGoWorker(runner, 1).(ClosableWorker)
+	require.NoError(t, obj.Call("data"))
+	<-integrating
+
+	obj.Close()
+	err := obj.Call("other")
+	close(gate)
+
+	assert.Same(t, ErrClosed, err)
+	result, err := obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "final", result)
+	runner.AssertExpectations(t)
+}
+
+func TestParallelManagerWorkRunnerStream(t *testing.T) {
+	item := &workItem{data: 2}
+	work := make(chan *workItem, 1)
+	work <- item
+	close(work)
+	obj := &parallelManager{
+		worker: &parallelWorker{
+			runner: &pageRunner{},
+		},
+		results: make(chan *managerItem, 4),
+	}
+
+	obj.workRunner(work)
+
+	close(obj.results)
+	results := []*managerItem{}
+	for item := range obj.results {
+		results = append(results, item)
+	}
+	assert.Equal(t, []*managerItem{
+		{result: &Result{Result: page{item: 2, page: 0}, Partial: true}},
+		{result: &Result{Result: page{item: 2, page: 1}, Partial: true}},
+		{item: item, result: &Result{Result: page{item: 2, page: -1}}},
+		{done: true},
+	}, results)
+}
+
+func TestParallelManagerReceiveResultPartial(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &parallelManager{
+		worker: &parallelWorker{
+			runner: runner,
+		},
+		count:   5,
+		waiting: 3,
+		queue:   &list.List{},
+	}
+	runner.On("Integrate", obj, &Result{Result: "page", Partial: true})
+
+	obj.receiveResult(&managerItem{
+		result: &Result{Result: "page", Partial: true},
+	})
+
+	assert.Equal(t, 5, obj.count)
+	assert.Equal(t, 3, obj.waiting)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerRunStream(t *testing.T) {
+	runner := &pageRunner{next: map[int]int{}}
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+	}
+
+	result := obj.run(2, nil)
+
+	assert.Equal(t, &Result{Result: page{item: 2, page: -1}}, result)
+	assert.Equal(t, 2, runner.pages)
+	assert.Equal(t, 0, runner.bad)
+}
+
+func TestGoWorkerEmit(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+	}
+	runner.On("Integrate", obj, &Result{Result: "page", Partial: true})
+
+	obj.emit("page")
+
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerEmitIntegrator(t *testing.T) {
+	obj := &goWorker{
+		integ: &integrator{results: make(chan integration, 1)},
+	}
+
+	obj.emit("page")
+
+	assert.Equal(t, integration{
+		result: &Result{Result: "page", Partial: true},
+	}, <-obj.integ.results)
+}
+
+func TestGoWorkerIntegratePartial(t *testing.T) {
+	runner := &MockRunner{}
+	item := &workItem{data: "data", result: make(chan *Result, 1)}
+	ckpt := &checkpointer{pending: map[uint64]interface{}{}}
+	ckpt.add(item)
+	obj := &goWorker{
+		serial: &sync.Mutex{},
+		runner: runner,
+		wg:     &sync.WaitGroup{},
+		ckpt:   ckpt,
+	}
+	runner.On("Integrate", obj, &Result{Result: "page", Partial: true})
+
+	obj.integrate(item, &Result{Result: "page", Partial: true})
+
+	assert.Len(t, item.result, 0)
+	assert.Equal(t, map[uint64]interface{}{0: "data"}, ckpt.pending)
+	runner.AssertExpectations(t)
+}
+
+func TestParallelWorkerSpawn(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewParallelWorker(runner, 4)
+	require.NoError(t, obj.Call(6))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 127, result)
+}
+
+func TestGoWorkerSpawn(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewGoWorker(runner, 4)
+	require.NoError(t, obj.Call(6))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 127, result)
+}
+
+func TestGoWorkerSpawnPool(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewGoWorker(runner, 4, WithPool(0))
+	require.NoError(t, obj.Call(6))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 127, result)
+}
+
+func TestGoWorkerRunLocalFromSet(t *testing.T) {
+	runner := &localRunner{}
+	obj := &goWorker{
+		runner: runner,
+		locals: newLocalSet(runner),
+	}
+
+	result1 := obj.run(1, nil)
+	result2 := obj.run(2, nil)
+
+	assert.Equal(t, &Result{Result: 1}, result1)
+	assert.Equal(t, &Result{Result: 2}, result2)
+	require.Len(t, obj.locals.free, 1)
+	assert.True(t, obj.locals.free[0].ready)
+	assert.Equal(t, 1, runner.setups)
+}
+
+func TestGoWorkerRunLocalPassed(t *testing.T) {
+	runner := &localRunner{}
+	local := &workerLocal{runner: runner}
+	obj := &goWorker{
+		runner: runner,
+		locals: newLocalSet(runner),
+	}
+
+	result := obj.run(1, local)
+
+	assert.Equal(t, &Result{Result: 1}, result)
+	assert.True(t, local.ready)
+	assert.Len(t, obj.locals.free, 0)
+	assert.Equal(t, 1, runner.setups)
+}
+
+func TestGoWorkerLocalTeardown(t *testing.T) {
+	runner := &localRunner{}
+	obj := NewGoWorker(runner, 2)
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 55, result)
+	assert.Equal(t, int32(0), runner.shared)
+	assert.LessOrEqual(t, runner.setups, 2)
+	assert.Equal(t, runner.setups, runner.teardowns)
+	assert.Nil(t, obj.(*goWorker).locals.free)
+}
+
+func TestGoWorkerWaitIdleIdle(t *testing.T) {
+	obj := &goWorker{idle: newIdleTracker()}
+
+	err := obj.WaitIdle(context.Background())
+
+	assert.NoError(t, err)
+}
+
+func TestGoWorkerWaitIdleBusy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	obj := &goWorker{idle: newIdleTracker()}
+	obj.idle.add()
+
+	err := obj.WaitIdle(ctx)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestGoWorkerWaitIdleActive(t *testing.T) {
+	obj := &goWorker{
+		active: newGoroutineMarker(),
+		idle:   newIdleTracker(),
+	}
+	var err error
+
+	obj.active.run(func() {
+		err = obj.WaitIdle(context.Background())
+	})
+
+	assert.Same(t, ErrWouldDeadlock, err)
+}
+
+func TestGoWorkerIdle(t *testing.T) {
+	obj := &goWorker{idle: newIdleTracker()}
+	obj.idle.add()
+
+	result := obj.Idle()
+
+	assert.False(t, isClosed(result))
+	obj.idle.done()
+	assert.True(t, isClosed(result))
+}
+
+func TestGoWorkerFlushSpawned(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewGoWorker(runner, 4)
+	require.NoError(t, obj.Call(4))
+
+	err := obj.(IdleWorker).Flush()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 31, runner.count)
+	assert.NoError(t, obj.Call(3))
+	obj.Wait()
+}
+
+func TestGoWorkerWaitIdleCanceled(t *testing.T) {
+	runner := &flushRunner{gate: make(chan struct{})}
+	obj := NewGoWorker(runner, 1).(IdleWorker)
+	require.NoError(t, obj.Call("data"))
+	idle := obj.Idle()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := obj.WaitIdle(ctx)
+
+	assert.Same(t, context.Canceled, err)
+	assert.False(t, isClosed(idle))
+	close(runner.gate)
+	<-idle
+	obj.Wait()
+}
+
+func TestGoWorkerWaitIdleDeadlock(t *testing.T) {
+	runner := &flushRunner{}
+	obj := NewGoWorker(runner, 1)
+	require.NoError(t, obj.Call("data"))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, []error{ErrWouldDeadlock}, runner.errs)
+	assert.Equal(t, []bool{false}, runner.idles)
+}
+
+func TestGoWorkerImplementsClosableWorker(t *testing.T) {
+	assert.Implements(t, (*ClosableWorker)(nil), &goWorker{})
+}
+
+func TestGoWorkerCloseFromIntegrate(t *testing.T) {
+	runner := &closeRunner{closeAt: 3}
+	obj := NewGoWorker(runner, 2).(ClosableWorker)
+	require.NoError(t, obj.Call(3))
+
+	<-obj.Done()
+
+	assert.Equal(t, 6, runner.total)
+	assert.Equal(t, []error{nil, nil, nil}, runner.errs)
+	assert.Same(t, ErrClosed, obj.Call(1))
+}
+
+func TestGoWorkerCloseFromIntegrator(t *testing.T) {
+	runner := &closeRunner{closeAt: 3}
+	obj := NewGoWorker(runner, 2, WithIntegrator(1)).(ClosableWorker)
+	require.NoError(t, obj.Call(3))
+
+	<-obj.Done()
+
+	assert.Equal(t, 6, runner.total)
+	assert.Equal(t, []error{nil, nil, nil}, runner.errs)
+	assert.Same(t, ErrClosed, obj.Call(1))
+}
+
+func TestGoWorkerCloseTwice(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result").Once()
+	obj := NewGoWorker(runner, 2).(ClosableWorker)
+	obj.Close()
+
+	obj.Close()
+
+	<-obj.Done()
+	assert.Equal(t, pResult, obj.(*goWorker).state)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerCloseNew(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	obj := NewGoWorker(runner, 2).(ClosableWorker)
+
+	obj.Close()
+
+	<-obj.Done()
+	result, err := obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerCloseRepanic(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewGoWorker(runner, 2, WithRepanic()).(ClosableWorker)
+	got := make(chan error, 1)
+	obj.OnComplete(func(result interface{}, err error) {
+		got <- err
+	})
+	require.NoError(t, obj.Call("data"))
+	require.NoError(t, obj.(IdleWorker).Flush())
+
+	obj.Close()
+
+	err := <-got
+	require.IsType(t, &PanicError{}, err)
+	assert.Equal(t, "boom", err.(*PanicError).Value)
+	assert.Panics(t, func() { obj.Wait() })
+}
+
+func TestGoWorkerName(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewGoWorker(runner, 1, WithName("fetch")).(AsyncWorker)
+	cr, err := obj.CallAsync("data")
+	require.NoError(t, err)
+
+	result := cr.Wait()
+
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "NewGoWorker", result.PanicError.Source)
+	assert.Equal(t, "fetch", result.PanicError.Name)
+	obj.Wait()
+	runner.AssertExpectations(t)
+}
+
+func TestGoWorkerCallAsyncWaitContextCanceled(t *testing.T) {
+	gate := make(chan struct{})
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result").Run(func(args mock.Arguments) {
+		<-gate
+	})
+	runner.On("Integrate", mock.Anything, &Result{Result: "result"})
+	runner.On("Result").Return("done")
+	obj := NewGoWorker(runner, 1).(AsyncWorker)
+	cr, err := obj.CallAsync("data")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := cr.(ContextCallResult).WaitContext(ctx)
+
+	assert.Nil(t, result)
+	assert.Same(t, context.Canceled, err)
+	close(gate)
+	obj.Wait()
+	runner.AssertExpectations(t)
+}
+
+func TestNewGoWorkerUnsupportedOption(t *testing.T) {
+	assert.PanicsWithError(t, "Option not supported: NewGoWorker does not support WithHeartbeat", func() {
+		NewGoWorker(&MockRunner{}, 2, WithHeartbeat(0, 0))
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// workerPool implements the WithPool option.  It maintains a pool of
+// long-lived goroutines pulling work items from a queue.  Goroutines
+// are started lazily, up to the pool size, when an item is submitted
+// and more items are queued than goroutines are idle, and exit once
+// they have been idle for the idle timeout.  If the runner implements
+// LocalRunner, each goroutine has its own worker-local state, torn
+// down when the goroutine exits.
+type workerPool struct {
+	sync.Mutex
+	size    int                                      // Maximum number of goroutines
+	idle    time.Duration                            // How long a goroutine waits for work
+	runner  Runner                                   // The runner, for worker-local state
+	fn      func(item *workItem, local *workerLocal) // The function to work an item
+	queue   *list.List                               // Items waiting for a goroutine
+	running int                                      // Number of goroutines running
+	waiting int                                      // Number of goroutines idle
+	closed  bool                                     // Set once the pool is closed
+	wake    chan struct{}                            // Signals idle goroutines
+	wg      *sync.WaitGroup                          // Wait group for the goroutines
+}
+
+// newWorkerPool constructs a workerPool with the specified size that
+// calls the function to work each item, passing it the goroutine's
+// worker-local state for the runner.
+func newWorkerPool(size int, idle time.Duration, runner Runner, fn func(item *workItem, local *workerLocal)) *workerPool {
+	return &workerPool{
+		size:   size,
+		idle:   idle,
+		runner: runner,
+		fn:     fn,
+		queue:  &list.List{},
+		wake:   make(chan struct{}, size),
+		wg:     &sync.WaitGroup{},
+	}
+}
+
+// submit adds a work item to the queue, waking an idle goroutine and
+// starting a new one as needed.
+func (p *workerPool) submit(item *workItem) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.queue.PushBack(item)
+
+	// Wake an idle goroutine
+	if p.waiting > 0 {
+		select {
+		case p.wake <- struct{}{}:
+		default: // Enough goroutines are already being woken
+		}
+	}
+
+	// Start a new goroutine if there are more items queued than
+	// idle goroutines to take them; a woken goroutine counts as
+	// idle until it runs, so a burst of items would otherwise all
+	// be left to the goroutines that were idle
+	if p.queue.Len() > p.waiting && p.running < p.size {
+		p.running++
+		p.wg.Add(1)
+		go p.loop()
+	}
+}
+
+// next retrieves the next item from the queue.  If there is none, and
+// the goroutine should exit, it returns false.
+func (p *workerPool) next() (*workItem, bool) {
+	for {
+		p.Lock()
+		if elem := p.queue.Front(); elem != nil {
+			p.queue.Remove(elem)
+			p.Unlock()
+			return elem.Value.(*workItem), true
+		}
+
+		// Exit if closed or if idle goroutines aren't kept
+		if p.closed || p.idle <= 0 {
+			p.running--
+			p.Unlock()
+			return nil, false
+		}
+		p.waiting++
+		p.Unlock()
+
+		// Wait for work or for the idle timeout
+		timer := time.NewTimer(p.idle)
+		select {
+		case <-p.wake:
+			timer.Stop()
+			p.Lock()
+			p.waiting--
+			p.Unlock()
+
+		case <-timer.C:
+			p.Lock()
+			p.waiting--
+			if p.queue.Len() <= 0 {
+				p.running--
+				p.Unlock()
+				return nil, false
+			}
+			p.Unlock()
+		}
+	}
+}
+
+// loop is the main loop of a pool goroutine.
+func (p *workerPool) loop() {
+	defer p.wg.Done()
+
+	// Set up the worker-local state lazily, tearing it down on exit
+	local := newWorkerLocal(p.runner)
+	defer local.close()
+
+	for {
+		item, ok := p.next()
+		if !ok {
+			return
+		}
+
+		p.fn(item, local)
+	}
+}
+
+// close causes the idle goroutines to exit, and waits for all the
+// goroutines to exit.  It must only be called once the queue is
+// empty and no more items will be submitted.
+func (p *workerPool) close() {
+	p.Lock()
+	p.closed = true
+	close(p.wake)
+	p.Unlock()
+
+	p.wg.Wait()
+}
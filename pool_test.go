@@ -0,0 +1,262 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// poolState returns the number of running and waiting goroutines in
+// a pool.
+func poolState(p *workerPool) (int, int) {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.running, p.waiting
+}
+
+func TestNewWorkerPool(t *testing.T) {
+	result := newWorkerPool(3, time.Second, nil, nil)
+
+	assert.Equal(t, 3, result.size)
+	assert.Equal(t, time.Second, result.idle)
+	assert.Equal(t, &list.List{}, result.queue)
+	assert.Equal(t, 3, cap(result.wake))
+	assert.NotNil(t, result.wg)
+}
+
+func TestWorkerPoolSubmitStarts(t *testing.T) {
+	items := make(chan *workItem, 1)
+	obj := newWorkerPool(2, 0, nil, func(item *workItem, local *workerLocal) { items <- item })
+	item := &workItem{data: "data"}
+
+	obj.submit(item)
+
+	assert.Same(t, item, <-items)
+	obj.wg.Wait()
+	running, waiting := poolState(obj)
+	assert.Equal(t, 0, running)
+	assert.Equal(t, 0, waiting)
+}
+
+func TestWorkerPoolSubmitLimit(t *testing.T) {
+	gate := make(chan struct{})
+	obj := newWorkerPool(2, 0, nil, func(item *workItem, local *workerLocal) { <-gate })
+
+	for i := 0; i < 5; i++ {
+		obj.submit(&workItem{data: i})
+	}
+
+	running, _ := poolState(obj)
+	assert.Equal(t, 2, running)
+	close(gate)
+	obj.wg.Wait()
+	assert.Equal(t, 0, obj.queue.Len())
+}
+
+func TestWorkerPoolSubmitWakesIdle(t *testing.T) {
+	items := make(chan *workItem, 2)
+	obj := newWorkerPool(2, time.Hour, nil, func(item *workItem, local *workerLocal) { items <- item })
+	obj.submit(&workItem{data: 1})
+	<-items
+	require.Eventually(t, func() bool {
+		_, waiting := poolState(obj)
+		return waiting == 1
+	}, time.Second, time.Millisecond)
+
+	obj.submit(&workItem{data: 2})
+
+	assert.Equal(t, &workItem{data: 2}, <-items)
+	running, _ := poolState(obj)
+	assert.Equal(t, 1, running)
+	obj.close()
+}
+
+func TestWorkerPoolSubmitBurstAfterIdle(t *testing.T) {
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	current, peak := 0, 0
+	obj := newWorkerPool(4, time.Hour, nil, func(item *workItem, local *workerLocal) {
+		mu.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		mu.Unlock()
+		if item.data != 0 {
+			<-gate
+		}
+		mu.Lock()
+		current--
+		mu.Unlock()
+	})
+	obj.submit(&workItem{data: 0})
+	require.Eventually(t, func() bool {
+		_, waiting := poolState(obj)
+		return waiting == 1
+	}, time.Second, time.Millisecond)
+
+	for i := 1; i <= 8; i++ {
+		obj.submit(&workItem{data: i})
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return current == 4
+	}, time.Second, time.Millisecond)
+	running, _ := poolState(obj)
+	assert.Equal(t, 4, running)
+	close(gate)
+	require.Eventually(t, func() bool {
+		obj.Lock()
+		defer obj.Unlock()
+		return obj.queue.Len() == 0 && obj.waiting == obj.running
+	}, time.Second, time.Millisecond)
+	obj.close()
+	assert.Equal(t, 4, peak)
+}
+
+func TestWorkerPoolIdleTimeout(t *testing.T) {
+	obj := newWorkerPool(2, time.Millisecond, nil, func(item *workItem, local *workerLocal) {})
+
+	obj.submit(&workItem{data: "data"})
+
+	obj.wg.Wait()
+	running, waiting := poolState(obj)
+	assert.Equal(t, 0, running)
+	assert.Equal(t, 0, waiting)
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	obj := newWorkerPool(2, time.Hour, nil, func(item *workItem, local *workerLocal) {})
+	obj.submit(&workItem{data: "data"})
+	require.Eventually(t, func() bool {
+		_, waiting := poolState(obj)
+		return waiting == 1
+	}, time.Second, time.Millisecond)
+
+	obj.close()
+
+	running, waiting := poolState(obj)
+	assert.Equal(t, 0, running)
+	assert.Equal(t, 0, waiting)
+	assert.True(t, obj.closed)
+}
+
+func TestWorkerPoolLocal(t *testing.T) {
+	runner := &localRunner{}
+	locals := make(chan *workerLocal, 2)
+	obj := newWorkerPool(1, time.Hour, runner, func(item *workItem, local *workerLocal) {
+		local.run("test", item.data)
+		locals <- local
+	})
+	obj.submit(&workItem{data: 1})
+	obj.submit(&workItem{data: 2})
+	local1 := <-locals
+	local2 := <-locals
+
+	obj.close()
+
+	assert.Same(t, local1, local2)
+	assert.False(t, local1.ready)
+	assert.Equal(t, 1, runner.setups)
+	assert.Equal(t, 1, runner.teardowns)
+}
+
+func TestNewGoWorkerPool(t *testing.T) {
+	result := NewGoWorker(&MockRunner{}, 3, WithPool(time.Second))
+
+	obj := result.(*goWorker)
+	assert.Nil(t, obj.limit)
+	require.NotNil(t, obj.pool)
+	assert.Equal(t, 3, obj.pool.size)
+	assert.Equal(t, time.Second, obj.pool.idle)
+}
+
+func TestNewGoWorkerPoolNumCPU(t *testing.T) {
+	result := NewGoWorker(&MockRunner{}, 0, WithPool(time.Second))
+
+	obj := result.(*goWorker)
+	assert.Equal(t, runtime.NumCPU(), obj.pool.size)
+}
+
+// poolRunner is a Runner that records the goroutines that call Run.
+type poolRunner struct {
+	sync.Mutex
+	ids   map[uint64]bool
+	count int
+}
+
+func (r *poolRunner) Run(data interface{}) interface{} {
+	r.Lock()
+	defer r.Unlock()
+
+	r.ids[goid()] = true
+	return data
+}
+
+func (r *poolRunner) Integrate(worker Worker, result *Result) {
+	r.count++
+	if n := result.Result.(int); n < 100 {
+		worker.Call(n + 100)
+	}
+}
+
+func (r *poolRunner) Result() interface{} {
+	return r.count
+}
+
+func TestGoWorkerPool(t *testing.T) {
+	runner := &poolRunner{ids: map[uint64]bool{}}
+	obj := NewGoWorker(runner, 4, WithPool(time.Hour))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, result)
+	assert.LessOrEqual(t, len(runner.ids), 4)
+	running, _ := poolState(obj.(*goWorker).pool)
+	assert.Equal(t, 0, running)
+}
+
+func benchmarkGoWorker(b *testing.B, opts ...Option) {
+	obj := NewGoWorker(&countRunner{}, 4, opts...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj.Call(i)
+	}
+	obj.Wait()
+}
+
+func BenchmarkGoWorker(b *testing.B) {
+	benchmarkGoWorker(b)
+}
+
+func BenchmarkGoWorkerPool(b *testing.B) {
+	benchmarkGoWorker(b, WithPool(time.Second))
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// processChildEnv is the environment variable set in child processes
+// started by a process worker.
+const processChildEnv = "PARALLELIZER_PROCESS_CHILD"
+
+// Response status codes sent by a child process.
+const (
+	processResult byte = iota // Run returned a result
+	processPanic              // Run panicked
+)
+
+// ProcessCrashError is the panic value reported in Result.Panic when
+// a child process of a process worker exits or breaks the protocol
+// while processing a data item.
+type ProcessCrashError struct {
+	Err error // The error describing the crash
+}
+
+// Error returns the error message.
+func (e *ProcessCrashError) Error() string {
+	return fmt.Sprintf("Child process crashed: %s", e.Err)
+}
+
+// Unwrap returns the error describing the crash.
+func (e *ProcessCrashError) Unwrap() error {
+	return e.Err
+}
+
+// IsProcessChild returns true if the current process was started as a
+// child process by a process worker.  A program using
+// NewProcessWorker should check this early in main, and if it returns
+// true, call ServeProcess instead of proceeding normally.
+func IsProcessChild() bool {
+	return os.Getenv(processChildEnv) != ""
+}
+
+// ServeProcess implements the child side of a process worker.  It
+// reads data items from standard input, passes them to Runner.Run,
+// and writes the results to standard output, until standard input is
+// closed.  Only the Run method of the Runner is called.  The Codec
+// must match the one passed to NewProcessWorker.  Since standard
+// output carries the results, os.Stdout is redirected to standard
+// error while serving; output written directly to file descriptor 1,
+// such as by C code, will corrupt the protocol and be reported as a
+// crash.
+func ServeProcess(runner Runner, codec Codec) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	return serveProcess(os.Stdin, out, runner, codec)
+}
+
+// serveProcess is the implementation of ServeProcess.
+func serveProcess(r io.Reader, w io.Writer, runner Runner, codec Codec) error {
+	br := bufio.NewReader(r)
+	for {
+		req, err := readFrame(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := writeFrame(w, processRespond(req, runner, codec)); err != nil {
+			return err
+		}
+	}
+}
+
+// processRespond is a helper for serveProcess that computes the
+// response to a request.  Panics are reported as strings, since
+// arbitrary panic values cannot be encoded.
+func processRespond(req []byte, runner Runner, codec Codec) []byte {
+	data, err := codec.Decode(req)
+	if err != nil {
+		return append([]byte{processPanic}, err.Error()...)
+	}
+
+	result := panicer("ServeProcess", runner.Run, data)
+	if result.Panic != nil {
+		return append([]byte{processPanic}, fmt.Sprint(result.Panic)...)
+	}
+
+	payload, err := codec.Encode(result.Result)
+	if err != nil {
+		return append([]byte{processPanic}, err.Error()...)
+	}
+
+	return append([]byte{processResult}, payload...)
+}
+
+// processChild describes a child process of a process worker.  A
+// processChild is used by only one goroutine at a time.
+type processChild struct {
+	cmd    *exec.Cmd      // The running child, or nil
+	stdin  io.WriteCloser // Pipe to the child's standard input
+	stdout *bufio.Reader  // Pipe from the child's standard output
+}
+
+// start starts the child process.  If the command is empty, the
+// current executable is run with the current arguments.
+func (c *processChild) start(command []string) error {
+	if len(command) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		command = append([]string{exe}, os.Args[1:]...)
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), processChildEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	c.cmd = cmd
+	c.stdin = stdin
+	c.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+// kill kills the child process and waits for it to exit.  It returns
+// the error describing how the child exited, or the fallback error if
+// the child exited successfully.
+func (c *processChild) kill(fallback error) error {
+	c.cmd.Process.Kill()
+	err := c.cmd.Wait()
+	c.cmd = nil
+	if err == nil {
+		err = fallback
+	}
+
+	return err
+}
+
+// stop closes the child's standard input, causing it to exit, and
+// waits for it to do so.
+func (c *processChild) stop() {
+	if c.cmd != nil {
+		c.stdin.Close()
+		c.cmd.Wait()
+		c.cmd = nil
+	}
+}
+
+// call sends a data item to the child process, starting it if
+// necessary, and returns the result or the panic value reported by
+// the child.  If the child could not be started or crashed, an error
+// is returned; the child will be restarted by the next call.
+func (c *processChild) call(command []string, codec Codec, data interface{}) (interface{}, interface{}, error) {
+	// Encode the request
+	req, err := codec.Encode(data)
+	if err != nil {
+		return nil, err, nil
+	}
+
+	// Start the child, if needed
+	if c.cmd == nil {
+		if err := c.start(command); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Exchange the request and the response
+	if err := writeFrame(c.stdin, req); err != nil {
+		return nil, nil, c.kill(err)
+	}
+	resp, err := readFrame(c.stdout)
+	if err != nil {
+		return nil, nil, c.kill(err)
+	}
+
+	// Interpret the response
+	result, panicVal, err := processResponse(resp, codec)
+	if err != nil {
+		return nil, nil, c.kill(err)
+	}
+
+	return result, panicVal, nil
+}
+
+// processResponse interprets a response computed by processRespond,
+// returning the result or the panic value.  An error is returned if
+// the response is invalid.
+func processResponse(resp []byte, codec Codec) (interface{}, interface{}, error) {
+	if len(resp) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	switch resp[0] {
+	case processResult:
+		result, err := codec.Decode(resp[1:])
+		if err != nil {
+			return nil, err, nil
+		}
+		return result, nil, nil
+
+	case processPanic:
+		return nil, string(resp[1:]), nil
+	}
+
+	return nil, nil, fmt.Errorf("invalid response status %d", resp[0])
+}
+
+// processRunner is a Runner that wraps the application's Runner,
+// passing data items to child processes to be run.
+type processRunner struct {
+	Runner                     // The application's Runner
+	codec   Codec              // Codec for data items and results
+	command []string           // Command to run; empty for the current binary
+	pool    chan *processChild // Idle child processes
+}
+
+// Run passes the data to an idle child process and returns the
+// result.  If the child panicked or crashed, Run panics, so that the
+// panic is reported in Result.Panic.
+func (r *processRunner) Run(data interface{}) interface{} {
+	child := <-r.pool
+	defer func() { r.pool <- child }()
+
+	result, panicVal, err := child.call(r.command, r.codec, data)
+	if err != nil {
+		panic(&ProcessCrashError{Err: err})
+	} else if panicVal != nil {
+		panic(panicVal)
+	}
+
+	return result
+}
+
+// Result stops the child processes, then returns the final result
+// from the application's Runner.
+func (r *processRunner) Result() interface{} {
+	for i := 0; i < cap(r.pool); i++ {
+		child := <-r.pool
+		child.stop()
+	}
+
+	return r.Runner.Result()
+}
+
+// NewProcessWorker constructs a worker that calls Runner.Run in child
+// processes, for use with Runners that are not safe to run in
+// goroutines, such as those using C libraries that are not
+// thread-safe.  Up to the specified number of child processes are
+// started as needed; if that number is less than or equal to 0, the
+// number of CPUs is used.  By default, the children run the current
+// executable with the current arguments, which must call ServeProcess
+// when IsProcessChild returns true; the WithProcessCommand option may
+// be used to run a different command.  Data items and results are
+// exchanged with the children over their standard input and output
+// using the Codec.  If Runner.Run panics in a child, Result.Panic is
+// set to the string representation of the panic value; if a child
+// crashes, Result.Panic is set to a *ProcessCrashError, and the child
+// is restarted for the next data item.  Runner.Integrate and
+// Runner.Result are called in the current process.  The returned
+// Worker is otherwise like one returned by NewGoWorker, and accepts
+// the same options, as well as WithProcessCommand.
+func NewProcessWorker(runner Runner, workers int, codec Codec, opts ...Option) Worker {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	o := mustOptions("NewProcessWorker", goOptions|optProcessCommand, opts)
+
+	// Set up the pool of children
+	pool := make(chan *processChild, workers)
+	for i := 0; i < workers; i++ {
+		pool <- &processChild{}
+	}
+
+	return newGoWorker(&processRunner{
+		Runner:  runner,
+		codec:   codec,
+		command: o.processCommand,
+		pool:    pool,
+	}, workers, o)
+}
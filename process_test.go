@@ -0,0 +1,377 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain allows the test binary to serve as the child process for
+// the process worker tests, and as an agent for the coordinator
+// tests.
+func TestMain(m *testing.M) {
+	if IsProcessChild() {
+		if err := ServeProcess(processTestRunner{}, JSONCodec{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if addr := os.Getenv(remoteTestAgentEnv); addr != "" {
+		if err := DialAgent("tcp", addr, processTestRunner{}, JSONCodec{}, 2); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// processTestRunner is the Runner used by the child processes in the
+// process worker tests.
+type processTestRunner struct{}
+
+func (r processTestRunner) Run(data interface{}) interface{} {
+	switch data {
+	case "panic":
+		panic("boom")
+
+	case "crash":
+		os.Exit(3)
+
+	case "print":
+		fmt.Println("noise")
+		return "printed"
+
+	case "pid":
+		return float64(os.Getpid())
+	}
+
+	return data.(float64) * 2
+}
+
+func (r processTestRunner) Integrate(worker Worker, result *Result) {}
+
+func (r processTestRunner) Result() interface{} {
+	return nil
+}
+
+// countRunner is a Runner that counts the results integrated.
+type countRunner struct {
+	count int
+}
+
+func (r *countRunner) Run(data interface{}) interface{} {
+	return data
+}
+
+func (r *countRunner) Integrate(worker Worker, result *Result) {
+	r.count++
+}
+
+func (r *countRunner) Result() interface{} {
+	return r.count
+}
+
+func TestProcessCrashErrorImplementsError(t *testing.T) {
+	assert.Implements(t, (*error)(nil), &ProcessCrashError{})
+}
+
+func TestProcessCrashErrorError(t *testing.T) {
+	obj := &ProcessCrashError{Err: assert.AnError}
+
+	result := obj.Error()
+
+	assert.Equal(t, "Child process crashed: "+assert.AnError.Error(), result)
+}
+
+func TestProcessCrashErrorUnwrap(t *testing.T) {
+	obj := &ProcessCrashError{Err: assert.AnError}
+
+	result := obj.Unwrap()
+
+	assert.Same(t, assert.AnError, result)
+}
+
+func TestIsProcessChild(t *testing.T) {
+	assert.False(t, IsProcessChild())
+	os.Setenv(processChildEnv, "1")
+	defer os.Unsetenv(processChildEnv)
+	assert.True(t, IsProcessChild())
+}
+
+func TestProcessRespondResult(t *testing.T) {
+	result := processRespond([]byte("2"), processTestRunner{}, JSONCodec{})
+
+	assert.Equal(t, append([]byte{processResult}, "4"...), result)
+}
+
+func TestProcessRespondPanic(t *testing.T) {
+	result := processRespond([]byte(`"panic"`), processTestRunner{}, JSONCodec{})
+
+	assert.Equal(t, append([]byte{processPanic}, "boom"...), result)
+}
+
+func TestProcessRespondDecodeError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Decode", []byte("bad")).Return(nil, assert.AnError)
+
+	result := processRespond([]byte("bad"), processTestRunner{}, codec)
+
+	assert.Equal(t, append([]byte{processPanic}, assert.AnError.Error()...), result)
+	codec.AssertExpectations(t)
+}
+
+func TestProcessRespondEncodeError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Decode", []byte("2")).Return(2.0, nil)
+	codec.On("Encode", 4.0).Return(nil, assert.AnError)
+
+	result := processRespond([]byte("2"), processTestRunner{}, codec)
+
+	assert.Equal(t, append([]byte{processPanic}, assert.AnError.Error()...), result)
+	codec.AssertExpectations(t)
+}
+
+func TestServeProcessBase(t *testing.T) {
+	in := &bytes.Buffer{}
+	require.NoError(t, writeFrame(in, []byte("1")))
+	require.NoError(t, writeFrame(in, []byte("2")))
+	out := &bytes.Buffer{}
+
+	err := serveProcess(in, out, processTestRunner{}, JSONCodec{})
+
+	assert.NoError(t, err)
+	resp1, err := readFrame(out)
+	require.NoError(t, err)
+	resp2, err := readFrame(out)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{processResult}, "2"...), resp1)
+	assert.Equal(t, append([]byte{processResult}, "4"...), resp2)
+	assert.Equal(t, 0, out.Len())
+}
+
+func TestServeProcessReadError(t *testing.T) {
+	in := bytes.NewReader([]byte{0, 0, 0, 4})
+
+	err := serveProcess(in, &bytes.Buffer{}, processTestRunner{}, JSONCodec{})
+
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+// failWriter is an io.Writer that always fails.
+type failWriter struct{}
+
+func (w failWriter) Write(p []byte) (int, error) {
+	return 0, assert.AnError
+}
+
+func TestServeProcessWriteError(t *testing.T) {
+	in := &bytes.Buffer{}
+	require.NoError(t, writeFrame(in, []byte("1")))
+
+	err := serveProcess(in, failWriter{}, processTestRunner{}, JSONCodec{})
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestProcessChildCall(t *testing.T) {
+	obj := &processChild{}
+	defer obj.stop()
+
+	result1, panic1, err1 := obj.call(nil, JSONCodec{}, 2.0)
+	result2, panic2, err2 := obj.call(nil, JSONCodec{}, "panic")
+	result3, panic3, err3 := obj.call(nil, JSONCodec{}, "print")
+
+	assert.NoError(t, err1)
+	assert.Nil(t, panic1)
+	assert.Equal(t, 4.0, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, "boom", panic2)
+	assert.Nil(t, result2)
+	assert.NoError(t, err3)
+	assert.Nil(t, panic3)
+	assert.Equal(t, "printed", result3)
+}
+
+func TestProcessChildCallCrash(t *testing.T) {
+	obj := &processChild{}
+	defer obj.stop()
+
+	pid1, _, err1 := obj.call(nil, JSONCodec{}, "pid")
+	_, _, err2 := obj.call(nil, JSONCodec{}, "crash")
+	pid2, _, err3 := obj.call(nil, JSONCodec{}, "pid")
+
+	assert.NoError(t, err1)
+	exitErr := &exec.ExitError{}
+	require.True(t, errors.As(err2, &exitErr))
+	assert.Equal(t, 3, exitErr.ExitCode())
+	assert.NoError(t, err3)
+	assert.NotEqual(t, pid1, pid2)
+}
+
+func TestProcessChildCallEncodeError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", "data").Return(nil, assert.AnError)
+	obj := &processChild{}
+
+	result, panicVal, err := obj.call(nil, codec, "data")
+
+	assert.NoError(t, err)
+	assert.Same(t, assert.AnError, panicVal)
+	assert.Nil(t, result)
+	assert.Nil(t, obj.cmd)
+	codec.AssertExpectations(t)
+}
+
+func TestProcessChildCallStartError(t *testing.T) {
+	obj := &processChild{}
+
+	_, _, err := obj.call([]string{"/nonexistent/command"}, JSONCodec{}, "data")
+
+	assert.Error(t, err)
+	assert.Nil(t, obj.cmd)
+}
+
+func TestProcessChildCallBadProtocol(t *testing.T) {
+	obj := &processChild{}
+
+	_, _, err := obj.call([]string{"echo", "garbage"}, JSONCodec{}, "data")
+
+	assert.Error(t, err)
+	assert.Nil(t, obj.cmd)
+}
+
+func TestProcessRunnerRun(t *testing.T) {
+	obj := &processRunner{
+		codec: JSONCodec{},
+		pool:  make(chan *processChild, 1),
+	}
+	child := &processChild{}
+	obj.pool <- child
+	defer child.stop()
+
+	result1 := panicer("test", obj.Run, 2.0)
+	result2 := panicer("test", obj.Run, "panic")
+	result3 := panicer("test", obj.Run, "crash")
+
+	assert.Equal(t, &Result{Result: 4.0}, result1)
+	assert.Equal(t, "boom", result2.Panic)
+	assert.IsType(t, &ProcessCrashError{}, result3.Panic)
+}
+
+func TestProcessRunnerResult(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	obj := &processRunner{
+		Runner: runner,
+		codec:  JSONCodec{},
+		pool:   make(chan *processChild, 2),
+	}
+	child := &processChild{}
+	require.NoError(t, child.start(nil))
+	obj.pool <- child
+	obj.pool <- &processChild{}
+
+	result := obj.Result()
+
+	assert.Equal(t, "result", result)
+	assert.Nil(t, child.cmd)
+	assert.Len(t, obj.pool, 0)
+	runner.AssertExpectations(t)
+}
+
+func TestNewProcessWorkerBase(t *testing.T) {
+	runner := &MockRunner{}
+
+	result := NewProcessWorker(runner, 2, JSONCodec{}, WithProcessCommand("cmd", "arg"))
+
+	require.IsType(t, &goWorker{}, result)
+	w := result.(*goWorker)
+	require.IsType(t, &processRunner{}, w.runner)
+	pr := w.runner.(*processRunner)
+	assert.Same(t, runner, pr.Runner)
+	assert.Equal(t, JSONCodec{}, pr.codec)
+	assert.Equal(t, []string{"cmd", "arg"}, pr.command)
+	assert.Len(t, pr.pool, 2)
+}
+
+func TestNewProcessWorkerDefaultWorkers(t *testing.T) {
+	result := NewProcessWorker(&MockRunner{}, 0, JSONCodec{})
+
+	pr := result.(*goWorker).runner.(*processRunner)
+	assert.NotEqual(t, 0, cap(pr.pool))
+	assert.Nil(t, pr.command)
+}
+
+func TestProcessWorker(t *testing.T) {
+	runner := &countRunner{}
+	obj := NewProcessWorker(runner, 2, JSONCodec{})
+	results := []CallResult{}
+	for _, data := range []interface{}{1.0, "panic", "crash", 2.0, 3.0} {
+		cr, err := obj.(AsyncWorker).CallAsync(data)
+		require.NoError(t, err)
+		results = append(results, cr)
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	expected := []*Result{
+		{Result: 2.0},
+		nil,
+		nil,
+		{Result: 4.0},
+		{Result: 6.0},
+	}
+	for i, cr := range results {
+		r := cr.Wait()
+		switch i {
+		case 1:
+			assert.Equal(t, "boom", r.Panic)
+		case 2:
+			assert.IsType(t, &ProcessCrashError{}, r.Panic)
+		default:
+			assert.Equal(t, expected[i], r)
+		}
+	}
+}
+
+func TestProcessWorkerIntegrateCalls(t *testing.T) {
+	runner := &MockRunner{}
+	obj := NewProcessWorker(runner, 1, JSONCodec{})
+	runner.On("Integrate", obj, &Result{Result: 2.0}).Run(func(args mock.Arguments) {
+		args[0].(Worker).Call(5.0)
+	})
+	runner.On("Integrate", obj, &Result{Result: 10.0})
+	runner.On("Result").Return("result")
+	require.NoError(t, obj.Call(1.0))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	runner.AssertExpectations(t)
+}
@@ -0,0 +1,468 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default heartbeat interval and lease duration.
+const (
+	defaultHeartbeatInterval = time.Second
+	defaultLeaseDuration     = 5 * time.Second
+)
+
+// Message types exchanged between a coordinator and its agents.
+const (
+	remoteHello     byte = iota + 1 // Agent announces its slots
+	remoteWelcome                   // Coordinator sends its timing
+	remoteWork                      // Coordinator sends a data item
+	remoteResult                    // Agent sends a result
+	remoteHeartbeat                 // Either side is alive
+	remoteGoodbye                   // Coordinator is shutting down
+)
+
+// ErrProtocol is returned by ServeAgent if the coordinator sends a
+// message that violates the protocol.
+var ErrProtocol = errors.New("Remote protocol violation")
+
+// remoteCall describes a data item being worked by an agent.
+type remoteCall struct {
+	id      uint64      // Identifier of the call
+	payload []byte      // Encoded data item
+	resp    chan []byte // Receives the response from the agent
+}
+
+// remoteAgent describes an agent connected to a coordinator.
+type remoteAgent struct {
+	sync.Mutex
+	conn  net.Conn               // Connection to the agent
+	wlock sync.Mutex             // Serializes writes to the connection
+	calls map[uint64]*remoteCall // Calls assigned to the agent
+	dead  chan struct{}          // Closed when the agent is lost
+	once  sync.Once              // Ensures dead is only closed once
+}
+
+// send sends a message to the agent.
+func (a *remoteAgent) send(msg []byte) error {
+	a.wlock.Lock()
+	defer a.wlock.Unlock()
+
+	return writeFrame(a.conn, msg)
+}
+
+// fail marks the agent as lost and closes its connection.
+func (a *remoteAgent) fail() {
+	a.once.Do(func() {
+		a.Lock()
+		close(a.dead)
+		a.calls = nil
+		a.Unlock()
+		a.conn.Close()
+	})
+}
+
+// assign sends a call to the agent.  It returns false if the agent
+// has already been lost.  If sending fails, the agent is marked as
+// lost.
+func (a *remoteAgent) assign(call *remoteCall) bool {
+	a.Lock()
+	if a.calls == nil {
+		a.Unlock()
+		return false
+	}
+	a.calls[call.id] = call
+	a.Unlock()
+
+	msg := make([]byte, 9, 9+len(call.payload))
+	msg[0] = remoteWork
+	binary.BigEndian.PutUint64(msg[1:], call.id)
+	if err := a.send(append(msg, call.payload...)); err != nil {
+		a.fail()
+	}
+
+	return true
+}
+
+// complete delivers a response to the call it belongs to.
+func (a *remoteAgent) complete(id uint64, resp []byte) {
+	a.Lock()
+	call, ok := a.calls[id]
+	delete(a.calls, id)
+	a.Unlock()
+
+	if ok {
+		call.resp <- resp
+	}
+}
+
+// remoteRunner is a Runner that wraps the application's Runner,
+// passing data items to remote agents to be run.
+type remoteRunner struct {
+	sync.Mutex
+	Runner                           // The application's Runner
+	codec      Codec                 // Codec for data items and results
+	listener   net.Listener          // Listener accepting agents
+	interval   time.Duration         // Interval between heartbeats
+	lease      time.Duration         // How long before an agent is lost
+	ready      chan *remoteAgent     // Agents with a free slot
+	nextID     uint64                // Identifier for the next call
+	agents     map[*remoteAgent]bool // Connected agents
+	closed     bool                  // True once shut down
+	acceptDone chan struct{}         // Closed when the accept loop exits
+	wg         sync.WaitGroup        // Waits for the agent goroutines
+}
+
+// accept is the goroutine that accepts connections from agents.
+func (r *remoteRunner) accept() {
+	defer close(r.acceptDone)
+
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		r.wg.Add(1)
+		go r.serve(conn)
+	}
+}
+
+// release returns a slot of the agent to the ready channel.
+func (r *remoteRunner) release(a *remoteAgent) {
+	go func() {
+		select {
+		case r.ready <- a:
+		case <-a.dead:
+		}
+	}()
+}
+
+// heartbeat is the goroutine that sends heartbeats to an agent.
+func (r *remoteRunner) heartbeat(a *remoteAgent) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.send([]byte{remoteHeartbeat}); err != nil {
+				a.fail()
+				return
+			}
+
+		case <-a.dead:
+			return
+		}
+	}
+}
+
+// serve is the goroutine that handles the connection from an agent.
+// It reads messages from the agent until the agent's lease expires or
+// the connection fails, at which point the calls assigned to the
+// agent are reassigned.
+func (r *remoteRunner) serve(conn net.Conn) {
+	defer r.wg.Done()
+
+	a := &remoteAgent{
+		conn:  conn,
+		calls: map[uint64]*remoteCall{},
+		dead:  make(chan struct{}),
+	}
+	defer a.fail()
+
+	// Read the hello
+	conn.SetReadDeadline(time.Now().Add(r.lease))
+	msg, err := readFrame(conn)
+	if err != nil || len(msg) != 5 || msg[0] != remoteHello {
+		return
+	}
+	slots := int(binary.BigEndian.Uint32(msg[1:]))
+
+	// Send the welcome
+	welcome := make([]byte, 17)
+	welcome[0] = remoteWelcome
+	binary.BigEndian.PutUint64(welcome[1:], uint64(r.interval))
+	binary.BigEndian.PutUint64(welcome[9:], uint64(r.lease))
+	if err := a.send(welcome); err != nil {
+		return
+	}
+
+	// Register the agent
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	r.agents[a] = true
+	r.Unlock()
+	defer func() {
+		r.Lock()
+		delete(r.agents, a)
+		r.Unlock()
+	}()
+
+	// Start heartbeats and offer the agent's slots
+	go r.heartbeat(a)
+	for i := 0; i < slots; i++ {
+		r.release(a)
+	}
+
+	// Read messages
+	for {
+		conn.SetReadDeadline(time.Now().Add(r.lease))
+		msg, err := readFrame(conn)
+		if err != nil || len(msg) == 0 {
+			return
+		}
+
+		switch msg[0] {
+		case remoteHeartbeat:
+
+		case remoteResult:
+			if len(msg) < 9 {
+				return
+			}
+			a.complete(binary.BigEndian.Uint64(msg[1:]), msg[9:])
+
+		default:
+			return
+		}
+	}
+}
+
+// Run passes the data to an agent with a free slot and returns the
+// result.  If the agent is lost before returning the result, the data
+// is reassigned to another agent.  If the agent reported a panic, Run
+// panics, so that the panic is reported in Result.Panic.
+func (r *remoteRunner) Run(data interface{}) interface{} {
+	payload, err := r.codec.Encode(data)
+	if err != nil {
+		panic(err)
+	}
+
+	call := &remoteCall{
+		id:      atomic.AddUint64(&r.nextID, 1),
+		payload: payload,
+		resp:    make(chan []byte, 1),
+	}
+	for {
+		a := <-r.ready
+		if !a.assign(call) {
+			continue
+		}
+
+		// Wait for the response, or for the agent to be lost
+		var resp []byte
+		ok := false
+		select {
+		case resp, ok = <-call.resp:
+		case <-a.dead:
+			select {
+			case resp, ok = <-call.resp:
+			default:
+			}
+		}
+		if !ok {
+			continue
+		}
+		r.release(a)
+
+		result, panicVal, err := processResponse(resp, r.codec)
+		if err != nil {
+			panic(err)
+		} else if panicVal != nil {
+			panic(panicVal)
+		}
+		return result
+	}
+}
+
+// Result shuts down the coordinator, disconnecting the agents, then
+// returns the final result from the application's Runner.
+func (r *remoteRunner) Result() interface{} {
+	// Stop accepting agents
+	r.listener.Close()
+	<-r.acceptDone
+
+	// Say goodbye to the agents
+	r.Lock()
+	r.closed = true
+	for a := range r.agents {
+		a.send([]byte{remoteGoodbye})
+		a.fail()
+	}
+	r.Unlock()
+	r.wg.Wait()
+
+	return r.Runner.Result()
+}
+
+// NewCoordinator constructs a worker that distributes data items to
+// agents connected through the listener, allowing a Runner to be
+// scaled beyond one machine.  Agents are started with ServeAgent or
+// DialAgent, and may connect at any time until Worker.Wait is called;
+// data items wait until an agent has a free slot.  Data items and
+// results are exchanged with the agents using the Codec.  Agents and
+// the coordinator exchange heartbeats; an agent that is not heard from
+// for the lease duration, or whose connection fails, is considered
+// lost, and the data items assigned to it are reassigned to other
+// agents, as many times as necessary.  The heartbeat interval and
+// lease duration may be set with the WithHeartbeat option, and default
+// to 1 second and 5 seconds.  If Runner.Run panics in an agent,
+// Result.Panic is set to the string representation of the panic
+// value.  Runner.Integrate and Runner.Result are called in the current
+// process.  Worker.Wait disconnects the agents and closes the
+// listener.  The returned Worker is otherwise like one returned by
+// NewGoWorker, and accepts the same options, as well as
+// WithHeartbeat.
+func NewCoordinator(runner Runner, listener net.Listener, codec Codec, opts ...Option) Worker {
+	o := mustOptions("NewCoordinator", goOptions|optHeartbeat, opts)
+
+	r := &remoteRunner{
+		Runner:     runner,
+		codec:      codec,
+		listener:   listener,
+		interval:   o.heartbeatInterval,
+		lease:      o.leaseDuration,
+		ready:      make(chan *remoteAgent),
+		agents:     map[*remoteAgent]bool{},
+		acceptDone: make(chan struct{}),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultHeartbeatInterval
+	}
+	if r.lease <= 0 {
+		r.lease = defaultLeaseDuration
+	}
+
+	go r.accept()
+
+	return newGoWorker(r, 0, o)
+}
+
+// ServeAgent implements an agent for a coordinator constructed by
+// NewCoordinator, communicating over the connection.  It runs up to
+// the specified number of data items at a time; if that number is less
+// than or equal to 0, the number of CPUs is used.  Only the Run method
+// of the Runner is called.  The Codec must match the one passed to
+// NewCoordinator.  ServeAgent closes the connection and returns nil
+// when the coordinator shuts down, or an error if the connection
+// fails or the coordinator is not heard from for the lease duration.
+func ServeAgent(conn net.Conn, runner Runner, codec Codec, slots int) error {
+	defer conn.Close()
+	if slots <= 0 {
+		slots = runtime.NumCPU()
+	}
+
+	// Send the hello
+	wlock := &sync.Mutex{}
+	send := func(msg []byte) error {
+		wlock.Lock()
+		defer wlock.Unlock()
+		return writeFrame(conn, msg)
+	}
+	hello := make([]byte, 5)
+	hello[0] = remoteHello
+	binary.BigEndian.PutUint32(hello[1:], uint32(slots))
+	if err := send(hello); err != nil {
+		return err
+	}
+
+	// Read the welcome
+	conn.SetReadDeadline(time.Now().Add(defaultLeaseDuration))
+	msg, err := readFrame(conn)
+	if err != nil {
+		return err
+	} else if len(msg) != 17 || msg[0] != remoteWelcome {
+		return ErrProtocol
+	}
+	interval := time.Duration(binary.BigEndian.Uint64(msg[1:]))
+	lease := time.Duration(binary.BigEndian.Uint64(msg[9:]))
+
+	// Send heartbeats
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer close(stop)
+	defer conn.Close()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				send([]byte{remoteHeartbeat})
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Process messages
+	for {
+		conn.SetReadDeadline(time.Now().Add(lease))
+		msg, err := readFrame(conn)
+		if err != nil {
+			return err
+		} else if len(msg) == 0 {
+			return ErrProtocol
+		}
+
+		switch msg[0] {
+		case remoteHeartbeat:
+
+		case remoteWork:
+			if len(msg) < 9 {
+				return ErrProtocol
+			}
+			wg.Add(1)
+			go func(id, req []byte) {
+				defer wg.Done()
+				resp := append([]byte{remoteResult}, id...)
+				send(append(resp, processRespond(req, runner, codec)...))
+			}(msg[1:9], msg[9:])
+
+		case remoteGoodbye:
+			return nil
+
+		default:
+			return ErrProtocol
+		}
+	}
+}
+
+// DialAgent connects to a coordinator constructed by NewCoordinator at
+// the specified network address, then serves as an agent using
+// ServeAgent.
+func DialAgent(network, address string, runner Runner, codec Codec, slots int) error {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+
+	return ServeAgent(conn, runner, codec, slots)
+}
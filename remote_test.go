@@ -0,0 +1,391 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteTestAgentEnv is the environment variable that causes the test
+// binary to serve as an agent connecting to the specified address.
+const remoteTestAgentEnv = "PARALLELIZER_TEST_AGENT"
+
+// listenLocal opens a listener on a local TCP port.
+func listenLocal(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	return l
+}
+
+// startAgents starts agents in goroutines, returning a function that
+// waits for them to exit and returns their errors.
+func startAgents(network, address string, count, slots int) func() []error {
+	wg := &sync.WaitGroup{}
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = DialAgent(network, address, processTestRunner{}, JSONCodec{}, slots)
+		}(i)
+	}
+
+	return func() []error {
+		wg.Wait()
+		return errs
+	}
+}
+
+// fakeAgent connects to a coordinator and performs the handshake,
+// offering one slot.  It returns the connection.
+func fakeAgent(t *testing.T, address string) net.Conn {
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+
+	require.NoError(t, writeFrame(conn, []byte{remoteHello, 0, 0, 0, 1}))
+	msg, err := readFrame(conn)
+	require.NoError(t, err)
+	require.Equal(t, remoteWelcome, msg[0])
+
+	return conn
+}
+
+// readWork reads messages sent to a fake agent until a work message is
+// received, and returns its payload.
+func readWork(t *testing.T, conn net.Conn) []byte {
+	for {
+		msg, err := readFrame(conn)
+		require.NoError(t, err)
+		if msg[0] == remoteWork {
+			return msg[9:]
+		}
+	}
+}
+
+func TestRemoteAgentAssignDead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	obj := &remoteAgent{
+		conn:  server,
+		calls: map[uint64]*remoteCall{},
+		dead:  make(chan struct{}),
+	}
+	obj.fail()
+
+	result := obj.assign(&remoteCall{id: 1})
+
+	assert.False(t, result)
+}
+
+func TestRemoteAgentAssignSendError(t *testing.T) {
+	client, server := net.Pipe()
+	client.Close()
+	obj := &remoteAgent{
+		conn:  server,
+		calls: map[uint64]*remoteCall{},
+		dead:  make(chan struct{}),
+	}
+
+	result := obj.assign(&remoteCall{id: 1})
+
+	assert.True(t, result)
+	_, ok := <-obj.dead
+	assert.False(t, ok)
+}
+
+func TestRemoteAgentComplete(t *testing.T) {
+	call := &remoteCall{id: 1, resp: make(chan []byte, 1)}
+	obj := &remoteAgent{
+		calls: map[uint64]*remoteCall{1: call},
+	}
+
+	obj.complete(2, []byte("other"))
+	obj.complete(1, []byte("resp"))
+
+	assert.Equal(t, []byte("resp"), <-call.resp)
+	assert.Len(t, obj.calls, 0)
+}
+
+func TestNewCoordinatorBase(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	l := listenLocal(t)
+
+	result := NewCoordinator(runner, l, JSONCodec{}, WithHeartbeat(time.Second, 3*time.Second))
+
+	require.IsType(t, &goWorker{}, result)
+	w := result.(*goWorker)
+	require.IsType(t, &remoteRunner{}, w.runner)
+	r := w.runner.(*remoteRunner)
+	assert.Same(t, runner, r.Runner)
+	assert.Equal(t, JSONCodec{}, r.codec)
+	assert.Same(t, l, r.listener)
+	assert.Equal(t, time.Second, r.interval)
+	assert.Equal(t, 3*time.Second, r.lease)
+	assert.Nil(t, w.limit)
+	final, err := result.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "result", final)
+	runner.AssertExpectations(t)
+}
+
+func TestNewCoordinatorDefaults(t *testing.T) {
+	result := NewCoordinator(&MockRunner{}, listenLocal(t), JSONCodec{})
+
+	r := result.(*goWorker).runner.(*remoteRunner)
+	assert.Equal(t, defaultHeartbeatInterval, r.interval)
+	assert.Equal(t, defaultLeaseDuration, r.lease)
+	r.listener.Close()
+}
+
+func TestCoordinatorTCP(t *testing.T) {
+	l := listenLocal(t)
+	runner := &sumRunner{}
+	obj := NewCoordinator(runner, l, JSONCodec{})
+	wait := startAgents("tcp", l.Addr().String(), 3, 2)
+	for i := 1.0; i <= 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 110.0, result)
+	assert.Equal(t, []error{nil, nil, nil}, wait())
+}
+
+func TestCoordinatorUnix(t *testing.T) {
+	path := filepath.Join(tempDir(t), "sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	obj := NewCoordinator(&countRunner{}, l, JSONCodec{})
+	wait := startAgents("unix", path, 1, 1)
+	cr1, err := obj.(AsyncWorker).CallAsync(2.0)
+	require.NoError(t, err)
+	cr2, err := obj.(AsyncWorker).CallAsync("panic")
+	require.NoError(t, err)
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result)
+	assert.Equal(t, &Result{Result: 4.0}, cr1.Wait())
+	assert.Equal(t, "boom", cr2.Wait().Panic)
+	assert.Equal(t, []error{nil}, wait())
+}
+
+func TestCoordinatorReassignLostAgent(t *testing.T) {
+	l := listenLocal(t)
+	obj := NewCoordinator(&sumRunner{}, l, JSONCodec{})
+	fake := fakeAgent(t, l.Addr().String())
+	require.NoError(t, obj.Call(3.0))
+	assert.Equal(t, []byte("3"), readWork(t, fake))
+	wait := startAgents("tcp", l.Addr().String(), 1, 1)
+
+	fake.Close()
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6.0, result)
+	assert.Equal(t, []error{nil}, wait())
+}
+
+func TestCoordinatorReassignLeaseExpired(t *testing.T) {
+	l := listenLocal(t)
+	obj := NewCoordinator(&sumRunner{}, l, JSONCodec{}, WithHeartbeat(10*time.Millisecond, 100*time.Millisecond))
+	fake := fakeAgent(t, l.Addr().String())
+	defer fake.Close()
+	require.NoError(t, obj.Call(3.0))
+	assert.Equal(t, []byte("3"), readWork(t, fake))
+	wait := startAgents("tcp", l.Addr().String(), 1, 1)
+
+	// The fake agent now goes silent
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6.0, result)
+	assert.Equal(t, []error{nil}, wait())
+}
+
+func TestCoordinatorBadHello(t *testing.T) {
+	l := listenLocal(t)
+	obj := NewCoordinator(&MockRunner{}, l, JSONCodec{})
+	defer obj.(*goWorker).runner.(*remoteRunner).listener.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, writeFrame(conn, []byte{remoteHeartbeat}))
+	_, err = readFrame(conn)
+
+	assert.Error(t, err)
+}
+
+func TestCoordinatorSubprocessAgents(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	l := listenLocal(t)
+	obj := NewCoordinator(&sumRunner{}, l, JSONCodec{})
+	cmds := []*exec.Cmd{}
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(exe)
+		cmd.Env = append(os.Environ(), remoteTestAgentEnv+"="+l.Addr().String())
+		cmd.Stderr = os.Stderr
+		require.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	r := obj.(*goWorker).runner.(*remoteRunner)
+	require.Eventually(t, func() bool {
+		r.Lock()
+		defer r.Unlock()
+		return len(r.agents) == 2
+	}, 10*time.Second, time.Millisecond)
+	for i := 1.0; i <= 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 110.0, result)
+	for _, cmd := range cmds {
+		assert.NoError(t, cmd.Wait())
+	}
+}
+
+// agentPipe starts ServeAgent on one end of a pipe and performs the
+// coordinator's side of the handshake on the other.  It returns the
+// coordinator's end and a channel receiving the error from
+// ServeAgent.
+func agentPipe(t *testing.T, welcome []byte) (net.Conn, <-chan error) {
+	client, server := net.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- ServeAgent(client, processTestRunner{}, JSONCodec{}, 3)
+	}()
+
+	msg, err := readFrame(server)
+	require.NoError(t, err)
+	require.Equal(t, []byte{remoteHello, 0, 0, 0, 3}, msg)
+	require.NoError(t, writeFrame(server, welcome))
+
+	return server, errs
+}
+
+// remoteWelcomeMsg constructs a welcome message.
+func remoteWelcomeMsg(interval, lease time.Duration) []byte {
+	msg := make([]byte, 17)
+	msg[0] = remoteWelcome
+	binary.BigEndian.PutUint64(msg[1:], uint64(interval))
+	binary.BigEndian.PutUint64(msg[9:], uint64(lease))
+
+	return msg
+}
+
+func TestServeAgentWork(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Hour, time.Hour))
+	defer conn.Close()
+
+	require.NoError(t, writeFrame(conn, append([]byte{remoteWork, 0, 0, 0, 0, 0, 0, 0, 7}, "2"...)))
+	msg, err := readFrame(conn)
+	require.NoError(t, err)
+	require.NoError(t, writeFrame(conn, []byte{remoteGoodbye}))
+
+	assert.Equal(t, append([]byte{remoteResult, 0, 0, 0, 0, 0, 0, 0, 7, processResult}, "4"...), msg)
+	assert.NoError(t, <-errs)
+}
+
+func TestServeAgentHeartbeat(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Millisecond, time.Hour))
+	defer conn.Close()
+
+	msg, err := readFrame(conn)
+	require.NoError(t, err)
+	require.NoError(t, writeFrame(conn, []byte{remoteGoodbye}))
+
+	assert.Equal(t, []byte{remoteHeartbeat}, msg)
+	go func() {
+		for {
+			if _, err := readFrame(conn); err != nil {
+				return
+			}
+		}
+	}()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeAgentLeaseExpired(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Hour, time.Millisecond))
+	defer conn.Close()
+
+	err := <-errs
+
+	assert.Error(t, err)
+}
+
+func TestServeAgentBadWelcome(t *testing.T) {
+	conn, errs := agentPipe(t, []byte{remoteHeartbeat})
+	defer conn.Close()
+
+	assert.Same(t, ErrProtocol, <-errs)
+}
+
+func TestServeAgentBadMessage(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Hour, time.Hour))
+	defer conn.Close()
+
+	require.NoError(t, writeFrame(conn, []byte{remoteHello}))
+
+	assert.Same(t, ErrProtocol, <-errs)
+}
+
+func TestServeAgentShortWork(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Hour, time.Hour))
+	defer conn.Close()
+
+	require.NoError(t, writeFrame(conn, []byte{remoteWork, 0}))
+
+	assert.Same(t, ErrProtocol, <-errs)
+}
+
+func TestServeAgentEmptyMessage(t *testing.T) {
+	conn, errs := agentPipe(t, remoteWelcomeMsg(time.Hour, time.Hour))
+	defer conn.Close()
+
+	require.NoError(t, writeFrame(conn, []byte{}))
+
+	assert.Same(t, ErrProtocol, <-errs)
+}
+
+func TestDialAgentError(t *testing.T) {
+	l := listenLocal(t)
+	addr := l.Addr().String()
+	l.Close()
+
+	err := DialAgent("tcp", addr, processTestRunner{}, JSONCodec{}, 1)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Scheduler is an interface describing a source of scheduling
+// decisions for a worker constructed by NewScheduledWorker.  The
+// package provides a seedable pseudo-random scheduler, returned by
+// NewRandomScheduler, and a scheduler that replays a recorded
+// sequence of decisions, returned by NewReplayScheduler.
+type Scheduler interface {
+	// Choose selects one of n possible actions, returning a
+	// number from 0 to n-1.  It is only called with n greater
+	// than 1.
+	Choose(n int) int
+}
+
+// TestingT is the subset of the testing.T interface used by
+// ExploreRandom and ExploreAll.
+type TestingT interface {
+	// Errorf reports a formatted error and marks the test as
+	// failed.
+	Errorf(format string, args ...interface{})
+
+	// Failed reports whether the test has failed.
+	Failed() bool
+}
+
+// randomScheduler is an implementation of Scheduler that makes
+// pseudo-random decisions.
+type randomScheduler struct {
+	seed int64      // The seed of the scheduler
+	rand *rand.Rand // The source of decisions
+}
+
+// NewRandomScheduler constructs a Scheduler that makes pseudo-random
+// decisions.  Schedulers constructed with the same seed make the same
+// decisions, so a schedule may be reproduced using its seed.
+func NewRandomScheduler(seed int64) Scheduler {
+	return &randomScheduler{
+		seed: seed,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Choose selects one of n possible actions, returning a number from 0
+// to n-1.  It is only called with n greater than 1.
+func (s *randomScheduler) Choose(n int) int {
+	return s.rand.Intn(n)
+}
+
+// replayScheduler is an implementation of Scheduler that replays a
+// recorded sequence of decisions, recording the decisions it makes.
+type replayScheduler struct {
+	choices []int // The decisions to replay
+	trace   []int // The decisions made
+	options []int // The number of possible actions for each decision
+}
+
+// NewReplayScheduler constructs a Scheduler that replays a recorded
+// sequence of decisions, such as is reported by ExploreAll.  Once the
+// sequence is exhausted, or if a recorded decision is out of range,
+// the first action is chosen.
+func NewReplayScheduler(choices []int) Scheduler {
+	return &replayScheduler{choices: choices}
+}
+
+// Choose selects one of n possible actions, returning a number from 0
+// to n-1.  It is only called with n greater than 1.
+func (s *replayScheduler) Choose(n int) int {
+	choice := 0
+	if i := len(s.trace); i < len(s.choices) && s.choices[i] < n {
+		choice = s.choices[i]
+	}
+	s.trace = append(s.trace, choice)
+	s.options = append(s.options, n)
+
+	return choice
+}
+
+// next computes the sequence of decisions for the next schedule in a
+// depth-first enumeration of the possible schedules.  It returns false
+// if all schedules have been enumerated.
+func (s *replayScheduler) next() ([]int, bool) {
+	for i := len(s.trace) - 1; i >= 0; i-- {
+		if s.trace[i]+1 < s.options[i] {
+			next := make([]int, i+1)
+			copy(next, s.trace[:i])
+			next[i] = s.trace[i] + 1
+			return next, true
+		}
+	}
+
+	return nil, false
+}
+
+// schedDone describes a result waiting to be integrated.
+type schedDone struct {
+	item   *workItem // The work item
+	result *Result   // The result of Runner.Run
+}
+
+// scheduledWorker is an implementation of the SteppingWorker interface
+// that runs the data items and integrates their results in an order
+// controlled by a Scheduler.  Like the synchronous worker, there are
+// no goroutines involved, and it should not be used simultaneously
+// from different goroutines.
+type scheduledWorker struct {
+	state       pState      // State of the worker
+	runner      Runner      // The runner to be invoked by the worker
+	sched       Scheduler   // The source of scheduling decisions
+	runs        []*workItem // Items waiting to be run
+	integrates  []schedDone // Results waiting to be integrated
+	integrating bool        // True while Runner.Integrate is running
+	result      interface{} // The result from calling Runner.Result
+}
+
+// NewScheduledWorker constructs a worker for testing Runners under a
+// reproducible ordering.  Data items submitted to the worker are not
+// processed until the caller calls SteppingWorker.Step or Worker.Wait;
+// each step either calls Runner.Run for one submitted data item or
+// calls Runner.Integrate for one result, with the Scheduler choosing
+// among all the possible steps.  Thus, results may be integrated in
+// any order, and Runner.Run calls may be interleaved with
+// Runner.Integrate calls, as they are in a parallel worker, but a
+// given sequence of scheduling decisions always produces the same
+// order.  The returned Worker implements SteppingWorker.
+func NewScheduledWorker(runner Runner, sched Scheduler) Worker {
+	return &scheduledWorker{
+		runner: runner,
+		sched:  sched,
+	}
+}
+
+// Step performs a single scheduling step: either calling Runner.Run
+// for one submitted data item, or calling Runner.Integrate for one
+// result.  It returns false if there was nothing to do.
+func (w *scheduledWorker) Step() bool {
+	// Pick a step
+	n := len(w.runs) + len(w.integrates)
+	if n == 0 {
+		return false
+	}
+	choice := 0
+	if n > 1 {
+		choice = w.sched.Choose(n)
+		if choice < 0 || choice >= n {
+			choice = 0
+		}
+	}
+
+	// Run an item
+	if choice < len(w.runs) {
+		item := w.runs[choice]
+		w.runs = append(w.runs[:choice], w.runs[choice+1:]...)
+		w.integrates = append(w.integrates, schedDone{
+			item:   item,
+			result: panicer("NewScheduledWorker", w.runner.Run, item.data),
+		})
+		return true
+	}
+
+	// Integrate a result
+	choice -= len(w.runs)
+	done := w.integrates[choice]
+	w.integrates = append(w.integrates[:choice], w.integrates[choice+1:]...)
+	w.integrating = true
+	w.runner.Integrate(w, done.result)
+	w.integrating = false
+	done.item.resolve(done.result)
+
+	return true
+}
+
+// call is a helper for Call and CallAsync that submits a work item.
+func (w *scheduledWorker) call(item *workItem) error {
+	switch w.state {
+	case pNew:
+		w.state = pRunning
+
+	case pClosed, pResult:
+		// Accept recursive Calls even when closed, so we work
+		// all items
+		if !w.integrating {
+			return ErrClosed
+		}
+	}
+
+	w.runs = append(w.runs, item)
+
+	return nil
+}
+
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  It may return an error if the worker has
+// been shut down through a call to Wait.
+func (w *scheduledWorker) Call(data interface{}) error {
+	return w.call(&workItem{data: data})
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (w *scheduledWorker) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	if err := w.call(item); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Wait is called to shut down the worker and return the final result;
+// it performs steps until all data has been processed.  Note that the
+// final result, generated by Runner.Result, is saved by Worker to
+// satisfy later calls to Wait.  If Wait is called before any calls to
+// Call, the worker will go straight to a stopped state, and no further
+// Call calls may be made; no error will be returned in that case.
+func (w *scheduledWorker) Wait() (interface{}, error) {
+	// Detect deadlocks
+	if w.integrating {
+		return nil, ErrWouldDeadlock
+	}
+
+	switch w.state {
+	case pNew, pRunning, pClosed: // Get the result
+		w.state = pClosed
+		for w.Step() {
+		}
+		w.result = w.runner.Result()
+		w.state = pResult
+	}
+
+	return w.result, nil
+}
+
+// exploreRun is a helper for ExploreRandom and ExploreAll that calls
+// the test function with a scheduler.  It returns true if the test
+// failed during the call; if the test function exits the goroutine,
+// such as by calling testing.T.FailNow, the report function is still
+// called.
+func exploreRun(t TestingT, sched Scheduler, fn func(sched Scheduler), report func()) (failed bool) {
+	before := t.Failed()
+	defer func() {
+		if failed = !before && t.Failed(); failed {
+			report()
+		}
+	}()
+
+	fn(sched)
+
+	return
+}
+
+// ExploreRandom runs a concurrency test under a number of
+// pseudo-random schedules.  The test function should construct a
+// worker with NewScheduledWorker using the Scheduler it is passed,
+// exercise the Runner under test, and report failures to t.  The
+// schedulers are seeded with consecutive seeds starting at the
+// specified seed; if the seed is 0, a seed is derived from the
+// current time.  Exploration stops at the first failing schedule,
+// whose seed is reported to t; the failing schedule may then be
+// reproduced by passing that seed to NewRandomScheduler.
+func ExploreRandom(t TestingT, seed int64, runs int, fn func(sched Scheduler)) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	for i := 0; i < runs; i++ {
+		s := seed + int64(i)
+		if exploreRun(t, NewRandomScheduler(s), fn, func() {
+			t.Errorf("Failing schedule has seed %d", s)
+		}) {
+			return
+		}
+	}
+}
+
+// ExploreAll runs a concurrency test under every possible schedule,
+// or under the first limit schedules if limit is greater than 0; it
+// returns the number of schedules explored.  This is practical only
+// for small numbers of data items, since the number of schedules grows
+// factorially.  The test function should construct a worker with
+// NewScheduledWorker using the Scheduler it is passed, exercise the
+// Runner under test, and report failures to t; it must behave
+// deterministically under a given schedule.  Exploration stops at the
+// first failing schedule, whose sequence of scheduling decisions is
+// reported to t; the failing schedule may then be reproduced by
+// passing that sequence to NewReplayScheduler.
+func ExploreAll(t TestingT, limit int, fn func(sched Scheduler)) int {
+	var choices []int
+	count := 0
+	for limit <= 0 || count < limit {
+		count++
+		s := &replayScheduler{choices: choices}
+		if exploreRun(t, s, fn, func() {
+			t.Errorf("Failing schedule has decisions %#v", s.trace)
+		}) {
+			break
+		}
+
+		var ok bool
+		if choices, ok = s.next(); !ok {
+			break
+		}
+	}
+
+	return count
+}
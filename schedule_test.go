@@ -0,0 +1,350 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT is an implementation of TestingT that records errors.
+type fakeT struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Failed() bool {
+	return len(t.errors) > 0
+}
+
+func (t *fakeT) Cleanup(fn func()) {
+	t.cleanups = append(t.cleanups, fn)
+}
+
+// finish runs the functions registered with Cleanup, in reverse order.
+func (t *fakeT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+	t.cleanups = nil
+}
+
+// orderRunner is a Runner whose Integrate records the order in which
+// results are integrated.  Results greater than 10 cause a recursive
+// Call with the result less 10.
+type orderRunner struct {
+	order []interface{}
+}
+
+func (r *orderRunner) Run(data interface{}) interface{} {
+	return data
+}
+
+func (r *orderRunner) Integrate(worker Worker, result *Result) {
+	if n := result.Result.(int); n > 10 {
+		worker.Call(n - 10)
+	}
+	r.order = append(r.order, result.Result)
+}
+
+func (r *orderRunner) Result() interface{} {
+	return r.order
+}
+
+// orderTest is a test function for the explorers that expects results
+// to be integrated in submission order, which is a bug, and so fails
+// under some schedules.
+func orderTest(t TestingT) func(sched Scheduler) {
+	return func(sched Scheduler) {
+		w := NewScheduledWorker(&orderRunner{}, sched)
+		w.Call(1)
+		w.Call(2)
+		result, _ := w.Wait()
+		assert.Equal(t, []interface{}{1, 2}, result)
+	}
+}
+
+func TestRandomSchedulerImplementsScheduler(t *testing.T) {
+	assert.Implements(t, (*Scheduler)(nil), &randomScheduler{})
+}
+
+func TestRandomSchedulerReproducible(t *testing.T) {
+	obj1 := NewRandomScheduler(42)
+	obj2 := NewRandomScheduler(42)
+
+	for i := 0; i < 10; i++ {
+		c := obj1.Choose(5)
+		assert.True(t, c >= 0 && c < 5)
+		assert.Equal(t, c, obj2.Choose(5))
+	}
+}
+
+func TestReplaySchedulerImplementsScheduler(t *testing.T) {
+	assert.Implements(t, (*Scheduler)(nil), &replayScheduler{})
+}
+
+func TestReplaySchedulerChoose(t *testing.T) {
+	obj := NewReplayScheduler([]int{1, 5, 2})
+
+	result := []int{obj.Choose(3), obj.Choose(3), obj.Choose(3), obj.Choose(4)}
+
+	assert.Equal(t, []int{1, 0, 2, 0}, result)
+	assert.Equal(t, []int{1, 0, 2, 0}, obj.(*replayScheduler).trace)
+	assert.Equal(t, []int{3, 3, 3, 4}, obj.(*replayScheduler).options)
+}
+
+func TestReplaySchedulerNext(t *testing.T) {
+	obj := &replayScheduler{
+		trace:   []int{1, 2, 0, 1},
+		options: []int{3, 4, 2, 2},
+	}
+
+	result, ok := obj.next()
+
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 2, 1}, result)
+}
+
+func TestReplaySchedulerNextDone(t *testing.T) {
+	obj := &replayScheduler{
+		trace:   []int{2, 1},
+		options: []int{3, 2},
+	}
+
+	result, ok := obj.next()
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestScheduledWorkerImplementsSteppingWorker(t *testing.T) {
+	assert.Implements(t, (*SteppingWorker)(nil), &scheduledWorker{})
+}
+
+func TestNewScheduledWorker(t *testing.T) {
+	runner := &MockRunner{}
+	sched := &MockScheduler{}
+
+	result := NewScheduledWorker(runner, sched)
+
+	assert.Equal(t, &scheduledWorker{
+		runner: runner,
+		sched:  sched,
+	}, result)
+}
+
+func TestScheduledWorkerStep(t *testing.T) {
+	runner := &MockRunner{}
+	sched := &MockScheduler{}
+	obj := &scheduledWorker{
+		state:  pRunning,
+		runner: runner,
+		sched:  sched,
+	}
+	item1 := &workItem{data: "one"}
+	item2 := &workItem{data: "two"}
+	obj.runs = []*workItem{item1, item2}
+	runner.On("Run", "one").Return("result1")
+	runner.On("Run", "two").Return("result2")
+	runner.On("Integrate", obj, &Result{Result: "result1"})
+	runner.On("Integrate", obj, &Result{Result: "result2"})
+	sched.On("Choose", 2).Return(42).Once()
+	sched.On("Choose", 2).Return(1).Once()
+
+	assert.True(t, obj.Step()) // runs "one"; bad choice
+	assert.True(t, obj.Step()) // integrates "one"
+	assert.True(t, obj.Step()) // runs "two"
+	assert.True(t, obj.Step()) // integrates "two"
+	assert.False(t, obj.Step())
+
+	runner.AssertExpectations(t)
+	sched.AssertExpectations(t)
+	assert.Len(t, obj.runs, 0)
+	assert.Len(t, obj.integrates, 0)
+}
+
+func TestScheduledWorkerStepPanic(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &scheduledWorker{
+		state:  pRunning,
+		runner: runner,
+		runs:   []*workItem{{data: "data"}},
+	}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("oops")
+	})
+
+	result := obj.Step()
+
+	assert.True(t, result)
+	require.Len(t, obj.integrates, 1)
+	assert.Equal(t, &workItem{data: "data"}, obj.integrates[0].item)
+	assert.Equal(t, "oops", obj.integrates[0].result.Panic)
+	assert.Equal(t, "NewScheduledWorker", obj.integrates[0].result.PanicError.Source)
+	runner.AssertExpectations(t)
+}
+
+func TestScheduledWorkerCallNew(t *testing.T) {
+	obj := &scheduledWorker{}
+
+	err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, pRunning, obj.state)
+	assert.Equal(t, []*workItem{{data: "data"}}, obj.runs)
+}
+
+func TestScheduledWorkerCallClosed(t *testing.T) {
+	obj := &scheduledWorker{state: pResult}
+
+	err := obj.Call("data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Len(t, obj.runs, 0)
+}
+
+func TestScheduledWorkerCallClosedIntegrating(t *testing.T) {
+	obj := &scheduledWorker{
+		state:       pClosed,
+		integrating: true,
+	}
+
+	err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*workItem{{data: "data"}}, obj.runs)
+}
+
+func TestScheduledWorkerCallAsync(t *testing.T) {
+	obj := NewScheduledWorker(&orderRunner{}, NewRandomScheduler(1))
+
+	cr, err := obj.(AsyncWorker).CallAsync(5)
+	require.NoError(t, err)
+	early, _ := cr.TryWait()
+	assert.Nil(t, early)
+	for obj.(SteppingWorker).Step() {
+	}
+
+	assert.Equal(t, &Result{Result: 5}, cr.Wait())
+}
+
+func TestScheduledWorkerCallAsyncClosed(t *testing.T) {
+	obj := &scheduledWorker{state: pResult}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Nil(t, cr)
+}
+
+func TestScheduledWorkerWait(t *testing.T) {
+	runner := &orderRunner{}
+	obj := NewScheduledWorker(runner, NewReplayScheduler(nil))
+	obj.Call(12)
+	obj.Call(3)
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{12, 3, 2}, result)
+	assert.Equal(t, pResult, obj.(*scheduledWorker).state)
+	result, err = obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{12, 3, 2}, result)
+}
+
+func TestScheduledWorkerWaitIntegrating(t *testing.T) {
+	obj := &scheduledWorker{integrating: true}
+
+	result, err := obj.Wait()
+
+	assert.Same(t, ErrWouldDeadlock, err)
+	assert.Nil(t, result)
+}
+
+func TestExploreAllCount(t *testing.T) {
+	ft := &fakeT{}
+
+	result := ExploreAll(ft, 0, func(sched Scheduler) {
+		w := NewScheduledWorker(&orderRunner{}, sched)
+		w.Call(1)
+		w.Call(2)
+		w.Call(3)
+		w.Wait()
+	})
+
+	assert.Equal(t, 90, result)
+	assert.Len(t, ft.errors, 0)
+}
+
+func TestExploreAllLimit(t *testing.T) {
+	ft := &fakeT{}
+
+	result := ExploreAll(ft, 5, func(sched Scheduler) {
+		w := NewScheduledWorker(&orderRunner{}, sched)
+		w.Call(1)
+		w.Call(2)
+		w.Wait()
+	})
+
+	assert.Equal(t, 5, result)
+}
+
+func TestExploreAllFailure(t *testing.T) {
+	ft := &fakeT{}
+
+	result := ExploreAll(ft, 0, orderTest(ft))
+
+	assert.Equal(t, 2, result)
+	require.Len(t, ft.errors, 2)
+	assert.Equal(t, "Failing schedule has decisions []int{0, 0, 1}", ft.errors[1])
+	ft2 := &fakeT{}
+	orderTest(ft2)(NewReplayScheduler([]int{0, 0, 1}))
+	assert.True(t, ft2.Failed())
+}
+
+func TestExploreRandomFailure(t *testing.T) {
+	ft := &fakeT{}
+
+	ExploreRandom(ft, 1, 100, orderTest(ft))
+
+	require.Len(t, ft.errors, 2)
+	var seed int64
+	_, err := fmt.Sscanf(ft.errors[1], "Failing schedule has seed %d", &seed)
+	require.NoError(t, err)
+	ft2 := &fakeT{}
+	orderTest(ft2)(NewRandomScheduler(seed))
+	assert.True(t, ft2.Failed())
+}
+
+func TestExploreRandomSuccess(t *testing.T) {
+	ft := &fakeT{}
+	count := 0
+
+	ExploreRandom(ft, 0, 10, func(sched Scheduler) {
+		count++
+	})
+
+	assert.Equal(t, 10, count)
+	assert.Len(t, ft.errors, 0)
+}
@@ -14,7 +14,10 @@
 
 package parallelizer
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Size of the request channel.
 const (
@@ -24,67 +27,119 @@ const (
 // doRequest contains a request to call the Doer.Do method.  It
 // contains an optional result channel, through which the results will
 // be returned to the caller.  The channel must have a buffer size of
-// at least one to avoid blocking the serializer manager goroutine.
+// at least one to avoid blocking the serializer manager goroutine.  If
+// the optional context is done by the time the manager goroutine gets
+// to the request, the request is skipped and the result channel is
+// closed.
 type doRequest struct {
-	data   interface{}    // The data to send to Doer.Do
-	result chan<- *Result // Optional channel to send the result to
+	ctx    context.Context // Optional context for the request
+	data   interface{}     // The data to send to Doer.Do
+	result chan<- *Result  // Optional channel to send the result to
 }
 
 // serializer is an implementation of the Serializer interface.
 type serializer struct {
 	sync.Mutex
-	state   pState         // State of the serializer
-	doer    Doer           // The Doer wrapped
-	request chan doRequest // Channel for requests
-	done    chan bool      // Channel for signaling done
-	gonner  *sync.Once     // A once incarnation for getting the result
-	result  interface{}    // The result from finishing the operation
+	state   pState           // State of the serializer
+	doer    Doer             // The Doer wrapped
+	request chan doRequest   // Channel for requests
+	done    chan bool        // Channel for signaling done
+	gonner  *sync.Once       // A once incarnation for getting the result
+	result  interface{}      // The result from finishing the operation
+	leak    *leakRecord      // Leak tracking record
+	watch   *watchdog        // Optional stall watchdog
+	repanic *repanicker      // Optional re-raising of panics in Wait
+	name    string           // Name given with WithName
+	finish  *completion      // Signals completion for ClosableSerializer
+	mgr     *goroutineMarker // Marks the manager goroutine
 }
 
 // NewSerializer constructs a serializer wrapping the specified Doer.
 // All calls to Doer.Do will occur in a single manager goroutine, but
 // the calls can be made from almost any other goroutine.  Note that
 // Doer.Do cannot call any of the Call* methods of Serializer due to
-// the potential for deadlocks.
-func NewSerializer(doer Doer) Serializer {
-	return &serializer{
+// the potential for deadlocks; calls to any of them from Doer.Do
+// return ErrWouldDeadlock.  The only Options supported are
+// WithWatchdog, WithRepanic, and WithName; any other panics with an
+// error wrapping ErrUnsupportedOption.  The returned Serializer also
+// implements ClosableSerializer.
+func NewSerializer(doer Doer, opts ...Option) Serializer {
+	o := mustOptions("NewSerializer", optWatchdog|optRepanic|optName, opts)
+
+	s := &serializer{
 		doer:    doer,
 		request: make(chan doRequest, requestBuffer),
 		done:    make(chan bool, 1),
 		gonner:  &sync.Once{},
+		watch:   newWatchdog("NewSerializer", o),
+		repanic: newRepanicker(o),
+		name:    o.name,
+		finish:  newCompletion(),
+		mgr:     newGoroutineMarker(),
 	}
+	s.leak = trackLeaks("NewSerializer", s)
+
+	return s
 }
 
 // manager is the manager goroutine.
 func (s *serializer) manager() {
 	defer func() { s.done <- true }()
 
-	for req := range s.request {
-		// Run the request and send back the result
-		if req.result == nil {
-			panicer(s.doer.Do, req.data)
-		} else {
-			req.result <- panicer(s.doer.Do, req.data)
+	s.watch.start()
+	defer s.watch.finish()
+
+	// Mark the goroutine so re-entrant calls can be detected
+	s.mgr.run(func() {
+		for req := range s.request {
+			// Skip requests the caller has given up on
+			if req.ctx != nil && req.ctx.Err() != nil {
+				close(req.result)
+				s.watch.complete()
+				continue
+			}
+
+			// Run the request and send back the result
+			result := nameResult(panicer("NewSerializer", s.doer.Do, req.data), s.name)
+			s.repanic.record(result)
+			if req.result != nil {
+				req.result <- result
+			}
+			s.watch.complete()
 		}
-	}
+	})
+}
+
+// inManager returns true if called from the manager goroutine, that
+// is, from Doer.Do.
+func (s *serializer) inManager() bool {
+	return s.mgr.contains()
 }
 
 // getResult is a helper for Wait to retrieve the result of calling
 // Doer.Finish.  It's called with serializer.gonner to ensure that it
-// only gets called once.
+// only gets called once.  Once the result is saved, it signals
+// completion.
 func (s *serializer) getResult() {
 	s.Lock()
-	defer s.Unlock()
-
 	s.result = s.doer.Finish()
 	s.state = pResult
+	s.Unlock()
+
+	s.finish.fire(s.result, s.repanic.first())
 }
 
 // Call is used to invoke the Doer.Do method of the wrapped Doer.  It
 // may return an error if the Serializer is closed.  Call is
 // synchronous, and will not return until the Doer.Do method has
-// completed.
+// completed.  If Call is called from Doer.Do, it returns
+// ErrWouldDeadlock.
 func (s *serializer) Call(data interface{}) (*Result, error) {
+	// Waiting on the manager from the manager would never return
+	if s.inManager() {
+		return nil, ErrWouldDeadlock
+	}
+
 	s.Lock()
 
 	switch s.state {
@@ -94,11 +149,13 @@ func (s *serializer) Call(data interface{}) (*Result, error) {
 
 	case pClosed, pResult: // Serializer is closed
 		s.Unlock()
+		s.leak.markClosedCall()
 		return nil, ErrClosed
 	}
 
 	// OK, construct a result channel and send the request
 	result := make(chan *Result, 1)
+	s.watch.accept()
 	s.request <- doRequest{
 		data:   data,
 		result: result,
@@ -109,10 +166,72 @@ func (s *serializer) Call(data interface{}) (*Result, error) {
 	return <-result, nil
 }
 
+// CallContext is a variant of Call that gives up waiting for the
+// Doer.Do method to complete when the context is canceled or its
+// deadline expires, returning the context's error.  If the call has
+// not yet been passed to Doer.Do when the caller gives up, it will be
+// skipped entirely.  If CallContext is called from Doer.Do, it
+// returns ErrWouldDeadlock.
+func (s *serializer) CallContext(ctx context.Context, data interface{}) (*Result, error) {
+	// Waiting on the manager from the manager would never return
+	if s.inManager() {
+		return nil, ErrWouldDeadlock
+	}
+
+	s.Lock()
+
+	switch s.state {
+	case pNew: // Need to start the manager
+		go s.manager()
+		s.state = pRunning
+
+	case pClosed, pResult: // Serializer is closed
+		s.Unlock()
+		s.leak.markClosedCall()
+		return nil, ErrClosed
+	}
+
+	// OK, construct a result channel and send the request, giving
+	// up if the context is done first
+	result := make(chan *Result, 1)
+	select {
+	case s.request <- doRequest{
+		ctx:    ctx,
+		data:   data,
+		result: result,
+	}:
+		s.watch.accept()
+	case <-ctx.Done():
+		s.Unlock()
+		return nil, ctx.Err()
+	}
+	s.Unlock()
+
+	// Get the response and return it; a closed channel means the
+	// request was skipped
+	select {
+	case response, ok := <-result:
+		if !ok {
+			return nil, ctx.Err()
+		}
+		return response, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // CallAsync is used to invoke the Doer.Do method, like Call, but it
 // does not block; instead, it returns a CallResult object, which may
-// be queried later for the result of the call.
+// be queried later for the result of the call.  If CallAsync is
+// called from Doer.Do, it returns ErrWouldDeadlock.
 func (s *serializer) CallAsync(data interface{}) (CallResult, error) {
+	// Sending to the manager from the manager would block once the
+	// request buffer fills
+	if s.inManager() {
+		return nil, ErrWouldDeadlock
+	}
+
 	s.Lock()
 
 	switch s.state {
@@ -122,24 +241,39 @@ func (s *serializer) CallAsync(data interface{}) (CallResult, error) {
 
 	case pClosed, pResult: // Serializer is closed
 		s.Unlock()
+		s.leak.markClosedCall()
 		return nil, ErrClosed
 	}
 
-	// OK, construct a result channel and send the request
+	// OK, construct a result channel and send the request; the
+	// context allows ContextCallResult.WaitContext to cancel the request
+	ctx, cancel := context.WithCancel(context.Background())
 	result := make(chan *Result, 1)
+	s.watch.accept()
 	s.request <- doRequest{
+		ctx:    ctx,
 		data:   data,
 		result: result,
 	}
 	s.Unlock()
 
 	// Return a callResult
-	return &callResult{response: result}, nil
+	return &callResult{
+		response: result,
+		cancel:   cancel,
+	}, nil
 }
 
 // CallOnly is used to invoke the Doer.Do method, but it does not
-// block; instead, the result of the call is discarded.
+// block; instead, the result of the call is discarded.  If CallOnly
+// is called from Doer.Do, it returns ErrWouldDeadlock.
 func (s *serializer) CallOnly(data interface{}) error {
+	// Sending to the manager from the manager would block once the
+	// request buffer fills
+	if s.inManager() {
+		return ErrWouldDeadlock
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -149,10 +283,12 @@ func (s *serializer) CallOnly(data interface{}) error {
 		s.state = pRunning
 
 	case pClosed, pResult: // Serializer is closed
+		s.leak.markClosedCall()
 		return ErrClosed
 	}
 
 	// OK, send the request
+	s.watch.accept()
 	s.request <- doRequest{data: data}
 
 	return nil
@@ -161,8 +297,19 @@ func (s *serializer) CallOnly(data interface{}) error {
 // Wait signals the manager goroutine to exit, then waits for it to do
 // so.  The manager will call the Doer.Finish method and return its
 // result to Wait, which will in turn return it to the caller.  The
-// result will be cached to satisfy future calls to Wait.
+// result will be cached to satisfy future calls to Wait.  Wait must
+// not be called from Doer.Do; if it is, it panics with
+// ErrWouldDeadlock, which is captured in the Result of that call.  If
+// the WithRepanic option was used and any call to Doer.Do panicked,
+// Wait panics with the *PanicError describing the first such panic.
 func (s *serializer) Wait() interface{} {
+	// Waiting for the manager from the manager would never return
+	if s.inManager() {
+		panic(ErrWouldDeadlock)
+	}
+
+	s.leak.markWaited()
+
 	s.Lock()
 
 	switch s.state {
@@ -180,39 +327,129 @@ func (s *serializer) Wait() interface{} {
 
 	case pClosed: // Closed, waiting for result
 		s.Unlock()
-		s.gonner.Do(s.getResult)
+
+		// Whoever closed the serializer will get the result
+		<-s.finish.channel()
 
 	case pResult: // Have result, just need to unlock
 		s.Unlock()
 	}
+	s.repanic.check()
 
 	return s.result
 }
 
+// Close signals the manager goroutine to exit like Wait, but without
+// waiting for it to do so.  No further Call* methods may be called;
+// the requests already made are still passed to Doer.Do, and
+// Doer.Finish is called from a separate goroutine once they have
+// been.  Close may be called from Doer.Do.
+func (s *serializer) Close() {
+	s.leak.markWaited()
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch s.state {
+	case pNew: // Haven't even started yet
+		s.state = pClosed
+		go s.gonner.Do(s.getResult)
+
+	case pRunning: // Signal to die, finish once done
+		s.state = pClosed
+		close(s.request)
+		go func() {
+			<-s.done
+			s.gonner.Do(s.getResult)
+		}()
+	}
+}
+
+// Done returns a channel that is closed once Doer.Finish has been
+// called and its result saved.
+func (s *serializer) Done() <-chan struct{} {
+	return s.finish.channel()
+}
+
+// OnComplete registers a function to be called with the result of
+// Doer.Finish once it has been called.  If the result has already
+// been computed, the function is called immediately.
+func (s *serializer) OnComplete(fn func(result interface{}, err error)) {
+	s.finish.onComplete(fn)
+}
+
 // callResult is an implementation of the CallResult interface.
 type callResult struct {
-	response <-chan *Result // The channel we'll get the response on
-	closed   bool           // A boolean flag indicating if the CallResult is closed
+	sync.Mutex
+	response <-chan *Result     // The channel we'll get the response on
+	cancel   context.CancelFunc // Optional function to cancel the request
+	closed   bool               // A boolean flag indicating if the CallResult is closed
+}
+
+// claim is a helper that closes the CallResult and returns the
+// response channel, or nil if the CallResult was already closed.
+func (c *callResult) claim() <-chan *Result {
+	c.Lock()
+	defer c.Unlock()
+
+	// Are we closed?
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.response
 }
 
 // Wait is used to retrieve the result of the call.  The result is not
 // cached in the CallResult object, so subsequent calls to Wait will
 // return nil.
 func (c *callResult) Wait() *Result {
-	// Are we closed?
-	if c.closed {
+	// Close the call result, then wait for the response
+	response := c.claim()
+	if response == nil {
 		return nil
 	}
 
-	// OK, wait for the response and close the call result
-	c.closed = true
-	return <-c.response
+	return <-response
+}
+
+// WaitContext is a variant of Wait that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  If the call was made through
+// Serializer.CallAsync and has not yet been passed to Doer.Do when
+// the caller gives up, it will be skipped entirely; calls made
+// through AsyncWorker.CallAsync are processed regardless.  As with
+// Wait, the result is not cached, and subsequent calls will return
+// nil.
+func (c *callResult) WaitContext(ctx context.Context) (*Result, error) {
+	// Close the call result
+	response := c.claim()
+	if response == nil {
+		return nil, nil
+	}
+
+	// Wait for the response or for the context to be done
+	select {
+	case result := <-response:
+		return result, nil
+
+	case <-ctx.Done():
+		// Caller gave up; skip the request if we can
+		if c.cancel != nil {
+			c.cancel()
+		}
+		return nil, ctx.Err()
+	}
 }
 
 // TryWait is a non-blocking variant of Wait.  It attempts to retrieve
 // the result, and returns the value and a boolean value that
 // indicates whether the result has already been retrieved.
 func (c *callResult) TryWait() (*Result, bool) {
+	c.Lock()
+	defer c.Unlock()
+
 	// Are we closed?
 	if c.closed {
 		return nil, false
@@ -236,12 +473,29 @@ func (c *callResult) TryWait() (*Result, bool) {
 // effectively closes the CallResult; subsequent calls to Wait and
 // TryWait will return nil results.
 func (c *callResult) Channel() <-chan *Result {
+	return c.claim()
+}
+
+// selectChannel returns the channel to select on, or nil if the
+// CallResult has been closed.  Unlike Channel, it does not close the
+// CallResult.
+func (c *callResult) selectChannel() <-chan *Result {
+	c.Lock()
+	defer c.Unlock()
+
 	// Are we closed?
 	if c.closed {
 		return nil
 	}
 
-	// OK, close ourself and return the channel
-	c.closed = true
 	return c.response
 }
+
+// selected is called when the channel returned by selectChannel has
+// been received from.  It closes the CallResult.
+func (c *callResult) selected() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.closed = true
+}
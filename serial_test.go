@@ -15,8 +15,10 @@
 package parallelizer
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -27,6 +29,10 @@ func TestSerializerImplementsSerializer(t *testing.T) {
 	assert.Implements(t, (*Serializer)(nil), &serializer{})
 }
 
+func TestSerializerImplementsContextSerializer(t *testing.T) {
+	assert.Implements(t, (*ContextSerializer)(nil), &serializer{})
+}
+
 func TestNewSerializer(t *testing.T) {
 	doer := &MockDoer{}
 
@@ -38,6 +44,7 @@ func TestNewSerializer(t *testing.T) {
 	assert.NotNil(t, s.request)
 	assert.NotNil(t, s.done)
 	assert.Equal(t, &sync.Once{}, s.gonner)
+	assert.Equal(t, newCompletion(), s.finish)
 }
 
 func TestSerializerManagerBase(t *testing.T) {
@@ -82,6 +89,33 @@ func TestSerializerManagerNilResult(t *testing.T) {
 	doer.AssertExpectations(t)
 }
 
+func TestSerializerManagerSkipsCanceled(t *testing.T) {
+	doer := &MockDoer{}
+	obj := &serializer{
+		doer:    doer,
+		request: make(chan doRequest, 1),
+		done:    make(chan bool, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := make(chan *Result, 1)
+	obj.request <- doRequest{
+		ctx:    ctx,
+		data:   "data",
+		result: result,
+	}
+	close(obj.request)
+
+	obj.manager()
+
+	response, ok := <-result
+	assert.Nil(t, response)
+	assert.False(t, ok)
+	done := <-obj.done
+	assert.True(t, done)
+	doer.AssertExpectations(t)
+}
+
 func TestSerializerGetResult(t *testing.T) {
 	doer := &MockDoer{}
 	doer.On("Finish").Return("result")
@@ -162,6 +196,101 @@ func TestSerializerCallResult(t *testing.T) {
 	assert.Equal(t, pResult, obj.state)
 }
 
+func TestSerializerCallContextNew(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return("result")
+	obj := &serializer{
+		doer:    doer,
+		request: make(chan doRequest, 1),
+		done:    make(chan bool, 1),
+	}
+
+	result, err := obj.CallContext(context.Background(), "data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	assert.Equal(t, pRunning, obj.state)
+	close(obj.request) // kill manager
+	done := <-obj.done
+	assert.True(t, done)
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCallContextCanceled(t *testing.T) {
+	doer := &MockDoer{}
+	obj := &serializer{
+		state:   pRunning,
+		doer:    doer,
+		request: make(chan doRequest, 1),
+		done:    make(chan bool, 1),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	result, err := obj.CallContext(ctx, "data")
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, result)
+	req := <-obj.request
+	assert.Equal(t, "data", req.data)
+	assert.Same(t, ctx, req.ctx)
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCallContextSendCanceled(t *testing.T) {
+	doer := &MockDoer{}
+	obj := &serializer{
+		state:   pRunning,
+		doer:    doer,
+		request: make(chan doRequest),
+		done:    make(chan bool, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := obj.CallContext(ctx, "data")
+
+	assert.Same(t, context.Canceled, err)
+	assert.Nil(t, result)
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCallContextSkipped(t *testing.T) {
+	doer := &MockDoer{}
+	obj := &serializer{
+		doer:    doer,
+		request: make(chan doRequest, 1),
+		done:    make(chan bool, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	doer.On("Do", "block").Return("blocked").Run(func(args mock.Arguments) {
+		cancel()
+	})
+	_, err := obj.CallAsync("block")
+	require.NoError(t, err)
+
+	result, err := obj.CallContext(ctx, "data")
+
+	assert.Same(t, context.Canceled, err)
+	assert.Nil(t, result)
+	close(obj.request) // kill manager
+	done := <-obj.done
+	assert.True(t, done)
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCallContextClosed(t *testing.T) {
+	obj := &serializer{
+		state: pClosed,
+	}
+
+	result, err := obj.CallContext(context.Background(), "data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Nil(t, result)
+	assert.Equal(t, pClosed, obj.state)
+}
+
 func TestSerializerCallAsyncNew(t *testing.T) {
 	doer := &MockDoer{}
 	doer.On("Do", "data").Return("result")
@@ -314,6 +443,28 @@ func TestSerializerWaitNew(t *testing.T) {
 	doer.AssertExpectations(t)
 }
 
+func TestSerializerRepanic(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	doer.On("Finish").Return("result")
+	obj := NewSerializer(doer, WithRepanic())
+	require.NoError(t, obj.CallOnly("data"))
+
+	defer func() {
+		panicData := recover()
+		require.IsType(t, &PanicError{}, panicData)
+		err := panicData.(*PanicError)
+		assert.Equal(t, "boom", err.Value)
+		assert.Equal(t, "data", err.Data)
+		assert.Equal(t, "NewSerializer", err.Source)
+		doer.AssertExpectations(t)
+	}()
+	obj.Wait()
+	assert.Fail(t, "Wait did not panic")
+}
+
 func TestSerializerWaitRunning(t *testing.T) {
 	doer := &MockDoer{}
 	obj := &serializer{
@@ -342,10 +493,12 @@ func TestSerializerWaitClosed(t *testing.T) {
 		state:  pClosed,
 		doer:   doer,
 		gonner: &sync.Once{},
+		finish: newCompletion(),
 	}
 	doer.On("Finish").Return("result").Run(func(args mock.Arguments) {
 		assert.Equal(t, pClosed, obj.state)
 	})
+	go obj.gonner.Do(obj.getResult)
 
 	result := obj.Wait()
 
@@ -375,6 +528,10 @@ func TestCallResultImplementsCallResult(t *testing.T) {
 	assert.Implements(t, (*CallResult)(nil), &callResult{})
 }
 
+func TestCallResultImplementsContextCallResult(t *testing.T) {
+	assert.Implements(t, (*ContextCallResult)(nil), &callResult{})
+}
+
 func TestCallResultWaitBase(t *testing.T) {
 	response := make(chan *Result, 1)
 	response <- &Result{Result: "result"}
@@ -386,6 +543,30 @@ func TestCallResultWaitBase(t *testing.T) {
 	assert.True(t, obj.closed)
 }
 
+func TestCallResultWaitConcurrent(t *testing.T) {
+	response := make(chan *Result, 1)
+	obj := &callResult{response: response}
+	results := make(chan *Result, 2)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- obj.Wait()
+		}()
+	}
+
+	response <- &Result{Result: "result"}
+	wg.Wait()
+
+	close(results)
+	received := []*Result{}
+	for result := range results {
+		received = append(received, result)
+	}
+	assert.ElementsMatch(t, []*Result{{Result: "result"}, nil}, received)
+}
+
 func TestCallResultWaitClosed(t *testing.T) {
 	obj := &callResult{closed: true}
 
@@ -395,6 +576,47 @@ func TestCallResultWaitClosed(t *testing.T) {
 	assert.True(t, obj.closed)
 }
 
+func TestCallResultWaitContextBase(t *testing.T) {
+	response := make(chan *Result, 1)
+	response <- &Result{Result: "result"}
+	obj := &callResult{response: response}
+
+	result, err := obj.WaitContext(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	assert.True(t, obj.closed)
+}
+
+func TestCallResultWaitContextCanceled(t *testing.T) {
+	canceled := false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := &callResult{
+		response: make(chan *Result, 1),
+		cancel: func() {
+			canceled = true
+		},
+	}
+
+	result, err := obj.WaitContext(ctx)
+
+	assert.Same(t, context.Canceled, err)
+	assert.Nil(t, result)
+	assert.True(t, canceled)
+	assert.True(t, obj.closed)
+}
+
+func TestCallResultWaitContextClosed(t *testing.T) {
+	obj := &callResult{closed: true}
+
+	result, err := obj.WaitContext(context.Background())
+
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+	assert.True(t, obj.closed)
+}
+
 func TestCallResultTryWaitReady(t *testing.T) {
 	response := make(chan *Result, 1)
 	response <- &Result{Result: "result"}
@@ -446,3 +668,105 @@ func TestCallResultChannelClosed(t *testing.T) {
 	assert.Nil(t, channel)
 	assert.True(t, obj.closed)
 }
+
+func TestSerializerImplementsClosableSerializer(t *testing.T) {
+	assert.Implements(t, (*ClosableSerializer)(nil), &serializer{})
+}
+
+func TestSerializerClose(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return("result")
+	doer.On("Finish").Return("finished")
+	obj := NewSerializer(doer).(ClosableSerializer)
+	got := make(chan interface{}, 1)
+	obj.OnComplete(func(result interface{}, err error) {
+		assert.NoError(t, err)
+		got <- result
+	})
+	require.NoError(t, obj.CallOnly("data"))
+
+	obj.Close()
+
+	<-obj.Done()
+	assert.Equal(t, "finished", <-got)
+	assert.Same(t, ErrClosed, obj.CallOnly("data"))
+	assert.Equal(t, "finished", obj.Wait())
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCloseNew(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Finish").Return("finished")
+	obj := NewSerializer(doer).(ClosableSerializer)
+
+	obj.Close()
+
+	assert.Equal(t, "finished", obj.Wait())
+	assert.True(t, isClosed(obj.Done()))
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCloseFromDo(t *testing.T) {
+	doer := &MockDoer{}
+	var obj ClosableSerializer
+	doer.On("Do", "data").Return("result").Run(func(args mock.Arguments) {
+		obj.Close()
+	})
+	doer.On("Finish").Return("finished")
+	obj = NewSerializer(doer).(ClosableSerializer)
+
+	result, err := obj.Call("data")
+
+	require.NoError(t, err)
+	assert.Equal(t, "result", result.Result)
+	<-obj.Done()
+	assert.Equal(t, "finished", obj.Wait())
+	doer.AssertExpectations(t)
+}
+
+func TestSerializerCloseRepanic(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	doer.On("Finish").Return("finished")
+	obj := NewSerializer(doer, WithRepanic()).(ClosableSerializer)
+	require.NoError(t, obj.CallOnly("data"))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var got error
+	obj.OnComplete(func(result interface{}, err error) {
+		got = err
+		wg.Done()
+	})
+
+	obj.Close()
+
+	wg.Wait()
+	require.IsType(t, &PanicError{}, got)
+	assert.Equal(t, "boom", got.(*PanicError).Value)
+}
+
+func TestSerializerName(t *testing.T) {
+	doer := &MockDoer{}
+	doer.On("Do", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	doer.On("Finish").Return("result")
+	obj := NewSerializer(doer, WithName("fetch"))
+
+	result, err := obj.Call("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "NewSerializer", result.PanicError.Source)
+	assert.Equal(t, "fetch", result.PanicError.Name)
+	obj.Wait()
+	doer.AssertExpectations(t)
+}
+
+func TestNewSerializerUnsupportedOption(t *testing.T) {
+	assert.PanicsWithError(t, "Option not supported: NewSerializer does not support WithPool", func() {
+		NewSerializer(&MockDoer{}, WithPool(0))
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import "sync"
+
+// shardSet tracks the shards used to integrate results for a
+// ShardedRunner.
+type shardSet struct {
+	sync.Mutex
+	runner ShardedRunner // The runner creating the shards
+	all    []interface{} // All the shards created
+	free   []interface{} // The shards not in use
+	busy   sync.RWMutex  // Held for reading while integrating
+}
+
+// newShardSet constructs a shardSet if the runner implements
+// ShardedRunner, or returns nil otherwise.
+func newShardSet(runner Runner) *shardSet {
+	sr, ok := runner.(ShardedRunner)
+	if !ok {
+		return nil
+	}
+
+	return &shardSet{runner: sr}
+}
+
+// get takes a shard out of the set of free shards, creating one if
+// none is available.
+func (s *shardSet) get() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	// Reuse a free shard
+	if n := len(s.free); n > 0 {
+		shard := s.free[n-1]
+		s.free = s.free[:n-1]
+		return shard
+	}
+
+	// Create a new one
+	shard := s.runner.NewShard()
+	s.all = append(s.all, shard)
+
+	return shard
+}
+
+// put returns a shard to the set of free shards.
+func (s *shardSet) put(shard interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.free = append(s.free, shard)
+}
+
+// integrate integrates a result into a free shard.  If the done
+// function is not nil, it is called once the result has been
+// integrated, before a checkpoint may merge the shards.
+func (s *shardSet) integrate(worker Worker, result *Result, done func()) {
+	s.busy.RLock()
+	defer s.busy.RUnlock()
+
+	shard := s.get()
+	defer s.put(shard)
+
+	s.runner.IntegrateShard(worker, shard, result)
+	if done != nil {
+		done()
+	}
+}
+
+// merge passes all the shards to ShardedRunner.Merge, then discards
+// them.
+func (s *shardSet) merge() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.runner.Merge(s.all)
+	s.all = nil
+	s.free = nil
+}
+
+// shardLock is a sync.Locker for use by the checkpointer in place of
+// the mutex serializing Runner.Integrate.  Locking it waits for calls
+// to ShardedRunner.IntegrateShard to finish, locks out further calls,
+// and merges the shards, so that the state saved by the checkpoint
+// includes them.
+type shardLock shardSet
+
+// Lock locks out calls to ShardedRunner.IntegrateShard and merges the
+// shards, if there are any.
+func (l *shardLock) Lock() {
+	s := (*shardSet)(l)
+
+	s.busy.Lock()
+	if len(s.all) > 0 {
+		s.merge()
+	}
+}
+
+// Unlock allows calls to ShardedRunner.IntegrateShard to resume.
+func (l *shardLock) Unlock() {
+	l.busy.Unlock()
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sumShard is a shard used by shardRunner.
+type sumShard struct {
+	busy int32 // Non-zero while the shard is in use
+	sum  int   // The sum of the integrated results
+}
+
+// shardRunner is a ShardedRunner that sums its results.  Results less
+// than 100 cause a recursive call with the result plus 100.
+type shardRunner struct {
+	sum    int
+	shards int
+	shared int32
+}
+
+func (r *shardRunner) Run(data interface{}) interface{} {
+	return data
+}
+
+func (r *shardRunner) Integrate(worker Worker, result *Result) {
+	panic("Integrate called")
+}
+
+func (r *shardRunner) Result() interface{} {
+	return r.sum
+}
+
+func (r *shardRunner) NewShard() interface{} {
+	return &sumShard{}
+}
+
+func (r *shardRunner) IntegrateShard(worker Worker, shard interface{}, result *Result) {
+	s := shard.(*sumShard)
+	if !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+		atomic.AddInt32(&r.shared, 1)
+	}
+	defer atomic.StoreInt32(&s.busy, 0)
+
+	n := result.Result.(int)
+	s.sum += n
+	if n < 100 {
+		worker.Call(n + 100)
+	}
+}
+
+func (r *shardRunner) Merge(shards []interface{}) {
+	r.shards = len(shards)
+	for _, shard := range shards {
+		r.sum += shard.(*sumShard).sum
+	}
+}
+
+func TestNewShardSetNotSharded(t *testing.T) {
+	result := newShardSet(&MockRunner{})
+
+	assert.Nil(t, result)
+}
+
+func TestNewShardSetSharded(t *testing.T) {
+	runner := &shardRunner{}
+
+	result := newShardSet(runner)
+
+	assert.Equal(t, &shardSet{runner: runner}, result)
+}
+
+func TestShardSetGetNew(t *testing.T) {
+	obj := &shardSet{runner: &shardRunner{}}
+
+	result := obj.get()
+
+	assert.Equal(t, &sumShard{}, result)
+	assert.Equal(t, []interface{}{result}, obj.all)
+	assert.Len(t, obj.free, 0)
+}
+
+func TestShardSetGetFree(t *testing.T) {
+	shard := &sumShard{}
+	obj := &shardSet{
+		runner: &shardRunner{},
+		all:    []interface{}{shard},
+		free:   []interface{}{shard},
+	}
+
+	result := obj.get()
+
+	assert.Same(t, shard, result)
+	assert.Len(t, obj.all, 1)
+	assert.Len(t, obj.free, 0)
+}
+
+func TestShardSetPut(t *testing.T) {
+	shard := &sumShard{}
+	obj := &shardSet{}
+
+	obj.put(shard)
+
+	assert.Equal(t, []interface{}{shard}, obj.free)
+}
+
+func TestShardSetIntegrate(t *testing.T) {
+	worker := &MockWorker{}
+	worker.On("Call", 105).Return(nil)
+	obj := &shardSet{runner: &shardRunner{}}
+
+	obj.integrate(worker, &Result{Result: 5}, nil)
+
+	require.Len(t, obj.all, 1)
+	assert.Equal(t, obj.all, obj.free)
+	assert.Equal(t, 5, obj.all[0].(*sumShard).sum)
+	worker.AssertExpectations(t)
+}
+
+func TestShardSetIntegrateDone(t *testing.T) {
+	worker := &MockWorker{}
+	worker.On("Call", 105).Return(nil)
+	obj := &shardSet{runner: &shardRunner{}}
+	sums := []int{}
+
+	obj.integrate(worker, &Result{Result: 5}, func() {
+		sums = append(sums, obj.all[0].(*sumShard).sum)
+	})
+
+	assert.Equal(t, []int{5}, sums)
+	worker.AssertExpectations(t)
+}
+
+func TestShardSetMerge(t *testing.T) {
+	runner := &shardRunner{}
+	obj := &shardSet{
+		runner: runner,
+		all:    []interface{}{&sumShard{sum: 1}, &sumShard{sum: 2}},
+	}
+
+	obj.merge()
+
+	assert.Equal(t, 3, runner.sum)
+	assert.Equal(t, 2, runner.shards)
+	assert.Nil(t, obj.all)
+	assert.Nil(t, obj.free)
+}
+
+func TestShardLockMerges(t *testing.T) {
+	runner := &shardRunner{}
+	shard := &sumShard{sum: 1}
+	obj := &shardSet{
+		runner: runner,
+		all:    []interface{}{shard},
+		free:   []interface{}{shard},
+	}
+
+	(*shardLock)(obj).Lock()
+	locked := make(chan struct{})
+	go func() {
+		obj.integrate(&MockWorker{}, &Result{Result: 100}, nil)
+		close(locked)
+	}()
+	integrated := isClosed(locked)
+	(*shardLock)(obj).Unlock()
+	<-locked
+
+	assert.False(t, integrated)
+	assert.Equal(t, 1, runner.sum)
+	assert.Equal(t, 1, runner.shards)
+	require.Len(t, obj.all, 1)
+	assert.Equal(t, 100, obj.all[0].(*sumShard).sum)
+}
+
+func TestShardLockEmpty(t *testing.T) {
+	runner := &shardRunner{}
+	obj := &shardSet{runner: runner}
+
+	(*shardLock)(obj).Lock()
+	(*shardLock)(obj).Unlock()
+
+	assert.Equal(t, 0, runner.shards)
+}
+
+func TestGoWorkerSharded(t *testing.T) {
+	runner := &shardRunner{}
+	obj := NewGoWorker(runner, 4)
+	expected := 0
+	for i := 0; i < 100; i++ {
+		require.NoError(t, obj.Call(i))
+		expected += 2*i + 100
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.GreaterOrEqual(t, runner.shards, 1)
+	assert.Equal(t, int32(0), runner.shared)
+}
+
+func TestSynchronousWorkerSharded(t *testing.T) {
+	runner := &shardRunner{}
+	obj := NewSynchronousWorker(runner)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1090, result)
+	assert.Equal(t, 1, runner.shards)
+}
@@ -0,0 +1,553 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// stealDeque is a double-ended queue of work items.  Its owner pushes
+// and pops items at the bottom, while other goroutines steal items
+// from the top.
+type stealDeque struct {
+	sync.Mutex
+	items []*workItem // The items in the deque, top first
+}
+
+// push adds an item to the bottom of the deque.
+func (d *stealDeque) push(item *workItem) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.items = append(d.items, item)
+}
+
+// pop removes the item at the bottom of the deque, the one most
+// recently pushed.  It returns nil if the deque is empty.
+func (d *stealDeque) pop() *workItem {
+	d.Lock()
+	defer d.Unlock()
+
+	n := len(d.items)
+	if n == 0 {
+		return nil
+	}
+	item := d.items[n-1]
+	d.items[n-1] = nil
+	d.items = d.items[:n-1]
+
+	return item
+}
+
+// steal removes the item at the top of the deque, the one least
+// recently pushed.  It returns nil if the deque is empty.
+func (d *stealDeque) steal() *workItem {
+	d.Lock()
+	defer d.Unlock()
+
+	if len(d.items) == 0 {
+		return nil
+	}
+	item := d.items[0]
+	d.items[0] = nil
+	d.items = d.items[1:]
+
+	return item
+}
+
+// stealingWorker is an implementation of the Worker interface that
+// runs data items in a fixed set of goroutines, each with its own
+// deque of work items, which steal work from each other when idle.
+type stealingWorker struct {
+	queued     int64            // Items in the deques; atomic, so first for alignment
+	sync.Mutex                  // Protects the state
+	state      pState           // State of the worker
+	runner     Runner           // The runner to be invoked by the workers
+	serial     *sync.Mutex      // Mutex serializing Runner.Integrate
+	inbox      *stealDeque      // Items submitted by Call
+	procs      []*stealProc     // The worker goroutines
+	sleeping   int32            // Goroutines looking for work; accessed atomically
+	park       *sync.Cond       // Signals goroutines looking for work
+	stopping   bool             // Tells goroutines to exit; protected by park
+	pending    *sync.WaitGroup  // Wait group for items not yet integrated
+	running    *sync.WaitGroup  // Wait group for the worker goroutines
+	gonner     *sync.Once       // Ensures the result is computed once
+	result     interface{}      // The result from calling Runner.Result
+	leak       *leakRecord      // Leak tracking record
+	active     *goroutineMarker // Marks the worker goroutines
+	repanic    *repanicker      // Optional re-raising of panics in Wait
+	name       string           // Name given with WithName
+	shards     *shardSet        // Shards for a ShardedRunner
+	idle       *idleTracker     // Tracks items for IdleWorker
+	done       *completion      // Signals completion for ClosableWorker
+}
+
+// stealProc describes one of the goroutines of a stealing worker.  It
+// is also an implementation of the Worker interface that is passed to
+// Runner.Integrate, so that items it submits are pushed onto the
+// goroutine's own deque.
+type stealProc struct {
+	worker *stealingWorker // The worker the goroutine belongs to
+	index  int             // The index of the goroutine
+	deque  *stealDeque     // The goroutine's own deque
+	local  *workerLocal    // Worker-local state for a LocalRunner
+}
+
+// NewStealingWorker constructs a worker for recursive, divide and
+// conquer workloads, such as walking a directory tree or exploring a
+// graph.  The worker runs the specified number of goroutines, or the
+// number of CPUs if that number is less than or equal to 0, each with
+// its own deque of data items.  Data items submitted by the
+// goroutine, through the spawn function passed to
+// SpawningRunner.RunWithSpawner or by calls to Worker.Call from
+// Runner.Integrate, are pushed onto its deque and are run most recent
+// first, preserving locality; data items submitted from other
+// goroutines are placed on a shared queue.  A goroutine with no work
+// of its own takes items from the shared queue, or steals the least
+// recent items from the other goroutines.  Runner.Integrate is called
+// from the goroutine that ran the data item, serialized with other
+// calls to Runner.Integrate.  Of the options, only WithRepanic and
+// WithName are supported; any other panics with an error wrapping
+// ErrUnsupportedOption.  The returned Worker also implements
+// AsyncWorker, IdleWorker, and ClosableWorker.
+func NewStealingWorker(runner Runner, workers int, opts ...Option) Worker {
+	checkRunner(runner)
+	o := mustOptions("NewStealingWorker", optRepanic|optName, opts)
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	w := &stealingWorker{
+		runner:  runner,
+		serial:  &sync.Mutex{},
+		inbox:   &stealDeque{},
+		procs:   make([]*stealProc, workers),
+		park:    sync.NewCond(&sync.Mutex{}),
+		pending: &sync.WaitGroup{},
+		running: &sync.WaitGroup{},
+		gonner:  &sync.Once{},
+		active:  newGoroutineMarker(),
+		repanic: newRepanicker(o),
+		name:    o.name,
+		shards:  newShardSet(runner),
+		idle:    newIdleTracker(),
+		done:    newCompletion(),
+	}
+	for i := range w.procs {
+		w.procs[i] = &stealProc{
+			worker: w,
+			index:  i,
+			deque:  &stealDeque{},
+			local:  newWorkerLocal(runner),
+		}
+	}
+	w.leak = trackLeaks("NewStealingWorker", w)
+
+	return w
+}
+
+// push pushes an item onto a deque and wakes a goroutine looking for
+// work, if there is one.  The item must already have been added to
+// the pending wait group.
+func (w *stealingWorker) push(deque *stealDeque, item *workItem) {
+	deque.push(item)
+
+	// The goroutines increment sleeping before checking queued,
+	// so one side or the other sees the change
+	atomic.AddInt64(&w.queued, 1)
+	if atomic.LoadInt32(&w.sleeping) > 0 {
+		w.park.L.Lock()
+		w.park.Signal()
+		w.park.L.Unlock()
+	}
+}
+
+// sleep waits until there may be an item to work.  It returns false
+// if the goroutine should exit.
+func (w *stealingWorker) sleep() bool {
+	w.park.L.Lock()
+	defer w.park.L.Unlock()
+
+	atomic.AddInt32(&w.sleeping, 1)
+	defer atomic.AddInt32(&w.sleeping, -1)
+
+	for atomic.LoadInt64(&w.queued) <= 0 {
+		if w.stopping {
+			return false
+		}
+		w.park.Wait()
+	}
+
+	return true
+}
+
+// stop causes the goroutines to exit once no work remains, and waits
+// for them to exit.
+func (w *stealingWorker) stop() {
+	w.park.L.Lock()
+	w.stopping = true
+	w.park.Broadcast()
+	w.park.L.Unlock()
+
+	w.running.Wait()
+}
+
+// getResult is a helper for Wait to retrieve the result.  It's called
+// with stealingWorker.gonner to ensure that it only gets called once.
+// Once the result is saved, it signals completion.
+func (w *stealingWorker) getResult() {
+	w.pending.Wait()
+	w.stop()
+	if w.shards != nil {
+		w.shards.merge()
+	}
+
+	w.Lock()
+	w.result = w.runner.Result()
+	w.state = pResult
+	w.Unlock()
+
+	w.done.fire(w.result, w.repanic.first())
+}
+
+// call is a helper for Call and CallAsync that places a work item on
+// the shared queue.
+func (w *stealingWorker) call(item *workItem) error {
+	// Check the state
+	w.Lock()
+	switch w.state {
+	case pNew: // Need to start up
+		w.state = pRunning
+		for _, p := range w.procs {
+			w.running.Add(1)
+			go p.loop()
+		}
+
+	case pClosed, pResult: // Oh, we're closed
+		w.Unlock()
+		w.leak.markClosedCall()
+		return ErrClosed
+	}
+	w.pending.Add(1)
+	w.idle.add()
+	w.Unlock()
+
+	w.push(w.inbox, item)
+
+	return nil
+}
+
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  It may return an error if the worker has
+// been shut down through a call to Wait.
+func (w *stealingWorker) Call(data interface{}) error {
+	return w.call(&workItem{data: data})
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (w *stealingWorker) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	if err := w.call(item); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Wait is called to shut down the worker and return the final result;
+// it will block the caller until all data, including all spawned data,
+// has been processed and all worker goroutines have stopped.  Note
+// that the final result, generated by Runner.Result, is saved by
+// Worker to satisfy later calls to Wait.  If Wait is called before any
+// calls to Call, the worker will go straight to a stopped state, and
+// no further Call calls may be made; no error will be returned in
+// that case.  If Wait is called from Runner.Run or Runner.Integrate,
+// it returns ErrWouldDeadlock.  If the WithRepanic option was used and
+// any call to Runner.Run panicked, Wait panics with the *PanicError
+// describing the first such panic.
+func (w *stealingWorker) Wait() (interface{}, error) {
+	// Waiting from a worker goroutine would wait on itself
+	if w.active.contains() {
+		return nil, ErrWouldDeadlock
+	}
+
+	w.leak.markWaited()
+
+	// Close the worker
+	w.Lock()
+	if w.state == pNew || w.state == pRunning {
+		w.state = pClosed
+	}
+	w.Unlock()
+
+	w.gonner.Do(w.getResult)
+	w.repanic.check()
+
+	return w.result, nil
+}
+
+// Close shuts down the worker like Wait, but without waiting for it to
+// finish.  Calls to Call on the worker return ErrClosed; only the
+// Worker passed to Runner.Integrate, and the spawner passed to
+// SpawningRunner.RunWithSpawner, still accept data.  The data already
+// submitted, including all spawned data, continues to be processed,
+// and Runner.Result is called from a separate goroutine once it has
+// been.
+func (w *stealingWorker) Close() {
+	w.leak.markWaited()
+
+	w.Lock()
+	defer w.Unlock()
+
+	// Close the worker, if it hasn't been already
+	switch w.state {
+	case pNew, pRunning:
+		w.state = pClosed
+		go w.gonner.Do(w.getResult)
+	}
+}
+
+// Done returns a channel that is closed once Runner.Result has been
+// called and its result saved.
+func (w *stealingWorker) Done() <-chan struct{} {
+	return w.done.channel()
+}
+
+// OnComplete registers a function to be called with the final result
+// and error once Runner.Result has been called.  If the result has
+// already been computed, the function is called immediately.
+func (w *stealingWorker) OnComplete(fn func(result interface{}, err error)) {
+	w.done.onComplete(fn)
+}
+
+// Flush blocks until the worker is idle; that is, until every data
+// item accepted by Call, including all spawned data, has been passed
+// to Runner.Integrate.  Unlike Wait, it leaves the worker open for
+// further calls to Call.  It is equivalent to WaitIdle with a
+// background context.
+func (w *stealingWorker) Flush() error {
+	return w.WaitIdle(context.Background())
+}
+
+// WaitIdle is a variant of Flush that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  If WaitIdle is called from Runner.Run or
+// Runner.Integrate, it returns ErrWouldDeadlock.
+func (w *stealingWorker) WaitIdle(ctx context.Context) error {
+	// Waiting from a worker goroutine would wait on itself
+	if w.active.contains() {
+		return ErrWouldDeadlock
+	}
+
+	return w.idle.wait(ctx)
+}
+
+// Idle returns a channel that is closed once the worker is idle.  If
+// the worker is idle when Idle is called, the channel is already
+// closed.
+func (w *stealingWorker) Idle() <-chan struct{} {
+	return w.idle.channel()
+}
+
+// loop is the main loop of a worker goroutine.
+func (p *stealProc) loop() {
+	defer p.worker.running.Done()
+
+	// Tear down the worker-local state on exit
+	defer p.local.close()
+
+	// Mark the goroutine so re-entrant waits can be detected
+	p.worker.active.run(func() {
+		for {
+			item := p.next()
+			if item == nil {
+				return
+			}
+
+			p.work(item)
+		}
+	})
+}
+
+// next retrieves the next item to work: the most recent item on the
+// goroutine's own deque, the least recent item on the shared queue,
+// or the least recent item on another goroutine's deque, in that
+// order of preference.  If no item is available, it waits for one.
+// It returns nil if the goroutine should exit.
+func (p *stealProc) next() *workItem {
+	w := p.worker
+	for {
+		if item := p.find(); item != nil {
+			atomic.AddInt64(&w.queued, -1)
+			return item
+		}
+
+		if !w.sleep() {
+			return nil
+		}
+	}
+}
+
+// find looks for an item to work, without waiting.
+func (p *stealProc) find() *workItem {
+	w := p.worker
+
+	// Check our own deque, then the shared queue
+	if item := p.deque.pop(); item != nil {
+		return item
+	}
+	if item := w.inbox.steal(); item != nil {
+		return item
+	}
+
+	// Steal from the others, starting with our neighbor
+	for i := 1; i < len(w.procs); i++ {
+		victim := w.procs[(p.index+i)%len(w.procs)]
+		if item := victim.deque.steal(); item != nil {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// spawn submits a child item onto the goroutine's own deque.  It is
+// passed to SpawningRunner.RunWithSpawner.
+func (p *stealProc) spawn(data interface{}) {
+	p.submit(&workItem{data: data, spawn: true})
+}
+
+// submit pushes a work item onto the goroutine's own deque.  This is
+// only called while an item is being worked, so the pending wait
+// group is not zero.
+func (p *stealProc) submit(item *workItem) {
+	p.worker.pending.Add(1)
+	p.worker.idle.add()
+	p.worker.push(p.deque, item)
+}
+
+// work runs the runner with an item, then integrates the result.
+func (p *stealProc) work(item *workItem) {
+	w := p.worker
+	defer w.pending.Done()
+	defer w.idle.done()
+
+	// Run the runner
+	result := nameResult(runItem("NewStealingWorker", w.runner, runHooks{
+		local: p.local,
+		spawn: p.spawn,
+		emit:  p.emit,
+	}, item.data), w.name)
+
+	// Integrate the result
+	w.repanic.record(result)
+	p.integrate(result)
+	item.resolve(result)
+}
+
+// emit integrates a partial result emitted by
+// StreamingRunner.RunStreaming.
+func (p *stealProc) emit(result interface{}) {
+	p.integrate(&Result{Result: result, Partial: true})
+}
+
+// integrate passes a result to Runner.Integrate, serialized with the
+// other goroutines, or to ShardedRunner.IntegrateShard.
+func (p *stealProc) integrate(result *Result) {
+	w := p.worker
+	if w.shards != nil {
+		w.shards.integrate(p, result, nil)
+		return
+	}
+
+	w.serial.Lock()
+	defer w.serial.Unlock()
+
+	w.runner.Integrate(p, result)
+}
+
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  Data submitted from Runner.Integrate is
+// pushed onto the deque of the goroutine calling it, and is accepted
+// even if Wait has been called.
+func (p *stealProc) Call(data interface{}) error {
+	p.submit(&workItem{data: data})
+
+	return nil
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (p *stealProc) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	p.submit(item)
+
+	return cr, nil
+}
+
+// Wait may not be called from Runner.Integrate; it returns
+// ErrWouldDeadlock.
+func (p *stealProc) Wait() (interface{}, error) {
+	return nil, ErrWouldDeadlock
+}
+
+// Flush may not be called from Runner.Integrate; it returns
+// ErrWouldDeadlock.
+func (p *stealProc) Flush() error {
+	return ErrWouldDeadlock
+}
+
+// WaitIdle may not be called from Runner.Integrate; it returns
+// ErrWouldDeadlock.
+func (p *stealProc) WaitIdle(ctx context.Context) error {
+	return ErrWouldDeadlock
+}
+
+// Idle returns a channel that is closed once the worker is idle.
+func (p *stealProc) Idle() <-chan struct{} {
+	return p.worker.Idle()
+}
+
+// Close shuts down the worker without waiting for it to finish.  Data
+// submitted from Runner.Integrate is still accepted.
+func (p *stealProc) Close() {
+	p.worker.Close()
+}
+
+// Done returns a channel that is closed once Runner.Result has been
+// called and its result saved.
+func (p *stealProc) Done() <-chan struct{} {
+	return p.worker.Done()
+}
+
+// OnComplete registers a function to be called with the final result
+// and error once Runner.Result has been called.
+func (p *stealProc) OnComplete(fn func(result interface{}, err error)) {
+	p.worker.OnComplete(fn)
+}
@@ -0,0 +1,484 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// treeRunner is a SpawningRunner that walks a binary tree of the
+// depth passed as data, counting the nodes and recording the
+// goroutines visiting the leaves.
+type treeRunner struct {
+	count int
+	ids   sync.Map
+}
+
+func (r *treeRunner) Run(data interface{}) interface{} {
+	panic("Run called")
+}
+
+func (r *treeRunner) RunWithSpawner(data interface{}, spawn func(data interface{})) interface{} {
+	depth := data.(int)
+	if depth > 0 {
+		spawn(depth - 1)
+		spawn(depth - 1)
+	} else {
+		r.ids.Store(goid(), true)
+		time.Sleep(time.Millisecond)
+	}
+
+	return 1
+}
+
+func (r *treeRunner) Integrate(worker Worker, result *Result) {
+	r.count += result.Result.(int)
+}
+
+func (r *treeRunner) Result() interface{} {
+	return r.count
+}
+
+func TestStealDequePushPop(t *testing.T) {
+	item1 := &workItem{data: 1}
+	item2 := &workItem{data: 2}
+	obj := &stealDeque{}
+	obj.push(item1)
+	obj.push(item2)
+
+	result1 := obj.pop()
+	result2 := obj.pop()
+	result3 := obj.pop()
+
+	assert.Same(t, item2, result1)
+	assert.Same(t, item1, result2)
+	assert.Nil(t, result3)
+}
+
+func TestStealDequePushSteal(t *testing.T) {
+	item1 := &workItem{data: 1}
+	item2 := &workItem{data: 2}
+	obj := &stealDeque{}
+	obj.push(item1)
+	obj.push(item2)
+
+	result1 := obj.steal()
+	result2 := obj.steal()
+	result3 := obj.steal()
+
+	assert.Same(t, item1, result1)
+	assert.Same(t, item2, result2)
+	assert.Nil(t, result3)
+}
+
+func TestNewStealingWorkerBase(t *testing.T) {
+	runner := &MockRunner{}
+
+	result := NewStealingWorker(runner, 3)
+
+	require.IsType(t, &stealingWorker{}, result)
+	obj := result.(*stealingWorker)
+	assert.Same(t, runner, obj.runner)
+	assert.Nil(t, obj.repanic)
+	require.Len(t, obj.procs, 3)
+	for i, p := range obj.procs {
+		assert.Same(t, obj, p.worker)
+		assert.Equal(t, i, p.index)
+		assert.NotNil(t, p.deque)
+	}
+}
+
+func TestNewStealingWorkerDefault(t *testing.T) {
+	runner := &treeRunner{}
+
+	result := NewStealingWorker(runner, 0, WithRepanic())
+
+	require.IsType(t, &stealingWorker{}, result)
+	obj := result.(*stealingWorker)
+	assert.NotNil(t, obj.repanic)
+	assert.Len(t, obj.procs, runtime.NumCPU())
+}
+
+func TestNewStealingWorkerSpawningStreaming(t *testing.T) {
+	assert.PanicsWithValue(t, ErrSpawningStreaming, func() {
+		NewStealingWorker(&spawnStreamRunner{}, 1)
+	})
+}
+
+func TestStealProcFindOrder(t *testing.T) {
+	obj := NewStealingWorker(&MockRunner{}, 3).(*stealingWorker)
+	own := &workItem{data: "own"}
+	shared := &workItem{data: "shared"}
+	stolen1 := &workItem{data: "stolen1"}
+	stolen2 := &workItem{data: "stolen2"}
+	obj.procs[0].deque.push(own)
+	obj.inbox.push(shared)
+	obj.procs[2].deque.push(stolen1)
+	obj.procs[2].deque.push(stolen2)
+
+	result := []*workItem{}
+	for item := obj.procs[0].find(); item != nil; item = obj.procs[0].find() {
+		result = append(result, item)
+	}
+
+	assert.Equal(t, []*workItem{own, shared, stolen1, stolen2}, result)
+}
+
+func TestStealingWorkerSpawn(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewStealingWorker(runner, 4)
+	require.NoError(t, obj.Call(6))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 127, result)
+	ids := 0
+	runner.ids.Range(func(key, value interface{}) bool {
+		ids++
+		return true
+	})
+	assert.Greater(t, ids, 1)
+}
+
+func TestStealingWorkerIntegrateCalls(t *testing.T) {
+	runner := &shardRunner{}
+	obj := NewStealingWorker(runner, 4)
+	expected := 0
+	for i := 0; i < 100; i++ {
+		require.NoError(t, obj.Call(i))
+		expected += 2*i + 100
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.Equal(t, int32(0), runner.shared)
+}
+
+func TestStealingWorkerWaitFromIntegrate(t *testing.T) {
+	runner := &integratorRunner{ids: map[uint64]bool{}}
+	obj := NewStealingWorker(runner, 2)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, result)
+	for _, err := range runner.errs {
+		assert.Same(t, ErrWouldDeadlock, err)
+	}
+}
+
+func TestStealingWorkerCallAsync(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", mock.Anything, &Result{Result: "result"})
+	runner.On("Result").Return("final")
+	obj := NewStealingWorker(runner, 2).(AsyncWorker)
+
+	cr, err := obj.CallAsync("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, cr)
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+	result, err := obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "final", result)
+	runner.AssertExpectations(t)
+}
+
+func TestStealingWorkerWaitNew(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	obj := NewStealingWorker(runner, 2)
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	assert.Same(t, ErrClosed, obj.Call("data"))
+	runner.AssertExpectations(t)
+}
+
+func TestStealingWorkerRepanic(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewStealingWorker(runner, 1, WithRepanic())
+	require.NoError(t, obj.Call("data"))
+
+	defer func() {
+		panicData := recover()
+		require.IsType(t, &PanicError{}, panicData)
+		assert.Equal(t, "boom", panicData.(*PanicError).Value)
+		assert.Equal(t, "NewStealingWorker", panicData.(*PanicError).Source)
+	}()
+	obj.Wait()
+}
+
+func TestStealProcEmit(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &stealProc{
+		worker: &stealingWorker{
+			runner: runner,
+			serial: &sync.Mutex{},
+		},
+	}
+	runner.On("Integrate", obj, &Result{Result: "page", Partial: true})
+
+	obj.emit("page")
+
+	runner.AssertExpectations(t)
+}
+
+func TestStealProcWorkStream(t *testing.T) {
+	runner := &pageRunner{next: map[int]int{}}
+	w := &stealingWorker{
+		runner:  runner,
+		serial:  &sync.Mutex{},
+		pending: &sync.WaitGroup{},
+		idle:    newIdleTracker(),
+	}
+	obj := &stealProc{worker: w}
+	w.pending.Add(1)
+	w.idle.add()
+
+	obj.work(&workItem{data: 2})
+
+	assert.Equal(t, 2, runner.pages)
+	assert.Equal(t, map[int]int{2: 2}, runner.next)
+	assert.Equal(t, 0, runner.bad)
+}
+
+func TestStealProcWorkLocal(t *testing.T) {
+	runner := &localRunner{}
+	w := &stealingWorker{
+		runner:  runner,
+		serial:  &sync.Mutex{},
+		pending: &sync.WaitGroup{},
+		idle:    newIdleTracker(),
+	}
+	obj := &stealProc{worker: w, local: newWorkerLocal(runner)}
+	for i := 1; i <= 2; i++ {
+		w.pending.Add(1)
+		w.idle.add()
+	}
+
+	obj.work(&workItem{data: 1})
+	obj.work(&workItem{data: 2})
+
+	assert.Equal(t, 3, runner.sum)
+	assert.True(t, obj.local.ready)
+	assert.Equal(t, 1, runner.setups)
+	assert.Equal(t, 0, runner.teardowns)
+}
+
+func TestStealingWorkerLocal(t *testing.T) {
+	runner := &localRunner{}
+	obj := NewStealingWorker(runner, 2)
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, obj.Call(i))
+	}
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 55, result)
+	assert.Equal(t, int32(0), runner.shared)
+	assert.LessOrEqual(t, runner.setups, 2)
+	assert.Equal(t, runner.setups, runner.teardowns)
+	for _, p := range obj.(*stealingWorker).procs {
+		assert.False(t, p.local.ready)
+	}
+}
+
+func TestStealingWorkerImplementsIdleWorker(t *testing.T) {
+	assert.Implements(t, (*IdleWorker)(nil), &stealingWorker{})
+}
+
+func TestStealProcImplementsIdleWorker(t *testing.T) {
+	assert.Implements(t, (*IdleWorker)(nil), &stealProc{})
+}
+
+func TestStealingWorkerWaitIdleActive(t *testing.T) {
+	obj := &stealingWorker{
+		active: newGoroutineMarker(),
+		idle:   newIdleTracker(),
+	}
+	var err error
+
+	obj.active.run(func() {
+		err = obj.WaitIdle(context.Background())
+	})
+
+	assert.Same(t, ErrWouldDeadlock, err)
+}
+
+func TestStealingWorkerIdle(t *testing.T) {
+	obj := &stealingWorker{idle: newIdleTracker()}
+	obj.idle.add()
+
+	result := obj.Idle()
+
+	assert.False(t, isClosed(result))
+	obj.idle.done()
+	assert.True(t, isClosed(result))
+}
+
+func TestStealingWorkerFlushSpawned(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewStealingWorker(runner, 4)
+	require.NoError(t, obj.Call(4))
+
+	err := obj.(IdleWorker).Flush()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 31, runner.count)
+	assert.NoError(t, obj.Call(3))
+	obj.Wait()
+}
+
+func TestStealProcWaitIdle(t *testing.T) {
+	obj := &stealProc{}
+
+	err := obj.WaitIdle(context.Background())
+
+	assert.Same(t, ErrWouldDeadlock, err)
+}
+
+func TestStealingWorkerWaitIdle(t *testing.T) {
+	runner := &flushRunner{gate: make(chan struct{})}
+	obj := NewStealingWorker(runner, 1).(IdleWorker)
+	require.NoError(t, obj.Call("data"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := obj.WaitIdle(ctx)
+
+	assert.Same(t, context.Canceled, err)
+	close(runner.gate)
+	assert.NoError(t, obj.Flush())
+	obj.Wait()
+}
+
+func TestStealingWorkerWaitIdleDeadlock(t *testing.T) {
+	runner := &flushRunner{}
+	obj := NewStealingWorker(runner, 1)
+	require.NoError(t, obj.Call("data"))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, []error{ErrWouldDeadlock}, runner.errs)
+	assert.Equal(t, []bool{false}, runner.idles)
+}
+
+func TestStealingWorkerImplementsClosableWorker(t *testing.T) {
+	assert.Implements(t, (*ClosableWorker)(nil), &stealingWorker{})
+}
+
+func TestStealProcImplementsClosableWorker(t *testing.T) {
+	assert.Implements(t, (*ClosableWorker)(nil), &stealProc{})
+}
+
+func TestStealingWorkerCloseFromIntegrate(t *testing.T) {
+	runner := &closeRunner{closeAt: 3}
+	obj := NewStealingWorker(runner, 2).(ClosableWorker)
+	require.NoError(t, obj.Call(3))
+
+	<-obj.Done()
+
+	assert.Equal(t, 6, runner.total)
+	assert.Equal(t, []error{nil, nil, nil}, runner.errs)
+	assert.Same(t, ErrClosed, obj.Call(1))
+}
+
+func TestStealingWorkerCloseNew(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Result").Return("result")
+	obj := NewStealingWorker(runner, 2).(ClosableWorker)
+
+	obj.Close()
+
+	<-obj.Done()
+	result, err := obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "result", result)
+	runner.AssertExpectations(t)
+}
+
+func TestStealingWorkerCloseFromRun(t *testing.T) {
+	gate := make(chan struct{})
+	errs := make(chan error, 1)
+	var obj ClosableWorker
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return("result").Run(func(args mock.Arguments) {
+		<-gate
+		errs <- obj.Call("more")
+	})
+	runner.On("Integrate", mock.Anything, &Result{Result: "result"})
+	runner.On("Result").Return("done")
+	obj = NewStealingWorker(runner, 2).(ClosableWorker)
+	require.NoError(t, obj.Call("data"))
+
+	obj.Close()
+
+	close(gate)
+	assert.Same(t, ErrClosed, <-errs)
+	<-obj.Done()
+	runner.AssertExpectations(t)
+}
+
+func TestStealingWorkerName(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewStealingWorker(runner, 1, WithName("fetch")).(AsyncWorker)
+	cr, err := obj.CallAsync("data")
+	require.NoError(t, err)
+
+	result := cr.Wait()
+
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "NewStealingWorker", result.PanicError.Source)
+	assert.Equal(t, "fetch", result.PanicError.Name)
+	obj.Wait()
+	runner.AssertExpectations(t)
+}
+
+func TestNewStealingWorkerUnsupportedOption(t *testing.T) {
+	assert.PanicsWithError(t, "Option not supported: NewStealingWorker does not support WithCheckpoint", func() {
+		NewStealingWorker(&MockRunner{}, 2, WithCheckpoint(&MockCheckpointStore{}, JSONCodec{}, 0))
+	})
+}
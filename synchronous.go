@@ -14,7 +14,10 @@
 
 package parallelizer
 
-import "container/list"
+import (
+	"container/list"
+	"context"
+)
 
 // synchronousWorker is an implementation of the Worker interface that
 // operates in a synchronous fashion; that is, there are no goroutines
@@ -23,23 +26,51 @@ import "container/list"
 // parallelization is intended to be optional, such as when ordering
 // may be important.
 type synchronousWorker struct {
-	state   pState      // State of the worker
-	runner  Runner      // The runner to be invoked by the workers
-	queue   *list.List  // A queue of submitted work items
-	running bool        // A flag indicating that Call is running
-	result  interface{} // The result that came from calling Runner.Result
+	state   pState       // State of the worker
+	runner  Runner       // The runner to be invoked by the workers
+	queue   *list.List   // A queue of submitted work items
+	running bool         // A flag indicating that Call is running
+	result  interface{}  // The result that came from calling Runner.Result
+	dedup   *deduper     // Optional duplicate suppression
+	memo    *memoizer    // Optional result cache
+	calls   int64        // Number of items accepted by Call
+	leak    *leakRecord  // Leak tracking record
+	repanic *repanicker  // Optional re-raising of panics in Wait
+	name    string       // Name given with WithName
+	shards  *shardSet    // Shards for a ShardedRunner
+	local   *workerLocal // Worker-local state for a LocalRunner
+	idle    *idleTracker // Tracks the queue for IdleWorker
+	done    *completion  // Signals completion for ClosableWorker
 }
 
 // NewSynchronousWorker constructs a synchronous worker.  Synchronous
 // workers do not utilize parallelism at all; they are provided to
 // allow for transition from a single-threaded algorithm to a
 // multithreaded one, or to enable optional parallelization in cases
-// where ordering may be important for certain invocations.
-func NewSynchronousWorker(runner Runner) Worker {
-	return &synchronousWorker{
-		runner: runner,
-		queue:  &list.List{},
+// where ordering may be important for certain invocations.  Of the
+// options, only WithDedup, WithDedupMemory, WithCache, WithRepanic,
+// and WithName are supported; any other panics with an error wrapping
+// ErrUnsupportedOption.  The returned Worker also implements
+// AsyncWorker, StatsWorker, IdleWorker, and ClosableWorker.
+func NewSynchronousWorker(runner Runner, opts ...Option) Worker {
+	checkRunner(runner)
+	o := mustOptions("NewSynchronousWorker", optDedup|optDedupMemory|optCache|optRepanic|optName, opts)
+
+	w := &synchronousWorker{
+		runner:  runner,
+		queue:   &list.List{},
+		dedup:   newDeduper(o),
+		memo:    newMemoizer(o),
+		repanic: newRepanicker(o),
+		name:    o.name,
+		shards:  newShardSet(runner),
+		local:   newWorkerLocal(runner),
+		idle:    newIdleTracker(),
+		done:    newCompletion(),
 	}
+	w.leak = trackLeaks("NewSynchronousWorker", w)
+
+	return w
 }
 
 // run is a helper that runs the items on the queue.
@@ -49,18 +80,61 @@ func (w *synchronousWorker) run() {
 		elem := w.queue.Front()
 		w.queue.Remove(elem)
 
+		// Check the cache
+		item := elem.Value.(*workItem)
+		var key interface{}
+		var result *Result
+		if w.memo != nil {
+			key, result = w.memo.get(item.data)
+		}
+
 		// Run the runner with that data
-		result := panicer(w.runner.Run, elem.Value)
+		if result == nil {
+			result = nameResult(runItem("NewSynchronousWorker", w.runner, runHooks{
+				local: w.local,
+				spawn: w.spawn,
+				emit:  w.emit,
+			}, item.data), w.name)
+			if w.memo != nil {
+				w.memo.put(key, result)
+			}
+		}
 
 		// Integrate the results
+		w.repanic.record(result)
+		w.integrate(result)
+		item.resolve(result)
+		if w.dedup != nil {
+			w.dedup.release(item, result)
+		}
+	}
+}
+
+// integrate passes a result to Runner.Integrate, or to
+// ShardedRunner.IntegrateShard.
+func (w *synchronousWorker) integrate(result *Result) {
+	if w.shards != nil {
+		w.shards.integrate(w, result, nil)
+	} else {
 		w.runner.Integrate(w, result)
 	}
 }
 
-// Call is the method used to submit data to be worked in a call to
-// the Runner.Run method.  It may return an error if the worker has
-// been shut down through a call to Wait.
-func (w *synchronousWorker) Call(data interface{}) error {
+// emit integrates a partial result emitted by
+// StreamingRunner.RunStreaming.
+func (w *synchronousWorker) emit(result interface{}) {
+	w.integrate(&Result{Result: result, Partial: true})
+}
+
+// spawn submits an item spawned by SpawningRunner.RunWithSpawner.
+// Since the queue is being run, the item is simply queued.
+func (w *synchronousWorker) spawn(data interface{}) {
+	w.call(&workItem{data: data, spawn: true}) // cannot error while running
+}
+
+// call is a helper for Call and CallAsync that submits a work item
+// and, if not already running, runs the queue.
+func (w *synchronousWorker) call(item *workItem) error {
 	// Check the worker state
 	switch w.state {
 	case pNew:
@@ -70,12 +144,20 @@ func (w *synchronousWorker) Call(data interface{}) error {
 		// Accept recursive Calls even when closed, so we work
 		// all items
 		if !w.running {
+			w.leak.markClosedCall()
 			return ErrClosed
 		}
 	}
 
+	w.calls++
+
+	// Suppress duplicates
+	if w.dedup != nil && !w.dedup.claim(item) {
+		return nil
+	}
+
 	// Enqueue the data
-	w.queue.PushBack(data)
+	w.queue.PushBack(item)
 
 	// If we're running, avoid recursion and allow the outside
 	// Call to do it all
@@ -83,35 +165,154 @@ func (w *synchronousWorker) Call(data interface{}) error {
 		return nil
 	}
 	w.running = true
+	w.idle.add()
 
 	// Run the queue
 	w.run()
 
 	// Done running
 	w.running = false
+	w.idle.done()
+
+	// Finish up if Close was called while running
+	if w.state == pClosed {
+		w.finish()
+	}
 
 	return nil
 }
 
+// finish is a helper for Wait and Close that retrieves the result,
+// then signals completion.
+func (w *synchronousWorker) finish() {
+	w.local.close()
+	if w.shards != nil {
+		w.shards.merge()
+	}
+	w.result = w.runner.Result()
+	w.state = pResult
+
+	w.done.fire(w.result, w.repanic.first())
+}
+
+// Call is the method used to submit data to be worked in a call to
+// the Runner.Run method.  It may return an error if the worker has
+// been shut down through a call to Wait.
+func (w *synchronousWorker) Call(data interface{}) error {
+	return w.call(&workItem{data: data})
+}
+
+// CallAsync is a variant of Call that also returns a CallResult
+// object, which may be used to wait for the result of calling the
+// Runner.Run method with the data.  The result is still passed to
+// Runner.Integrate as usual; the CallResult will not receive the
+// result until Runner.Integrate has returned.  Note that giving up on
+// waiting via ContextCallResult.WaitContext does not prevent the
+// data from being processed.
+func (w *synchronousWorker) CallAsync(data interface{}) (CallResult, error) {
+	item, cr := newAsyncItem(data)
+	if err := w.call(item); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
 // Wait is called to shut down the worker and return the final result;
 // it will block the caller until all data has been processed and all
 // worker goroutines have stopped.  Note that the final result,
 // generated by Runner.Result, is saved by Worker to satisfy later
 // calls to Wait.  If Wait is called before any calls to Call, the
 // worker will go straight to a stopped state, and no further Call
-// calls may be made; no error will be returned in that case.
+// calls may be made; no error will be returned in that case.  If the
+// WithRepanic option was used and any call to Runner.Run panicked,
+// Wait panics with the *PanicError describing the first such panic.
 func (w *synchronousWorker) Wait() (interface{}, error) {
 	// Detect deadlocks
 	if w.running {
 		return nil, ErrWouldDeadlock
 	}
+	w.leak.markWaited()
 
 	// Check the worker state
 	switch w.state {
 	case pNew, pRunning, pClosed: // Get the result
-		w.result = w.runner.Result()
-		w.state = pResult
+		w.finish()
 	}
+	w.repanic.check()
 
 	return w.result, nil
 }
+
+// Close shuts down the worker like Wait, but without waiting for it to
+// finish.  No further calls to Call may be made, save from Runner.Run
+// and Runner.Integrate.  If Close is called from Runner.Run or
+// Runner.Integrate, the data already submitted continues to be
+// processed, and Runner.Result is called once it has been;
+// otherwise, Runner.Result is called immediately.
+func (w *synchronousWorker) Close() {
+	w.leak.markWaited()
+
+	switch w.state {
+	case pNew, pRunning:
+		w.state = pClosed
+		if !w.running {
+			w.finish()
+		}
+	}
+}
+
+// Done returns a channel that is closed once Runner.Result has been
+// called and its result saved.
+func (w *synchronousWorker) Done() <-chan struct{} {
+	return w.done.channel()
+}
+
+// OnComplete registers a function to be called with the final result
+// and error once Runner.Result has been called.  If the result has
+// already been computed, the function is called immediately.
+func (w *synchronousWorker) OnComplete(fn func(result interface{}, err error)) {
+	w.done.onComplete(fn)
+}
+
+// Flush blocks until the worker is idle; that is, until every data
+// item accepted by Call has been passed to Runner.Integrate.  Unlike
+// Wait, it leaves the worker open for further calls to Call.  It is
+// equivalent to WaitIdle with a background context.
+func (w *synchronousWorker) Flush() error {
+	return w.WaitIdle(context.Background())
+}
+
+// WaitIdle is a variant of Flush that gives up waiting when the
+// context is canceled or its deadline expires, returning the
+// context's error.  If WaitIdle is called from Runner.Run or
+// Runner.Integrate, it returns ErrWouldDeadlock.
+func (w *synchronousWorker) WaitIdle(ctx context.Context) error {
+	// Detect deadlocks
+	if w.running {
+		return ErrWouldDeadlock
+	}
+
+	// Call runs the queue to completion, so we're idle
+	return nil
+}
+
+// Idle returns a channel that is closed once the worker is idle.  If
+// the worker is idle when Idle is called, the channel is already
+// closed.
+func (w *synchronousWorker) Idle() <-chan struct{} {
+	return w.idle.channel()
+}
+
+// Stats returns a snapshot of the statistics for the worker.
+func (w *synchronousWorker) Stats() Stats {
+	stats := Stats{Calls: w.calls}
+	if w.dedup != nil {
+		stats.Duplicates = w.dedup.count()
+	}
+	if w.memo != nil {
+		stats.CacheHits = w.memo.count()
+	}
+
+	return stats
+}
@@ -16,16 +16,30 @@ package parallelizer
 
 import (
 	"container/list"
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSynchronousWorkerImplementsWorker(t *testing.T) {
 	assert.Implements(t, (*Worker)(nil), &synchronousWorker{})
 }
 
+func TestSynchronousWorkerImplementsAsyncWorker(t *testing.T) {
+	assert.Implements(t, (*AsyncWorker)(nil), &synchronousWorker{})
+}
+
+func TestSynchronousWorkerImplementsStatsWorker(t *testing.T) {
+	assert.Implements(t, (*StatsWorker)(nil), &synchronousWorker{})
+}
+
+func TestSynchronousWorkerImplementsIdleWorker(t *testing.T) {
+	assert.Implements(t, (*IdleWorker)(nil), &synchronousWorker{})
+}
+
 func TestNewSynchronousWorker(t *testing.T) {
 	runner := &MockRunner{}
 
@@ -34,16 +48,34 @@ func TestNewSynchronousWorker(t *testing.T) {
 	assert.Equal(t, &synchronousWorker{
 		runner: runner,
 		queue:  &list.List{},
+		idle:   newIdleTracker(),
+		done:   newCompletion(),
 	}, result)
 }
 
+func TestNewSynchronousWorkerOptions(t *testing.T) {
+	runner := &MockRunner{}
+
+	result := NewSynchronousWorker(runner, WithDedup(identityKey))
+
+	obj, ok := result.(*synchronousWorker)
+	require.True(t, ok)
+	assert.NotNil(t, obj.dedup)
+}
+
+func TestNewSynchronousWorkerSpawningStreaming(t *testing.T) {
+	assert.PanicsWithValue(t, ErrSpawningStreaming, func() {
+		NewSynchronousWorker(&spawnStreamRunner{})
+	})
+}
+
 func TestSynchronousWorkerRun(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &synchronousWorker{
 		runner: runner,
 		queue:  &list.List{},
 	}
-	obj.queue.PushBack("value")
+	obj.queue.PushBack(&workItem{data: "value"})
 	runner.On("Run", "value").Return("result")
 	runner.On("Integrate", obj, &Result{Result: "result"})
 
@@ -53,6 +85,57 @@ func TestSynchronousWorkerRun(t *testing.T) {
 	runner.AssertExpectations(t)
 }
 
+func TestSynchronousWorkerRunResolves(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &synchronousWorker{
+		runner: runner,
+		queue:  &list.List{},
+	}
+	item, cr := newAsyncItem("value")
+	obj.queue.PushBack(item)
+	runner.On("Run", "value").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"}).Run(func(args mock.Arguments) {
+		result, ok := cr.TryWait()
+		assert.Nil(t, result)
+		assert.True(t, ok)
+	})
+
+	obj.run()
+
+	assert.Equal(t, 0, obj.queue.Len())
+	result, ok := cr.TryWait()
+	assert.True(t, ok)
+	assert.Equal(t, &Result{Result: "result"}, result)
+	runner.AssertExpectations(t)
+}
+
+func TestSynchronousWorkerRunCached(t *testing.T) {
+	runner := &MockRunner{}
+	cache := NewLRUCache(0, 0)
+	cache.Put("value", "cached")
+	obj := &synchronousWorker{
+		runner: runner,
+		queue:  &list.List{},
+		memo: &memoizer{
+			cache: cache,
+			key:   identityKey,
+		},
+	}
+	obj.queue.PushBack(&workItem{data: "value"})
+	obj.queue.PushBack(&workItem{data: "other"})
+	runner.On("Run", "other").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "cached", Cached: true})
+	runner.On("Integrate", obj, &Result{Result: "result"})
+
+	obj.run()
+
+	value, ok := cache.Get("other")
+	assert.True(t, ok)
+	assert.Equal(t, "result", value)
+	assert.Equal(t, Stats{CacheHits: 1}, obj.Stats())
+	runner.AssertExpectations(t)
+}
+
 func TestSynchronousWorkerCallBase(t *testing.T) {
 	runner := &MockRunner{}
 	obj := &synchronousWorker{
@@ -89,7 +172,7 @@ func TestSynchronousWorkerCallRunning(t *testing.T) {
 	assert.Equal(t, pRunning, obj.state)
 	assert.True(t, obj.running)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Equal(t, &workItem{data: "data"}, obj.queue.Front().Value)
 	runner.AssertExpectations(t)
 }
 
@@ -162,7 +245,7 @@ func TestSynchronousWorkerCallClosedRunning(t *testing.T) {
 	assert.Equal(t, pClosed, obj.state)
 	assert.True(t, obj.running)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Equal(t, &workItem{data: "data"}, obj.queue.Front().Value)
 	runner.AssertExpectations(t)
 }
 
@@ -181,7 +264,64 @@ func TestSynchronousWorkerCallResultRunning(t *testing.T) {
 	assert.Equal(t, pResult, obj.state)
 	assert.True(t, obj.running)
 	assert.Equal(t, 1, obj.queue.Len())
-	assert.Equal(t, "data", obj.queue.Front().Value)
+	assert.Equal(t, &workItem{data: "data"}, obj.queue.Front().Value)
+	runner.AssertExpectations(t)
+}
+
+func TestSynchronousWorkerCallDedup(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &synchronousWorker{
+		state:  pRunning,
+		runner: runner,
+		queue:  &list.List{},
+		dedup:  newDeduper(&options{dedupKey: identityKey}),
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"}).Run(func(args mock.Arguments) {
+		assert.NoError(t, obj.Call("data"))
+	})
+
+	err := obj.Call("data")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, obj.queue.Len())
+	assert.Equal(t, Stats{Calls: 2, Duplicates: 1}, obj.Stats())
+	assert.NotContains(t, obj.dedup.inflight, "data")
+	runner.AssertExpectations(t)
+}
+
+func TestSynchronousWorkerCallAsyncBase(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &synchronousWorker{
+		state:  pRunning,
+		runner: runner,
+		queue:  &list.List{},
+	}
+	runner.On("Run", "data").Return("result")
+	runner.On("Integrate", obj, &Result{Result: "result"})
+
+	cr, err := obj.CallAsync("data")
+
+	assert.NoError(t, err)
+	require.NotNil(t, cr)
+	assert.Equal(t, &Result{Result: "result"}, cr.Wait())
+	assert.Equal(t, pRunning, obj.state)
+	runner.AssertExpectations(t)
+}
+
+func TestSynchronousWorkerCallAsyncClosed(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &synchronousWorker{
+		state:  pClosed,
+		runner: runner,
+		queue:  &list.List{},
+	}
+
+	cr, err := obj.CallAsync("data")
+
+	assert.Same(t, ErrClosed, err)
+	assert.Nil(t, cr)
+	assert.Equal(t, 0, obj.queue.Len())
 	runner.AssertExpectations(t)
 }
 
@@ -263,3 +403,198 @@ func TestSynchronousWorkerWaitDeadlock(t *testing.T) {
 	assert.Nil(t, result)
 	assert.Equal(t, pRunning, obj.state)
 }
+
+func TestSynchronousWorkerRepanic(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewSynchronousWorker(runner, WithRepanic())
+	require.NoError(t, obj.Call("data"))
+
+	defer func() {
+		panicData := recover()
+		require.IsType(t, &PanicError{}, panicData)
+		err := panicData.(*PanicError)
+		assert.Equal(t, "boom", err.Value)
+		assert.Equal(t, "data", err.Data)
+		assert.Equal(t, "NewSynchronousWorker", err.Source)
+		runner.AssertExpectations(t)
+	}()
+	obj.Wait()
+	assert.Fail(t, "Wait did not panic")
+}
+
+func TestSynchronousWorkerEmit(t *testing.T) {
+	runner := &MockRunner{}
+	obj := &synchronousWorker{runner: runner}
+	runner.On("Integrate", obj, &Result{Result: "page", Partial: true})
+
+	obj.emit("page")
+
+	runner.AssertExpectations(t)
+}
+
+func TestSynchronousWorkerStream(t *testing.T) {
+	runner := &pageRunner{next: map[int]int{}}
+	obj := NewSynchronousWorker(runner)
+	require.NoError(t, obj.Call(2))
+	require.NoError(t, obj.Call(3))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	assert.Equal(t, map[int]int{2: 2, 3: 3}, runner.next)
+	assert.Equal(t, 0, runner.bad)
+}
+
+func TestSynchronousWorkerSpawn(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewSynchronousWorker(runner)
+	require.NoError(t, obj.Call(4))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 31, result)
+}
+
+func TestNewSynchronousWorkerLocal(t *testing.T) {
+	runner := &localRunner{}
+
+	result := NewSynchronousWorker(runner)
+
+	obj := result.(*synchronousWorker)
+	assert.Equal(t, &workerLocal{runner: runner}, obj.local)
+}
+
+func TestSynchronousWorkerLocal(t *testing.T) {
+	runner := &localRunner{}
+	obj := NewSynchronousWorker(runner)
+	require.NoError(t, obj.Call(1))
+	require.NoError(t, obj.Call("panic"))
+	require.NoError(t, obj.Call(2))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result)
+	assert.Equal(t, 2, runner.setups)
+	assert.Equal(t, 2, runner.teardowns)
+	assert.False(t, obj.(*synchronousWorker).local.ready)
+}
+
+func TestSynchronousWorkerWaitIdleRunning(t *testing.T) {
+	obj := &synchronousWorker{running: true}
+
+	err := obj.WaitIdle(context.Background())
+
+	assert.Same(t, ErrWouldDeadlock, err)
+}
+
+func TestSynchronousWorkerWaitIdleNotRunning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := &synchronousWorker{}
+
+	err := obj.WaitIdle(ctx)
+
+	assert.NoError(t, err)
+}
+
+func TestSynchronousWorkerCallIdle(t *testing.T) {
+	runner := &treeRunner{}
+	obj := NewSynchronousWorker(runner)
+
+	err := obj.Call(4)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 31, runner.count)
+	assert.True(t, isClosed(obj.(IdleWorker).Idle()))
+	obj.Wait()
+}
+
+func TestSynchronousWorkerWaitIdleDeadlock(t *testing.T) {
+	runner := &flushRunner{}
+	obj := NewSynchronousWorker(runner)
+	require.NoError(t, obj.Call("data"))
+
+	result, err := obj.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, []error{ErrWouldDeadlock}, runner.errs)
+	assert.Equal(t, []bool{false}, runner.idles)
+}
+
+func TestSynchronousWorkerImplementsClosableWorker(t *testing.T) {
+	assert.Implements(t, (*ClosableWorker)(nil), &synchronousWorker{})
+}
+
+func TestSynchronousWorkerCloseRunning(t *testing.T) {
+	obj := &synchronousWorker{
+		state:   pRunning,
+		running: true,
+		done:    newCompletion(),
+	}
+
+	obj.Close()
+
+	assert.Equal(t, pClosed, obj.state)
+	assert.False(t, isClosed(obj.Done()))
+}
+
+func TestSynchronousWorkerCloseFromIntegrate(t *testing.T) {
+	runner := &closeRunner{closeAt: 3}
+	obj := NewSynchronousWorker(runner).(ClosableWorker)
+
+	err := obj.Call(3)
+
+	assert.NoError(t, err)
+	assert.True(t, isClosed(obj.Done()))
+	assert.Equal(t, 6, runner.total)
+	assert.Equal(t, []error{nil, nil, nil}, runner.errs)
+	assert.Same(t, ErrClosed, obj.Call(1))
+}
+
+func TestSynchronousWorkerCloseIdle(t *testing.T) {
+	runner := &closeRunner{closeAt: -1}
+	obj := NewSynchronousWorker(runner).(ClosableWorker)
+	require.NoError(t, obj.Call(2))
+
+	obj.Close()
+
+	assert.True(t, isClosed(obj.Done()))
+	result, err := obj.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result)
+}
+
+func TestSynchronousWorkerName(t *testing.T) {
+	runner := &MockRunner{}
+	runner.On("Run", "data").Return(nil).Run(func(args mock.Arguments) {
+		panic("boom")
+	})
+	runner.On("Integrate", mock.Anything, mock.Anything)
+	runner.On("Result").Return("result")
+	obj := NewSynchronousWorker(runner, WithName("fetch")).(AsyncWorker)
+	cr, err := obj.CallAsync("data")
+	require.NoError(t, err)
+
+	result := cr.Wait()
+
+	require.NotNil(t, result.PanicError)
+	assert.Equal(t, "NewSynchronousWorker", result.PanicError.Source)
+	assert.Equal(t, "fetch", result.PanicError.Name)
+	obj.Wait()
+	runner.AssertExpectations(t)
+}
+
+func TestNewSynchronousWorkerUnsupportedOption(t *testing.T) {
+	assert.PanicsWithError(t, "Option not supported: NewSynchronousWorker does not support WithPool", func() {
+		NewSynchronousWorker(&MockRunner{}, WithPool(0))
+	})
+}
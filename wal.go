@@ -0,0 +1,466 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SyncPolicy describes when a durable queue forces its log to stable
+// storage.
+type SyncPolicy int
+
+// Available sync policies for NewDurableGoWorker.
+const (
+	// SyncAlways causes the log to be synced before each call to
+	// Worker.Call returns, so that an accepted data item survives
+	// an operating system crash or power loss.
+	SyncAlways SyncPolicy = iota
+
+	// SyncNone leaves flushing the log to the operating system.
+	// An accepted data item survives a crash of the process, but
+	// not necessarily of the operating system.
+	SyncNone
+)
+
+// Log record types and sizes.
+const (
+	walItem byte = iota + 1 // Record of an accepted data item
+	walAck                  // Record of an integrated data item
+
+	walHeaderSize   = 17       // type(1) + id(8) + length(4) + crc(4)
+	walSegmentSize  = 16 << 20 // Default size at which to start a new segment
+	walSegmentExt   = ".wal"   // Extension of segment files
+	walSegmentDigit = 16       // Number of hex digits in segment names
+)
+
+// ErrCorruptLog is returned by NewDurableGoWorker if a log segment
+// other than the most recent one cannot be read.  Damage to the end
+// of the most recent segment, such as is caused by a crash in the
+// middle of a write, is repaired automatically.
+var ErrCorruptLog = errors.New("Durable queue log is corrupt")
+
+// walSegment describes one segment file of the log.
+type walSegment struct {
+	seq         uint64 // Sequence number of the segment
+	path        string // Path to the segment file
+	outstanding int    // Number of items not yet acknowledged
+}
+
+// walPending describes an unacknowledged data item found when the
+// log is opened.
+type walPending struct {
+	id   uint64      // Identifier of the item
+	data interface{} // The decoded data item
+}
+
+// walQueue is an append-only log of accepted data items, divided into
+// segment files.  Each item is appended when it is accepted and
+// acknowledged once it has been integrated; segments are deleted once
+// all the items they contain have been acknowledged.
+type walQueue struct {
+	sync.Mutex
+	dir      string                 // Directory containing the segments
+	codec    Codec                  // Codec for the data items
+	policy   SyncPolicy             // When to sync the log
+	maxSize  int64                  // Size at which to start a new segment
+	segments []*walSegment          // Segments, oldest first
+	file     *os.File               // Current segment file, if open
+	size     int64                  // Size of the current segment
+	nextID   uint64                 // Identifier for the next item
+	items    map[uint64]*walSegment // Segments of outstanding items
+	err      error                  // First error writing an ack
+}
+
+// segmentPath computes the path of the segment file with the
+// specified sequence number.
+func (q *walQueue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%0*x%s", walSegmentDigit, seq, walSegmentExt))
+}
+
+// openWAL opens the log in the specified directory, creating the
+// directory if necessary.  It returns the log and the items that
+// were not acknowledged, in the order they were accepted.
+func openWAL(dir string, codec Codec, policy SyncPolicy) (*walQueue, []walPending, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	q := &walQueue{
+		dir:     dir,
+		codec:   codec,
+		policy:  policy,
+		maxSize: walSegmentSize,
+		nextID:  1,
+		items:   map[uint64]*walSegment{},
+	}
+
+	// Find the existing segments
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, walSegmentExt), "%x", &seq); err != nil {
+			continue
+		}
+		q.segments = append(q.segments, &walSegment{
+			seq:  seq,
+			path: filepath.Join(dir, name),
+		})
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].seq < q.segments[j].seq
+	})
+
+	// Replay them
+	pending := map[uint64][]byte{}
+	for i, seg := range q.segments {
+		if err := q.replay(seg, i == len(q.segments)-1, pending); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Decode the outstanding items
+	result := make([]walPending, 0, len(pending))
+	for id, data := range pending {
+		value, err := codec.Decode(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, walPending{id: id, data: value})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+
+	q.compact()
+
+	return q, result, nil
+}
+
+// replay reads the records of a segment, accumulating the payloads of
+// the outstanding items.  If the segment is the last one, a damaged
+// tail is truncated away; otherwise, damage results in
+// ErrCorruptLog.
+func (q *walQueue) replay(seg *walSegment, last bool, pending map[uint64][]byte) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		typ, id, payload, err := readWALRecord(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			if !last {
+				return ErrCorruptLog
+			}
+			return os.Truncate(seg.path, offset)
+		}
+		offset += int64(walHeaderSize + len(payload))
+
+		switch typ {
+		case walItem:
+			pending[id] = payload
+			q.items[id] = seg
+			seg.outstanding++
+			if id >= q.nextID {
+				q.nextID = id + 1
+			}
+
+		case walAck:
+			if owner, ok := q.items[id]; ok {
+				owner.outstanding--
+				delete(q.items, id)
+				delete(pending, id)
+			}
+		}
+	}
+}
+
+// readWALRecord reads one record from the log.  It returns io.EOF at
+// a clean end of the log, and ErrCorruptLog if the record is
+// incomplete or damaged.
+func readWALRecord(r io.Reader) (byte, uint64, []byte, error) {
+	header := make([]byte, walHeaderSize)
+	if n, err := io.ReadFull(r, header); err == io.EOF {
+		return 0, 0, nil, io.EOF
+	} else if err != nil || n != walHeaderSize {
+		return 0, 0, nil, ErrCorruptLog
+	}
+
+	typ := header[0]
+	id := binary.LittleEndian.Uint64(header[1:9])
+	length := binary.LittleEndian.Uint32(header[9:13])
+	sum := binary.LittleEndian.Uint32(header[13:17])
+	if typ != walItem && typ != walAck {
+		return 0, 0, nil, ErrCorruptLog
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, ErrCorruptLog
+	}
+	if walChecksum(header[:13], payload) != sum {
+		return 0, 0, nil, ErrCorruptLog
+	}
+
+	return typ, id, payload, nil
+}
+
+// walChecksum computes the checksum of a record.
+func walChecksum(header, payload []byte) uint32 {
+	sum := crc32.ChecksumIEEE(header)
+	return crc32.Update(sum, crc32.IEEETable, payload)
+}
+
+// encodeWALRecord encodes a record for the log.
+func encodeWALRecord(typ byte, id uint64, payload []byte) []byte {
+	buf := make([]byte, walHeaderSize+len(payload))
+	buf[0] = typ
+	binary.LittleEndian.PutUint64(buf[1:9], id)
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(payload)))
+	copy(buf[walHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(buf[13:17], walChecksum(buf[:13], payload))
+
+	return buf
+}
+
+// rotate closes the current segment, if any, and starts a new one.
+// Must be called with the lock held.
+func (q *walQueue) rotate() error {
+	if q.file != nil {
+		err := q.file.Sync()
+		if closeErr := q.file.Close(); err == nil {
+			err = closeErr
+		}
+		q.file = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	// Pick the next sequence number
+	var seq uint64
+	if len(q.segments) > 0 {
+		seq = q.segments[len(q.segments)-1].seq + 1
+	}
+	seg := &walSegment{
+		seq:  seq,
+		path: q.segmentPath(seq),
+	}
+
+	// Create the file
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if q.policy == SyncAlways {
+		if err := syncDir(q.dir); err != nil {
+			f.Close()
+			os.Remove(seg.path)
+			return err
+		}
+	}
+
+	q.segments = append(q.segments, seg)
+	q.file = f
+	q.size = 0
+	q.compact()
+
+	return nil
+}
+
+// syncDir syncs a directory, ensuring that newly created files in it
+// are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// write writes a record to the current segment, starting a new
+// segment if necessary.  If the write fails, the segment is truncated
+// to remove any partial record.  Must be called with the lock held.
+func (q *walQueue) write(rec []byte, sync bool) error {
+	if q.file == nil || q.size >= q.maxSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	_, err := q.file.Write(rec)
+	if err == nil && sync {
+		err = q.file.Sync()
+	}
+	if err != nil {
+		q.file.Truncate(q.size)
+		q.file.Seek(q.size, io.SeekStart)
+		return err
+	}
+	q.size += int64(len(rec))
+
+	return nil
+}
+
+// append durably records a work item as accepted, assigning it an
+// identifier.
+func (q *walQueue) append(item *workItem) error {
+	data, err := q.codec.Encode(item.data)
+	if err != nil {
+		return err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	id := q.nextID
+	if err := q.write(encodeWALRecord(walItem, id, data), q.policy == SyncAlways); err != nil {
+		return err
+	}
+	q.nextID++
+	item.logID = id
+	seg := q.segments[len(q.segments)-1]
+	seg.outstanding++
+	q.items[id] = seg
+
+	return nil
+}
+
+// ack records that a work item has been integrated.  Acks are not
+// synced; if an ack is lost, the item is redelivered when the log is
+// next opened.  The first error writing an ack is reported by close.
+func (q *walQueue) ack(item *workItem) {
+	q.Lock()
+	defer q.Unlock()
+
+	seg, ok := q.items[item.logID]
+	if !ok {
+		return
+	}
+	if err := q.write(encodeWALRecord(walAck, item.logID, nil), false); err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return
+	}
+	seg.outstanding--
+	delete(q.items, item.logID)
+	q.compact()
+}
+
+// compact deletes the oldest segments, other than the current one,
+// while all their items have been acknowledged.  Segments are only
+// deleted oldest first, since acks for the items in a segment may be
+// recorded in later segments.  Must be called with the lock held.
+func (q *walQueue) compact() {
+	for len(q.segments) > 0 && q.segments[0].outstanding == 0 {
+		if q.file != nil && len(q.segments) == 1 {
+			break
+		}
+		os.Remove(q.segments[0].path)
+		q.segments = q.segments[1:]
+	}
+}
+
+// close closes the log.  If all the items have been acknowledged, the
+// segment files are removed.  It returns the first error encountered
+// writing acks, or any error closing the log.
+func (q *walQueue) close() error {
+	q.Lock()
+	defer q.Unlock()
+
+	err := q.err
+	if q.file != nil {
+		syncErr := q.file.Sync()
+		if closeErr := q.file.Close(); syncErr == nil {
+			syncErr = closeErr
+		}
+		if err == nil {
+			err = syncErr
+		}
+		q.file = nil
+	}
+	if err == nil && len(q.items) == 0 {
+		q.compact()
+	}
+
+	return err
+}
+
+// NewDurableGoWorker constructs a worker like NewGoWorker, but backs
+// the worker's queue with an append-only log stored in the specified
+// directory, turning the worker into a lightweight embedded job
+// queue.  Each data item passed to Worker.Call is encoded with the
+// Codec and appended to the log before Call returns, and is
+// acknowledged in the log once Runner.Integrate has returned for it.
+// The policy controls whether the log is synced to stable storage
+// before Call returns.  If the log already contains items that were
+// not acknowledged, such as after a crash, those items are
+// redelivered to the new worker, in the order they were originally
+// accepted; delivery is therefore at-least-once.  Worker.Wait closes
+// the log, removing it if all items were acknowledged, and returns
+// any error encountered writing acknowledgements.  Only one worker
+// may use a given directory at a time.  The options supported are
+// those of NewGoWorker; passing any other returns an error wrapping
+// ErrUnsupportedOption.
+func NewDurableGoWorker(runner Runner, workers int, dir string, codec Codec, policy SyncPolicy, opts ...Option) (Worker, error) {
+	o := newOptions(opts)
+	if err := o.check("NewDurableGoWorker", goOptions); err != nil {
+		return nil, err
+	}
+
+	// Open the log
+	q, pending, err := openWAL(dir, codec, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct the worker and redeliver the outstanding items
+	w := newGoWorker(runner, workers, o)
+	w.wal = q
+	for _, p := range pending {
+		if err := w.call(&workItem{data: p.data, logID: p.id}); err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
@@ -0,0 +1,332 @@
+// Copyright (c) 2020 T-Mobile
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package parallelizer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walFiles returns the names of the segment files in a directory.
+func walFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	result := []string{}
+	for _, file := range files {
+		result = append(result, file.Name())
+	}
+
+	return result
+}
+
+func TestWALRecordRoundTrip(t *testing.T) {
+	rec := encodeWALRecord(walItem, 42, []byte("data"))
+
+	typ, id, payload, err := readWALRecord(bytes.NewReader(rec))
+
+	assert.NoError(t, err)
+	assert.Equal(t, walItem, typ)
+	assert.Equal(t, uint64(42), id)
+	assert.Equal(t, []byte("data"), payload)
+}
+
+func TestReadWALRecordEOF(t *testing.T) {
+	_, _, _, err := readWALRecord(bytes.NewReader(nil))
+
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadWALRecordShortHeader(t *testing.T) {
+	rec := encodeWALRecord(walItem, 42, []byte("data"))
+
+	_, _, _, err := readWALRecord(bytes.NewReader(rec[:5]))
+
+	assert.Same(t, ErrCorruptLog, err)
+}
+
+func TestReadWALRecordShortPayload(t *testing.T) {
+	rec := encodeWALRecord(walItem, 42, []byte("data"))
+
+	_, _, _, err := readWALRecord(bytes.NewReader(rec[:len(rec)-1]))
+
+	assert.Same(t, ErrCorruptLog, err)
+}
+
+func TestReadWALRecordBadChecksum(t *testing.T) {
+	rec := encodeWALRecord(walItem, 42, []byte("data"))
+	rec[len(rec)-1] ^= 0xff
+
+	_, _, _, err := readWALRecord(bytes.NewReader(rec))
+
+	assert.Same(t, ErrCorruptLog, err)
+}
+
+func TestReadWALRecordBadType(t *testing.T) {
+	rec := encodeWALRecord(42, 42, nil)
+
+	_, _, _, err := readWALRecord(bytes.NewReader(rec))
+
+	assert.Same(t, ErrCorruptLog, err)
+}
+
+func TestOpenWALEmpty(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "wal")
+
+	q, pending, err := openWAL(dir, JSONCodec{}, SyncAlways)
+
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+	assert.Equal(t, uint64(1), q.nextID)
+	assert.Len(t, q.segments, 0)
+	assert.NoError(t, q.close())
+	assert.Equal(t, []string{}, walFiles(t, dir))
+}
+
+func TestWALAppendAckReopen(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	items := []*workItem{{data: "a"}, {data: "b"}, {data: "c"}}
+	for _, item := range items {
+		require.NoError(t, q.append(item))
+	}
+
+	q.ack(items[1])
+	require.NoError(t, q.close())
+
+	assert.Equal(t, uint64(1), items[0].logID)
+	assert.Equal(t, uint64(2), items[1].logID)
+	assert.Equal(t, uint64(3), items[2].logID)
+	q, pending, err := openWAL(dir, JSONCodec{}, SyncNone)
+	require.NoError(t, err)
+	assert.Equal(t, []walPending{{id: 1, data: "a"}, {id: 3, data: "c"}}, pending)
+	assert.Equal(t, uint64(4), q.nextID)
+	assert.NoError(t, q.close())
+}
+
+func TestWALAppendEncodeError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", "data").Return(nil, assert.AnError)
+	q, _, err := openWAL(tempDir(t), codec, SyncAlways)
+	require.NoError(t, err)
+
+	err = q.append(&workItem{data: "data"})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Len(t, q.items, 0)
+	codec.AssertExpectations(t)
+}
+
+func TestWALAckUnknown(t *testing.T) {
+	q, _, err := openWAL(tempDir(t), JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+
+	q.ack(&workItem{logID: 42})
+
+	assert.Nil(t, q.file)
+	assert.NoError(t, q.close())
+}
+
+func TestWALRotateCompact(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncNone)
+	require.NoError(t, err)
+	q.maxSize = 1
+	items := []*workItem{{data: "a"}, {data: "b"}, {data: "c"}}
+	for _, item := range items {
+		require.NoError(t, q.append(item))
+	}
+	require.Len(t, walFiles(t, dir), 3)
+
+	q.ack(items[1])
+	q.ack(items[0])
+
+	// Segments 0 and 1 are done; segment 2 holds item c, and
+	// segments 3 and 4 hold the acks
+	assert.Equal(t, []string{
+		"0000000000000002.wal",
+		"0000000000000003.wal",
+		"0000000000000004.wal",
+	}, walFiles(t, dir))
+	q.ack(items[2])
+	assert.Equal(t, []string{"0000000000000005.wal"}, walFiles(t, dir))
+	assert.NoError(t, q.close())
+	assert.Equal(t, []string{}, walFiles(t, dir))
+}
+
+func TestOpenWALTornTail(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	require.NoError(t, q.append(&workItem{data: "a"}))
+	require.NoError(t, q.append(&workItem{data: "b"}))
+	path := q.segments[0].path
+	require.NoError(t, q.close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-2))
+
+	q, pending, err := openWAL(dir, JSONCodec{}, SyncAlways)
+
+	require.NoError(t, err)
+	assert.Equal(t, []walPending{{id: 1, data: "a"}}, pending)
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(walHeaderSize+3), info.Size())
+	assert.NoError(t, q.close())
+}
+
+func TestOpenWALCorruptSegment(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	q.maxSize = 1
+	require.NoError(t, q.append(&workItem{data: "a"}))
+	require.NoError(t, q.append(&workItem{data: "b"}))
+	path := q.segments[0].path
+	require.NoError(t, q.close())
+	require.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0644))
+
+	q, pending, err := openWAL(dir, JSONCodec{}, SyncAlways)
+
+	assert.Same(t, ErrCorruptLog, err)
+	assert.Nil(t, q)
+	assert.Nil(t, pending)
+}
+
+func TestOpenWALIgnoresOtherFiles(t *testing.T) {
+	dir := tempDir(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bogus.wal"), nil, 0644))
+
+	q, pending, err := openWAL(dir, JSONCodec{}, SyncAlways)
+
+	require.NoError(t, err)
+	assert.Len(t, pending, 0)
+	assert.Len(t, q.segments, 0)
+}
+
+func TestOpenWALDecodeError(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	require.NoError(t, q.append(&workItem{data: "a"}))
+	require.NoError(t, q.close())
+	codec := &MockCodec{}
+	codec.On("Decode", []byte(`"a"`)).Return(nil, assert.AnError)
+
+	_, _, err = openWAL(dir, codec, SyncAlways)
+
+	assert.Same(t, assert.AnError, err)
+	codec.AssertExpectations(t)
+}
+
+func TestNewDurableGoWorkerRedelivers(t *testing.T) {
+	dir := tempDir(t)
+	q, _, err := openWAL(dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	items := []*workItem{{data: 1.0}, {data: 2.0}, {data: 4.0}}
+	for _, item := range items {
+		require.NoError(t, q.append(item))
+	}
+	q.ack(items[0])
+	require.NoError(t, q.close())
+	runner := &sumRunner{}
+
+	worker, err := NewDurableGoWorker(runner, 2, dir, JSONCodec{}, SyncAlways)
+
+	require.NoError(t, err)
+	assert.NoError(t, worker.Call(8.0))
+	result, err := worker.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 14.0, result)
+	assert.Equal(t, []string{}, walFiles(t, dir))
+}
+
+func TestNewDurableGoWorkerCrash(t *testing.T) {
+	dir := tempDir(t)
+	runner1 := &sumRunner{gate: make(chan struct{})}
+	defer close(runner1.gate)
+	worker1, err := NewDurableGoWorker(runner1, 2, dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	cr, err := worker1.(AsyncWorker).CallAsync(1.0)
+	require.NoError(t, err)
+	require.NoError(t, worker1.Call(200.0))
+	cr.Wait()
+
+	// "Crash" worker1 and start over
+	runner2 := &sumRunner{}
+	worker2, err := NewDurableGoWorker(runner2, 2, dir, JSONCodec{}, SyncAlways)
+	require.NoError(t, err)
+	result, err := worker2.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200.0, result)
+}
+
+func TestNewDurableGoWorkerOpenError(t *testing.T) {
+	path := filepath.Join(tempDir(t), "file")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	worker, err := NewDurableGoWorker(&sumRunner{}, 2, path, JSONCodec{}, SyncAlways)
+
+	assert.Error(t, err)
+	assert.Nil(t, worker)
+}
+
+func TestNewDurableGoWorkerUnsupportedOption(t *testing.T) {
+	dir := tempDir(t)
+
+	worker, err := NewDurableGoWorker(&sumRunner{}, 2, dir, JSONCodec{}, SyncAlways, WithProcessCommand("true"))
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	assert.Nil(t, worker)
+}
+
+func TestNewDurableGoWorkerDedup(t *testing.T) {
+	dir := tempDir(t)
+	runner := &sumRunner{}
+	worker, err := NewDurableGoWorker(runner, 2, dir, JSONCodec{}, SyncAlways, WithDedup(identityKey), WithDedupMemory(0))
+	require.NoError(t, err)
+
+	assert.NoError(t, worker.Call(1.0))
+	assert.NoError(t, worker.Call(1.0))
+	result, err := worker.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, result)
+	assert.Equal(t, []string{}, walFiles(t, dir))
+}
+
+func TestNewDurableGoWorkerAppendError(t *testing.T) {
+	codec := &MockCodec{}
+	codec.On("Encode", "data").Return(nil, assert.AnError)
+	worker, err := NewDurableGoWorker(&MockRunner{}, 2, tempDir(t), codec, SyncAlways)
+	require.NoError(t, err)
+
+	err = worker.Call("data")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, Stats{}, worker.(StatsWorker).Stats())
+	codec.AssertExpectations(t)
+}